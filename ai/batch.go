@@ -0,0 +1,63 @@
+package ai
+
+import (
+	"context"
+	"time"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+// BatchResult is the analysis of one game by AnalyzeBatch.
+type BatchResult struct {
+	Game     *ptn.PTN
+	Position *tak.Position
+
+	PV    []tak.Move
+	Value int64
+	Stats Stats
+
+	// Err is set if the game's final position could not be
+	// reconstructed; the other fields are then unset.
+	Err error
+}
+
+// AnalyzeBatch analyzes the final position of each game in games,
+// such as those returned by ptn.ReadDir, searching each for up to
+// limit with a fresh MinimaxAI configured by cfg. cfg.Size is set
+// from each game. Games that are already over are reported without
+// a search.
+//
+// Results are sent on the returned channel in the order of games,
+// and the channel is closed after the last one, or once ctx is
+// cancelled.
+func AnalyzeBatch(ctx context.Context, games []*ptn.PTN, cfg MinimaxConfig, limit time.Duration) <-chan BatchResult {
+	out := make(chan BatchResult)
+	go func() {
+		defer close(out)
+		for _, g := range games {
+			r := BatchResult{Game: g}
+			r.Position, r.Err = g.PositionAtMove(0, tak.NoColor)
+			if r.Err == nil && !gameOver(r.Position) {
+				cfg := cfg
+				cfg.Size = r.Position.Size()
+				m := NewMinimax(cfg)
+				r.PV, r.Value, r.Stats = m.analyze(ctx, r.Position, limit, limit, nil)
+				if m.aborted && ctx.Err() != nil {
+					return
+				}
+			}
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func gameOver(p *tak.Position) bool {
+	over, _ := p.GameOver()
+	return over
+}
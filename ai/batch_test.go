@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+)
+
+func TestAnalyzeBatch(t *testing.T) {
+	var games []*ptn.PTN
+	for _, src := range []string{
+		"[Size \"5\"]\n\n1. a1 e5 2. c3\n",
+		"[Size \"5\"]\n\n1. a1 e5 2. e4 a2 3. e3 a3 4. e2 a4 5. e1\n",
+		"[Size \"5\"]\n\n1. a1 e5 2. a1+\n",
+	} {
+		g, err := ptn.ParsePTN(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		games = append(games, g)
+	}
+	var rs []BatchResult
+	for r := range AnalyzeBatch(context.Background(), games, MinimaxConfig{Depth: 2}, 0) {
+		rs = append(rs, r)
+	}
+	if len(rs) != len(games) {
+		t.Fatalf("got %d results", len(rs))
+	}
+	for i, r := range rs {
+		if r.Game != games[i] {
+			t.Errorf("%d: out of order", i)
+		}
+	}
+	if rs[0].Err != nil || len(rs[0].PV) != 2 || rs[0].Stats.Depth != 2 {
+		t.Errorf("game 0: err=%v pv=%s depth=%d", rs[0].Err, formatpv(rs[0].PV), rs[0].Stats.Depth)
+	}
+	if rs[1].Err != nil || rs[1].Position == nil || len(rs[1].PV) != 0 {
+		t.Errorf("finished game: err=%v pv=%s", rs[1].Err, formatpv(rs[1].PV))
+	}
+	if rs[2].Err == nil {
+		t.Errorf("illegal game: no error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n := 0
+	for range AnalyzeBatch(ctx, games, MinimaxConfig{Depth: 2}, 0) {
+		n++
+	}
+	if n > 1 {
+		t.Errorf("cancelled batch returned %d results", n)
+	}
+}
@@ -0,0 +1,178 @@
+package ai
+
+import (
+	"sort"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+// Book is an opening book: the move to play in each position it
+// covers, keyed by the position's ptn.CanonicalTPS. Each move is
+// stored for the canonical orientation of its position, so one
+// entry serves every rotation and reflection of it.
+type Book map[string]tak.Move
+
+// Lookup returns the book move for p, if there is one.
+func (b Book) Lookup(p *tak.Position) (tak.Move, bool) {
+	key, syms := ptn.CanonicalTPS(p)
+	m, ok := b[key]
+	if !ok {
+		return tak.Move{}, false
+	}
+	m = syms[0].Inverse().Move(m, p.Size())
+	if _, e := p.Move(&m); e != nil {
+		return tak.Move{}, false
+	}
+	return m, true
+}
+
+// BookOptions configures BuildBookWith.
+type BookOptions struct {
+	// MinGames is the number of games in which a move must have
+	// been played from a position to be considered for the book.
+	MinGames int
+	// MinScore is the least average score, for the player making
+	// it, that a move must have to enter the book. A win scores
+	// 1, a draw 1/2 and a loss 0.
+	MinScore float64
+}
+
+// BuildBook builds an opening book from a corpus of games, as
+// BuildBookWith does, keeping moves played in at least minGames of
+// them.
+func BuildBook(games []*ptn.PTN, minGames int) Book {
+	return BuildBookWith(games, BookOptions{MinGames: minGames})
+}
+
+// BuildBookWith builds an opening book from a corpus of games. It
+// follows the main line of each game, tallying the moves played from
+// each position, up to symmetry, and the score each went on to
+// earn for the player who made it. For each position, the book holds
+// the move with the best average score among those that satisfy
+// opts; ties go to the move played more often.
+//
+// Games whose result is unknown, because they neither end on the
+// board nor have a Result, are skipped, as are the moves after any
+// illegal move.
+func BuildBookWith(games []*ptn.PTN, opts BookOptions) Book {
+	type tally struct {
+		move  tak.Move
+		games int
+		score float64
+	}
+	seen := make(map[string]map[string]*tally)
+	for _, g := range games {
+		p, e := g.InitialPosition()
+		if e != nil {
+			continue
+		}
+		var keys []string
+		var moves []tak.Move
+		var movers []tak.Color
+		for _, o := range g.Ops {
+			m, ok := o.(*ptn.Move)
+			if !ok {
+				continue
+			}
+			next, e := p.Move(&m.Move)
+			if e != nil {
+				break
+			}
+			key, syms := ptn.CanonicalTPS(p)
+			keys = append(keys, key)
+			moves = append(moves, canonicalMove(m.Move, syms, p.Size()))
+			movers = append(movers, p.ToMove())
+			p = next
+		}
+		winner, ok := gameResult(g, p)
+		if !ok {
+			continue
+		}
+		for i, key := range keys {
+			byMove := seen[key]
+			if byMove == nil {
+				byMove = make(map[string]*tally)
+				seen[key] = byMove
+			}
+			ms := ptn.FormatMove(&moves[i])
+			t := byMove[ms]
+			if t == nil {
+				t = &tally{move: moves[i]}
+				byMove[ms] = t
+			}
+			t.games++
+			switch winner {
+			case movers[i]:
+				t.score++
+			case tak.NoColor:
+				t.score += 0.5
+			}
+		}
+	}
+
+	book := make(Book)
+	for key, byMove := range seen {
+		// visit moves in a fixed order so ties are broken
+		// the same way every time
+		names := make([]string, 0, len(byMove))
+		for ms := range byMove {
+			names = append(names, ms)
+		}
+		sort.Strings(names)
+		var best *tally
+		for _, ms := range names {
+			t := byMove[ms]
+			if t.games < opts.MinGames || t.score/float64(t.games) < opts.MinScore {
+				continue
+			}
+			if best == nil {
+				best = t
+				continue
+			}
+			rate, bestRate := t.score/float64(t.games), best.score/float64(best.games)
+			if rate > bestRate || (rate == bestRate && t.games > best.games) {
+				best = t
+			}
+		}
+		if best != nil {
+			book[key] = best.move
+		}
+	}
+	return book
+}
+
+// canonicalMove returns m transformed into the canonical orientation
+// of its position, given the symmetries that map the position there.
+// If there is more than one, equivalent moves, such as the four
+// corner placements on an empty board, are folded together by taking
+// the one that formats least.
+func canonicalMove(m tak.Move, syms []tak.Symmetry, size int) tak.Move {
+	best := syms[0].Move(m, size)
+	for _, s := range syms[1:] {
+		if c := s.Move(m, size); ptn.FormatMove(&c) < ptn.FormatMove(&best) {
+			best = c
+		}
+	}
+	return best
+}
+
+// gameResult returns the winner of g, whose main line ends in final:
+// from the board, if the game is over there, and otherwise from its
+// result, or Result tag. It returns false if the result is unknown.
+func gameResult(g *ptn.PTN, final *tak.Position) (tak.Color, bool) {
+	if over, winner := final.GameOver(); over {
+		return winner, true
+	}
+	r := &ptn.Result{Result: g.FindTag("Result")}
+	for _, o := range g.Ops {
+		if res, ok := o.(*ptn.Result); ok {
+			r = res
+		}
+	}
+	if r.Result == "1/2-1/2" {
+		return tak.NoColor, true
+	}
+	winner := r.Winner()
+	return winner, winner != tak.NoColor
+}
@@ -0,0 +1,58 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestBuildBook(t *testing.T) {
+	var games []*ptn.PTN
+	for _, src := range []string{
+		// a1 and e1 are the same opening, up to symmetry
+		"[Size \"5\"]\n[Result \"R-0\"]\n\n1. a1 e5 2. c3\n",
+		"[Size \"5\"]\n[Result \"F-0\"]\n\n1. e1 a5 2. c3\n",
+		"[Size \"5\"]\n\n1. c3 a1 2. b2 0-R\n",
+		"[Size \"5\"]\n\n1. c3 e5 2. b2 1/2-1/2\n",
+		// no result
+		"[Size \"5\"]\n\n1. c3 e5 2. b2\n",
+	} {
+		g, err := ptn.ParsePTN(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		games = append(games, g)
+	}
+	start := tak.New(tak.Config{Size: 5})
+
+	book := BuildBook(games, 2)
+	m, ok := book.Lookup(start)
+	if !ok {
+		t.Fatal("no book move for the start position")
+	}
+	if m.Type != tak.PlaceFlat || (m.X != 0 && m.X != 4) || (m.Y != 0 && m.Y != 4) {
+		t.Errorf("book move %s, want a corner", ptn.FormatMove(&m))
+	}
+	// after c3, a1 and e5 are the same reply, scoring 3/4
+	after, _ := start.Move(&tak.Move{X: 2, Y: 2, Type: tak.PlaceFlat})
+	if m, ok := book.Lookup(after); !ok {
+		t.Errorf("no book move after c3")
+	} else if m.X != 0 && m.X != 4 {
+		t.Errorf("book move %s after c3, want a corner", ptn.FormatMove(&m))
+	}
+
+	book = BuildBookWith(games, BookOptions{MinGames: 2, MinScore: 0.5})
+	if _, ok := book.Lookup(start); !ok {
+		t.Errorf("MinScore 0.5: no book move for the start position")
+	}
+	book = BuildBookWith(games, BookOptions{MinGames: 3})
+	if _, ok := book.Lookup(start); ok {
+		t.Errorf("MinGames 3: book move for the start position")
+	}
+	book = BuildBookWith(games, BookOptions{MinGames: 1, MinScore: 0.9})
+	if len(book) != 2 {
+		t.Errorf("MinScore 0.9: %d entries, want 2", len(book))
+	}
+}
@@ -0,0 +1,228 @@
+package ai
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// Term identifies one term of the default evaluation function.
+type Term int
+
+const (
+	TermTempo Term = iota
+	TermTopFlats
+	TermEndgame
+	TermStanding
+	TermCapstone
+	TermStones
+	TermCaptured
+	TermMobility
+	TermGroups
+	TermBlocking
+	TermCapThreats
+	TermLiberties
+	TermSquares
+	TermBridges
+	TermFlatLead
+	TermCenter
+
+	NumTerms
+)
+
+var termNames = [NumTerms]string{
+	TermTempo:      "tempo",
+	TermTopFlats:   "flats",
+	TermEndgame:    "endgame",
+	TermStanding:   "standing",
+	TermCapstone:   "caps",
+	TermStones:     "stones",
+	TermCaptured:   "captured",
+	TermMobility:   "mobility",
+	TermGroups:     "groups",
+	TermBlocking:   "blocking",
+	TermCapThreats: "cap threats",
+	TermLiberties:  "liberties",
+	TermSquares:    "squares",
+	TermBridges:    "bridges",
+	TermFlatLead:   "flat lead",
+	TermCenter:     "center",
+}
+
+func (t Term) String() string {
+	if t >= 0 && t < NumTerms {
+		return termNames[t]
+	}
+	return fmt.Sprintf("Term(%d)", int(t))
+}
+
+// ScoreTerms holds a value, in evaluation units, for each Term.
+type ScoreTerms [NumTerms]int64
+
+// Total returns the sum of all terms.
+func (s *ScoreTerms) Total() int64 {
+	var t int64
+	for _, v := range s {
+		t += v
+	}
+	return t
+}
+
+// ScoreBreakdown is an evaluation of a position split into the
+// contributions of each term, for each player.
+type ScoreBreakdown struct {
+	White, Black ScoreTerms
+	// Terminal is set if the game is over. The terms are then
+	// zero, and Value is the value of the result.
+	Terminal bool
+	// Value is the evaluation of the position to the player to
+	// move, as returned by the evaluator.
+	Value int64
+}
+
+// Breakdown evaluates p with the default evaluation function and
+// m's weights (see MinimaxConfig.Weights), returning the
+// contribution of each term.
+func Breakdown(m *MinimaxAI, p *tak.Position) ScoreBreakdown {
+	var b ScoreBreakdown
+	if v, over := terminalValue(p, m.weights().RoadWin); over {
+		b.Terminal = true
+		b.Value = v
+		return b
+	}
+	scoreTerms(m.weights(), m, p, &b.White, &b.Black)
+	b.Value = b.White.Total() - b.Black.Total()
+	if p.ToMove() == tak.Black {
+		b.Value = -b.Value
+	}
+	return b
+}
+
+// StaticOptions configures StaticEvaluate and ExplainScoreWith.
+type StaticOptions struct {
+	// NoTempo leaves out the Tempo term, which credits the player
+	// to move, so that a position evaluates the same whoever is
+	// to move in it.
+	NoTempo bool
+	// Ranked makes ExplainScoreWith list the terms of the
+	// evaluation ranked by their contribution, as RankTerms
+	// does, instead of the features they score.
+	Ranked bool
+}
+
+// StaticEvaluate evaluates p, as Breakdown does, without searching,
+// and returns the value from White's point of view, so that
+// positions can be compared with each other.
+func StaticEvaluate(m *MinimaxAI, p *tak.Position, opts StaticOptions) int64 {
+	b := Breakdown(m, p)
+	if b.Terminal {
+		return WhiteValue(p, b.Value)
+	}
+	if opts.NoTempo {
+		b.White[TermTempo], b.Black[TermTempo] = 0, 0
+	}
+	return b.White.Total() - b.Black.Total()
+}
+
+// TermContribution is one term's part in an evaluation.
+type TermContribution struct {
+	Term Term
+	// Value is White's score for the term less Black's.
+	Value int64
+	// Share is Value as a fraction of the sum of the magnitudes
+	// of all the terms, so that the magnitudes of the shares of
+	// all the terms sum to 1, and its sign is Value's.
+	Share float64
+}
+
+// RankTerms evaluates p as StaticEvaluate does, and returns the terms
+// that contribute to the evaluation, from White's point of view,
+// ordered by the magnitude of their contribution, largest first. It
+// returns nil if the game is over in p.
+func RankTerms(m *MinimaxAI, p *tak.Position, opts StaticOptions) []TermContribution {
+	b := Breakdown(m, p)
+	if b.Terminal {
+		return nil
+	}
+	if opts.NoTempo {
+		b.White[TermTempo], b.Black[TermTempo] = 0, 0
+	}
+	var out []TermContribution
+	var sum int64
+	for t := Term(0); t < NumTerms; t++ {
+		v := b.White[t] - b.Black[t]
+		if v == 0 {
+			continue
+		}
+		out = append(out, TermContribution{Term: t, Value: v})
+		sum += abs(v)
+	}
+	for i := range out {
+		out[i].Share = float64(out[i].Value) / float64(sum)
+	}
+	sort.Stable(byContribution(out))
+	return out
+}
+
+// byContribution orders terms by the magnitude of their
+// contribution, largest first.
+type byContribution []TermContribution
+
+func (b byContribution) Len() int { return len(b) }
+func (b byContribution) Less(i, j int) bool {
+	return abs(b[i].Value) > abs(b[j].Value)
+}
+func (b byContribution) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// explainRanked writes the table for ExplainScoreWith with
+// opts.Ranked set.
+func explainRanked(m *MinimaxAI, out io.Writer, p *tak.Position, opts StaticOptions) {
+	tw := tabwriter.NewWriter(out, 4, 8, 1, '\t', 0)
+	fmt.Fprintf(tw, "\tvalue\tshare\n")
+	for _, c := range RankTerms(m, p, opts) {
+		fmt.Fprintf(tw, "%s\t%+d\t%+.1f%%\n", c.Term, c.Value, 100*c.Share)
+	}
+	fmt.Fprintf(tw, "value\t%d\n", StaticEvaluate(m, p, opts))
+	tw.Flush()
+}
+
+// ScoreDiff is the change in the evaluation between two positions,
+// from White's point of view: positive values favor White.
+type ScoreDiff struct {
+	// Terms is the change in each term of White's score less
+	// Black's.
+	Terms ScoreTerms
+	// Before and After are the evaluations of the two positions,
+	// from White's point of view.
+	Before, After int64
+}
+
+// Total returns the change in the evaluation. Unless either position
+// is terminal, it is also the sum of Terms.
+func (d *ScoreDiff) Total() int64 {
+	return d.After - d.Before
+}
+
+// DiffScore explains the change in evaluation from before to after,
+// typically the positions before and after a move, term by term.
+func DiffScore(m *MinimaxAI, before, after *tak.Position) ScoreDiff {
+	b, a := Breakdown(m, before), Breakdown(m, after)
+	d := ScoreDiff{
+		Before: WhiteValue(before, b.Value),
+		After:  WhiteValue(after, a.Value),
+	}
+	for t := range d.Terms {
+		d.Terms[t] = (a.White[t] - a.Black[t]) - (b.White[t] - b.Black[t])
+	}
+	return d
+}
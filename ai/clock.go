@@ -0,0 +1,66 @@
+package ai
+
+import (
+	"time"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// TimeControl describes the state of a game clock from the point of
+// view of the player to move.
+type TimeControl struct {
+	// Remaining is the time left on our clock.
+	Remaining time.Duration
+	// Increment is the time added to our clock after each move.
+	Increment time.Duration
+	// MovesToGo is the number of moves until the next time
+	// control. If zero, it is estimated from our reserves.
+	MovesToGo int
+	// MaxPerMove, if nonzero, caps the time spent on any one
+	// move.
+	MaxPerMove time.Duration
+}
+
+const (
+	minMovesToGo = 5
+	// extendFactor bounds how far past its budget a search may
+	// run while the best move is still changing.
+	extendFactor = 3
+)
+
+// Budget computes how long to spend searching `p`. `soft` is the
+// normal budget for the move; `hard` is the most the search may use
+// if the principal variation is unstable between iterations.
+//
+// Moves in the opening, before each player has placed `size` stones,
+// get half the normal budget.
+func (tc TimeControl) Budget(p *tak.Position) (soft, hard time.Duration) {
+	togo := tc.MovesToGo
+	if togo <= 0 {
+		if p.ToMove() == tak.White {
+			togo = p.WhiteStones() / 2
+		} else {
+			togo = p.BlackStones() / 2
+		}
+	}
+	if togo < minMovesToGo {
+		togo = minMovesToGo
+	}
+	soft = tc.Remaining/time.Duration(togo) + tc.Increment*3/4
+	if p.MoveNumber() < 2*p.Size() {
+		soft /= 2
+	}
+	hard = extendFactor * soft
+
+	// never plan to use more than half our clock on one move
+	if max := tc.Remaining/2 + tc.Increment; hard > max {
+		hard = max
+	}
+	if tc.MaxPerMove != 0 && hard > tc.MaxPerMove {
+		hard = tc.MaxPerMove
+	}
+	if soft > hard {
+		soft = hard
+	}
+	return soft, hard
+}
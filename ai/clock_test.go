@@ -0,0 +1,36 @@
+package ai
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestTimeControlBudget(t *testing.T) {
+	p := tak.New(tak.Config{Size: 5})
+	tc := TimeControl{Remaining: 10 * time.Minute, MovesToGo: 20}
+
+	soft, hard := tc.Budget(p)
+	if soft != 15*time.Second || hard != 45*time.Second {
+		t.Errorf("opening budget=(%s, %s)", soft, hard)
+	}
+
+	p, _ = ptn.ParseTPS("x5/x5/x5/x5/x5 1 6")
+	soft, hard = tc.Budget(p)
+	if soft != 30*time.Second || hard != 90*time.Second {
+		t.Errorf("middlegame budget=(%s, %s)", soft, hard)
+	}
+
+	tc.MaxPerMove = time.Minute
+	if soft, hard = tc.Budget(p); soft != 30*time.Second || hard != time.Minute {
+		t.Errorf("capped budget=(%s, %s)", soft, hard)
+	}
+
+	tc = TimeControl{Remaining: 10 * time.Second, Increment: 8 * time.Second}
+	soft, hard = tc.Budget(p)
+	if hard > 13*time.Second || soft > hard {
+		t.Errorf("low-clock budget=(%s, %s)", soft, hard)
+	}
+}
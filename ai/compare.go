@@ -0,0 +1,99 @@
+package ai
+
+import (
+	"github.com/nelhage/taktician/tak"
+)
+
+// ComparisonReport is the result of CompareConfigs.
+type ComparisonReport struct {
+	// Positions is the number of positions compared, and Agreed
+	// the number on which both configurations chose the same move.
+	Positions int
+	Agreed    int
+	// NodesA and NodesB are the total positions visited and
+	// evaluated by each configuration.
+	NodesA, NodesB uint64
+	// Disagreements lists the positions on which the
+	// configurations chose different moves, in order.
+	Disagreements []Disagreement
+}
+
+// Disagreement describes a position on which the configurations
+// compared by CompareConfigs chose different moves. Values are from
+// the perspective of the player to move.
+type Disagreement struct {
+	Position *tak.Position
+
+	MoveA, MoveB   tak.Move
+	ValueA, ValueB int64
+	NodesA, NodesB uint64
+}
+
+// Agreement returns the fraction of positions on which both
+// configurations chose the same move.
+func (r *ComparisonReport) Agreement() float64 {
+	if r.Positions == 0 {
+		return 1
+	}
+	return float64(r.Agreed) / float64(r.Positions)
+}
+
+// NodeRatio returns NodesB / NodesA, so that a value below 1 means
+// the second configuration searched fewer nodes.
+func (r *ComparisonReport) NodeRatio() float64 {
+	if r.NodesA == 0 {
+		return 0
+	}
+	return float64(r.NodesB) / float64(r.NodesA)
+}
+
+// CompareConfigs analyzes each position with each configuration, to
+// its configured depth, and reports where they choose different
+// moves, to judge the effect of a change to the search on its
+// results and its speed. Size is set from each position, and each
+// search starts with an empty transposition table. Positions where
+// the game is over are counted as agreements.
+func CompareConfigs(cfgA, cfgB MinimaxConfig, positions []*tak.Position) ComparisonReport {
+	engines := [2]map[int]*MinimaxAI{{}, {}}
+	search := func(i int, cfg MinimaxConfig, p *tak.Position) ([]tak.Move, int64, uint64) {
+		m := engines[i][p.Size()]
+		if m == nil {
+			cfg.Size = p.Size()
+			m = NewMinimax(cfg)
+			engines[i][p.Size()] = m
+		}
+		m.ClearTable()
+		pv, v, st := m.Analyze(p, 0)
+		return pv, v, st.Visited + st.Evaluated
+	}
+
+	var r ComparisonReport
+	for _, p := range positions {
+		pvA, vA, nA := search(0, cfgA, p)
+		pvB, vB, nB := search(1, cfgB, p)
+		r.Positions++
+		r.NodesA += nA
+		r.NodesB += nB
+		var mA, mB tak.Move
+		if len(pvA) > 0 {
+			mA = pvA[0]
+		}
+		if len(pvB) > 0 {
+			mB = pvB[0]
+		}
+		if mA.Equal(&mB) {
+			r.Agreed++
+			continue
+		}
+		r.Disagreements = append(r.Disagreements, Disagreement{
+			Position: p,
+			MoveA:    mA,
+			MoveB:    mB,
+			ValueA:   vA,
+			ValueB:   vB,
+			NodesA:   nA,
+			NodesB:   nB,
+		})
+	}
+	return r
+}
@@ -0,0 +1,52 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestCompareConfigs(t *testing.T) {
+	var ps []*tak.Position
+	for _, tps := range []string{
+		midgameTPS,
+		"x3/1,1,x/2,2,x 1 3",
+		"x5/x5/1,1,1,1,1/x5/2,2,2,2,x 2 6",
+	} {
+		p, err := ptn.ParseTPS(tps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps = append(ps, p)
+	}
+
+	cfg := MinimaxConfig{Depth: 3, Deterministic: true}
+	r := CompareConfigs(cfg, cfg, ps)
+	if r.Positions != len(ps) || r.Agreement() != 1 || len(r.Disagreements) != 0 {
+		t.Errorf("same config: positions=%d agreed=%d disagreements=%d",
+			r.Positions, r.Agreed, len(r.Disagreements))
+	}
+	if r.NodesA == 0 || r.NodeRatio() != 1 {
+		t.Errorf("same config: nodes=%d/%d", r.NodesA, r.NodesB)
+	}
+
+	// an evaluator that wants to lose disagrees about the quiet
+	// position, but still completes the road
+	losing := func(m *MinimaxAI, p *tak.Position) int64 {
+		return -DefaultEvaluate(m, p)
+	}
+	r = CompareConfigs(cfg, MinimaxConfig{Depth: 3, Deterministic: true, Evaluate: losing}, ps)
+	if r.Agreed+len(r.Disagreements) != r.Positions {
+		t.Errorf("agreed=%d disagreements=%d positions=%d",
+			r.Agreed, len(r.Disagreements), r.Positions)
+	}
+	if len(r.Disagreements) != 1 || r.Disagreements[0].Position != ps[0] {
+		t.Fatalf("disagreements: %+v", r.Disagreements)
+	}
+	d := r.Disagreements[0]
+	if d.MoveA.Equal(&d.MoveB) || d.NodesA == 0 || d.NodesB == 0 {
+		t.Errorf("disagreement: %s vs %s, nodes=%d/%d",
+			ptn.FormatMove(&d.MoveA), ptn.FormatMove(&d.MoveB), d.NodesA, d.NodesB)
+	}
+}
@@ -0,0 +1,31 @@
+package ai
+
+import "github.com/nelhage/taktician/tak"
+
+// evalEntry caches the evaluation of a leaf. Hashes do not cover the
+// player to move, so it is kept alongside; an entry with no player to
+// move is empty. Only positions where the game is not over are
+// cached, since the values of finished games depend on the move
+// number.
+type evalEntry struct {
+	hash   uint64
+	toMove tak.Color
+	value  int64
+}
+
+// evaluateLeaf evaluates p, a leaf of the search at which the game is
+// not over, consulting the evaluation cache if there is one.
+func (ai *MinimaxAI) evaluateLeaf(p *tak.Position, ctx SearchContext) int64 {
+	if ai.evalCache == nil {
+		return ai.evaluate(ai, p, ctx)
+	}
+	h := p.Hash()
+	e := &ai.evalCache[h%uint64(len(ai.evalCache))]
+	if e.hash == h && e.toMove == p.ToMove() {
+		ai.st.EvalCacheHits++
+		return e.value
+	}
+	v := ai.evaluate(ai, p, ctx)
+	*e = evalEntry{hash: h, toMove: p.ToMove(), value: v}
+	return v
+}
@@ -0,0 +1,54 @@
+package ai
+
+import (
+	"testing"
+)
+
+func TestEvalCache(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true}
+	pv, v, plain := NewMinimax(cfg).Analyze(p, 0)
+
+	cfg.EvalCache = 1 << 16
+	cpv, cv, cached := NewMinimax(cfg).Analyze(p, 0)
+	if cv != v || !cpv[0].Equal(&pv[0]) {
+		t.Errorf("cached: pv=%s v=%d, uncached pv=%s v=%d", formatpv(cpv), cv, formatpv(pv), v)
+	}
+	if cached.EvalCacheHits == 0 || plain.EvalCacheHits != 0 {
+		t.Errorf("hits: cached=%d uncached=%d", cached.EvalCacheHits, plain.EvalCacheHits)
+	}
+	if cached.Evaluated != plain.Evaluated {
+		t.Errorf("evaluated %d leaves, uncached %d", cached.Evaluated, plain.Evaluated)
+	}
+
+	// a single slot forces every lookup to check the hash
+	cfg.EvalCache = 1
+	if cpv, cv, _ := NewMinimax(cfg).Analyze(p, 0); cv != v || !cpv[0].Equal(&pv[0]) {
+		t.Errorf("one slot: pv=%s v=%d, uncached pv=%s v=%d", formatpv(cpv), cv, formatpv(pv), v)
+	}
+
+	cfg.EvalCache = 1 << 16
+	cfg.EvaluateContext = DefaultEvaluate.WithContext()
+	if _, _, st := NewMinimax(cfg).Analyze(p, 0); st.EvalCacheHits != 0 {
+		t.Errorf("EvaluateContext: %d cache hits", st.EvalCacheHits)
+	}
+}
+
+func BenchmarkEvalCache(b *testing.B) {
+	p := parseTPS(b, midgameTPS)
+	for _, tc := range []struct {
+		name string
+		n    int
+	}{{"off", 0}, {"on", 1 << 16}} {
+		b.Run(tc.name, func(b *testing.B) {
+			var st Stats
+			for i := 0; i < b.N; i++ {
+				ai := NewMinimax(MinimaxConfig{
+					Size: p.Size(), Depth: 5, Deterministic: true, EvalCache: tc.n,
+				})
+				_, _, st = ai.Analyze(p, 0)
+			}
+			b.Logf("hits/eval: %.3f", float64(st.EvalCacheHits)/float64(st.Evaluated))
+		})
+	}
+}
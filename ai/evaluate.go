@@ -17,11 +17,77 @@ type Weights struct {
 	Flat     int
 	Captured int
 
+	// CapturedOwn and CapturedEnemy are credited, in addition to
+	// Captured, for each of the stones counted for Captured that
+	// is the stack owner's own flat, which they can recover by
+	// spreading the stack, or the opponent's, which is out of
+	// play while it stays buried.
+	CapturedOwn   int
+	CapturedEnemy int
+
 	Liberties int
 
 	Tempo int
 
+	// BlockingWall is credited for each standing stone adjacent
+	// to one of the opponent's road groups, and CapThreat for
+	// each opponent wall adjacent to both one of our road
+	// groups and our capstone, which can flatten it.
+	BlockingWall int
+	CapThreat    int
+
+	// StackMobility is credited, for each stack we control, per
+	// captured stone (as counted for Captured) per direction the
+	// stack can slide, so that a stack hemmed in by walls and
+	// capstones is worth less than one free to release its
+	// captives.
+	StackMobility int
+
+	// EndgameFlat is credited per top flat, for each stone the
+	// player with the smaller reserve is below EndgameStones,
+	// so that flat count dominates as the game nears its end.
+	EndgameFlat   int
+	EndgameStones int
+
+	// FlatLead is credited to the player ahead in top flats,
+	// per flat of their lead, once more than FlatLeadFill percent
+	// of the board is occupied, scaling up from nothing at that
+	// point to the full weight on a full board, so that the
+	// evaluation turns towards the flat count that will decide
+	// the game if the board fills.
+	FlatLead     int
+	FlatLeadFill int
+
+	// Bridge is credited for each empty square on which a flat
+	// would join two of our road groups (see
+	// tak.Position.BridgeMask). Several such squares are hard
+	// for the opponent to block at once.
+	Bridge int
+
+	// CenterControl is credited for each top flat or capstone,
+	// per ring of the board it lies inside the edge (see
+	// bitboard.Constants.Center). Larger boards have more rings,
+	// so the center counts for more on them: the middle square
+	// earns twice the weight on 5x5, and three times on 7x7.
+	CenterControl int
+
+	// RoadWin is added to the value of a win by road, if
+	// positive, or with its sign reversed to that of a win on
+	// flats, if negative, so that the engine prefers one kind of
+	// win to the other when both come on the same ply. It is
+	// limited to maxWinBonus, and never changes which ply a win is
+	// valued at.
+	RoadWin int
+
 	Groups [8]int
+
+	// Squares, if it has an entry for every square of the board,
+	// indexed by tak.SquareIndex, is credited for each top flat on
+	// the corresponding square, scaled by the fraction of the
+	// board that is still empty, so that it shapes the opening and
+	// fades as the board fills. It is ignored if its length does
+	// not match the board, as when it is unset.
+	Squares []int
 }
 
 var DefaultWeights = Weights{
@@ -54,39 +120,150 @@ func MakeEvaluator(w *Weights) EvaluationFunc {
 
 var DefaultEvaluate = MakeEvaluator(&DefaultWeights)
 
-func evaluate(w *Weights, m *MinimaxAI, p *tak.Position) int64 {
-	if over, winner := p.GameOver(); over {
-		var pieces int64
-		if winner == tak.White {
-			pieces = int64(p.WhiteStones())
-		} else {
-			pieces = int64(p.BlackStones())
-		}
-		switch winner {
-		case tak.NoColor:
-			return 0
-		case p.ToMove():
-			return maxEval - int64(p.MoveNumber()) + pieces
-		default:
-			return minEval + int64(p.MoveNumber()) - pieces
+// DefaultTempoCorrection is the correction used by
+// MakeTempoEvaluator that best stabilized root values between
+// iterations across the tests/data/ai positions.
+const DefaultTempoCorrection = 100
+
+// MakeTempoEvaluator returns an evaluator that also corrects for the
+// odd-even effect of iterative deepening. At leaves an odd number of
+// plies from the root, the root player has had one more move than
+// their opponent; the evaluator credits the opponent, who is to move
+// there, with an extra `correction` so that the root values of odd
+// and even iterations are comparable.
+func MakeTempoEvaluator(w *Weights, correction int) ContextEvaluationFunc {
+	return func(m *MinimaxAI, p *tak.Position, ctx SearchContext) int64 {
+		v := evaluate(w, m, p)
+		if ctx.Ply%2 == 0 || v > WinThreshold || v < -WinThreshold {
+			return v
 		}
+		return v + int64(correction)
 	}
+}
 
-	var ws, bs int64
+func evaluate(w *Weights, m *MinimaxAI, p *tak.Position) int64 {
+	if v, over := terminalValue(p, w.RoadWin); over {
+		return v
+	}
+	var ws, bs ScoreTerms
+	scoreTerms(w, m, p, &ws, &bs)
+	if p.ToMove() == tak.White {
+		return ws.Total() - bs.Total()
+	}
+	return bs.Total() - ws.Total()
+}
+
+// MateInPlies reports whether v, a value for p as returned by
+// Analyze, is a forced win or loss, and if so, how many plies from p
+// the game ends. Whether it is a win or a loss for the player to
+// move is given by the sign of v.
+func MateInPlies(p *tak.Position, v int64) (isMate bool, plies int) {
+	if v < 0 {
+		v = -v
+	}
+	if v <= WinThreshold {
+		return false, 0
+	}
+	end := (maxEval - v + mateScale - 1) / mateScale
+	return true, int(end) - p.MoveNumber()
+}
 
+// mateScale is the value of each ply by which a win comes sooner.
+// It exceeds any count of reserve stones, up to tak.MaxPieces, plus
+// the win bonus, which break ties between wins on the same ply, so
+// that MateInPlies can recover the ply.
+const mateScale = 512
+
+// terminalValue returns the value of p to the player to move, if the
+// game is over. Wins are valued by the ply on which they occur,
+// rather than by their distance from the root of a search, so the
+// value of a position does not depend on where it is reached.
+//
+// Wins on the same ply are ordered by the winner's reserve stones,
+// and then by the preference for road or flat wins given by roadWin
+// (see Weights.RoadWin).
+func terminalValue(p *tak.Position, roadWin int) (int64, bool) {
+	over, winner := p.GameOver()
+	if !over {
+		return 0, false
+	}
+	if winner == tak.NoColor {
+		return 0, true
+	}
+	var pieces int64
+	if winner == tak.White {
+		pieces = int64(p.WhiteStones())
+	} else {
+		pieces = int64(p.BlackStones())
+	}
+	pieces += winBonus(p, roadWin)
+	switch winner {
+	case p.ToMove():
+		return maxEval - mateScale*int64(p.MoveNumber()) + pieces, true
+	default:
+		return minEval + mateScale*int64(p.MoveNumber()) - pieces, true
+	}
+}
+
+// maxWinBonus bounds Weights.RoadWin so that, with the winner's
+// reserve stones, it stays below mateScale.
+const maxWinBonus = 128
+
+// winBonus returns the bonus for the kind of win with which p, which
+// must be won, ended, given the preference roadWin.
+func winBonus(p *tak.Position, roadWin int) int64 {
+	if roadWin == 0 {
+		return 0
+	}
+	bonus := roadWin
+	if bonus < 0 {
+		bonus = -bonus
+	}
+	if bonus > maxWinBonus {
+		bonus = maxWinBonus
+	}
+	road := p.WinDetails().Reason == tak.RoadWin
+	if road != (roadWin > 0) {
+		return 0
+	}
+	return int64(bonus)
+}
+
+// scoreTerms computes each term of the evaluation of a position
+// that is not over, for White into ws and for Black into bs.
+func scoreTerms(w *Weights, m *MinimaxAI, p *tak.Position, ws, bs *ScoreTerms) {
 	if p.ToMove() == tak.White {
-		ws += int64(w.Tempo)
+		ws[TermTempo] += int64(w.Tempo)
 	} else {
-		bs += int64(w.Tempo)
+		bs[TermTempo] += int64(w.Tempo)
 	}
 	analysis := p.Analysis()
 
-	ws += int64(bitboard.Popcount(p.White&^p.Caps&^p.Standing) * w.TopFlat)
-	bs += int64(bitboard.Popcount(p.Black&^p.Caps&^p.Standing) * w.TopFlat)
-	ws += int64(bitboard.Popcount(p.White&p.Standing) * w.Standing)
-	bs += int64(bitboard.Popcount(p.Black&p.Standing) * w.Standing)
-	ws += int64(bitboard.Popcount(p.White&p.Caps) * w.Capstone)
-	bs += int64(bitboard.Popcount(p.Black&p.Caps) * w.Capstone)
+	wf := bitboard.Popcount(p.White &^ p.Caps &^ p.Standing)
+	bf := bitboard.Popcount(p.Black &^ p.Caps &^ p.Standing)
+	ws[TermTopFlats] += int64(wf * w.TopFlat)
+	bs[TermTopFlats] += int64(bf * w.TopFlat)
+	reserves := p.WhiteStones()
+	if p.BlackStones() < reserves {
+		reserves = p.BlackStones()
+	}
+	if reserves < w.EndgameStones {
+		k := w.EndgameStones - reserves
+		ws[TermEndgame] += int64(k * wf * w.EndgameFlat)
+		bs[TermEndgame] += int64(k * bf * w.EndgameFlat)
+	}
+	if w.FlatLead != 0 {
+		lead := flatLead(w, p, wf-bf)
+		if lead > 0 {
+			ws[TermFlatLead] += lead
+		} else {
+			bs[TermFlatLead] -= lead
+		}
+	}
+	ws[TermStanding] += int64(bitboard.Popcount(p.White&p.Standing) * w.Standing)
+	bs[TermStanding] += int64(bitboard.Popcount(p.Black&p.Standing) * w.Standing)
+	ws[TermCapstone] += int64(bitboard.Popcount(p.White&p.Caps) * w.Capstone)
+	bs[TermCapstone] += int64(bitboard.Popcount(p.Black&p.Caps) * w.Capstone)
 
 	for i, h := range p.Height {
 		if h <= 1 {
@@ -95,33 +272,98 @@ func evaluate(w *Weights, m *MinimaxAI, p *tak.Position) int64 {
 		s := p.Stacks[i] & ((1 << (h - 1)) - 1)
 		bf := bitboard.Popcount(s)
 		wf := int(h) - bf - 1
-		ws += int64(wf * w.Flat)
-		bs += int64(bf * w.Flat)
+		ws[TermStones] += int64(wf * w.Flat)
+		bs[TermStones] += int64(bf * w.Flat)
 		captured := int(h - 1)
 		if captured > p.Size()-1 {
 			captured = p.Size() - 1
 		}
+		t := bs
 		if p.White&(1<<uint(i)) != 0 {
-			ws += int64(captured * w.Captured)
-		} else {
-			bs += int64(captured * w.Captured)
+			t = ws
+		}
+		t[TermCaptured] += int64(captured * w.Captured)
+		if w.CapturedOwn != 0 || w.CapturedEnemy != 0 {
+			enemy := bitboard.Popcount(s & (1<<uint(captured) - 1))
+			if t == bs {
+				enemy = captured - enemy
+			}
+			t[TermCaptured] += int64((captured-enemy)*w.CapturedOwn + enemy*w.CapturedEnemy)
+		}
+		if w.StackMobility != 0 {
+			mobility := captured * m.slideDirections(p, i)
+			t[TermMobility] += int64(mobility * w.StackMobility)
 		}
 	}
 
-	ws += int64(m.scoreGroups(analysis.WhiteGroups, w))
-	bs += int64(m.scoreGroups(analysis.BlackGroups, w))
+	ws[TermGroups] += int64(m.scoreGroups(analysis.WhiteGroups, w))
+	bs[TermGroups] += int64(m.scoreGroups(analysis.BlackGroups, w))
 
-	wr := p.White &^ p.Standing
-	br := p.Black &^ p.Standing
-	wl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.Black, wr) &^ wr)
-	bl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.White, br) &^ br)
-	ws += int64(w.Liberties * wl)
-	bs += int64(w.Liberties * bl)
+	if w.BlockingWall != 0 || w.CapThreat != 0 {
+		wb, wc := m.blocking(p, p.White, p.Black, analysis.WhiteGroups, analysis.BlackGroups)
+		bb, bc := m.blocking(p, p.Black, p.White, analysis.BlackGroups, analysis.WhiteGroups)
+		ws[TermBlocking] += int64(wb * w.BlockingWall)
+		bs[TermBlocking] += int64(bb * w.BlockingWall)
+		ws[TermCapThreats] += int64(wc * w.CapThreat)
+		bs[TermCapThreats] += int64(bc * w.CapThreat)
+	}
 
-	if p.ToMove() == tak.White {
-		return ws - bs
+	wr, br := p.RoadMask(tak.White), p.RoadMask(tak.Black)
+	if w.Liberties != 0 {
+		wl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.Black, wr) &^ wr)
+		bl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.White, br) &^ br)
+		ws[TermLiberties] += int64(w.Liberties * wl)
+		bs[TermLiberties] += int64(w.Liberties * bl)
+	}
+
+	if w.Bridge != 0 {
+		ws[TermBridges] += int64(bitboard.Popcount(p.BridgeMask(tak.White)) * w.Bridge)
+		bs[TermBridges] += int64(bitboard.Popcount(p.BridgeMask(tak.Black)) * w.Bridge)
+	}
+
+	if w.CenterControl != 0 {
+		ws[TermCenter] += int64(m.centerControl(wr) * w.CenterControl)
+		bs[TermCenter] += int64(m.centerControl(br) * w.CenterControl)
+	}
+
+	if cells := p.Size() * p.Size(); len(w.Squares) == cells {
+		empty := int64(bitboard.Popcount(m.c.Mask &^ (p.White | p.Black)))
+		ws[TermSquares] += squareBonus(w.Squares, wr&^p.Caps) * empty / int64(cells)
+		bs[TermSquares] += squareBonus(w.Squares, br&^p.Caps) * empty / int64(cells)
+	}
+}
+
+// flatLead returns the FlatLead credit for a lead of `lead` top
+// flats, which is negative if Black is ahead.
+func flatLead(w *Weights, p *tak.Position, lead int) int64 {
+	cells := int64(p.Size() * p.Size())
+	filled := int64(bitboard.Popcount(p.White|p.Black)) * 100
+	start := cells * int64(w.FlatLeadFill)
+	if filled <= start {
+		return 0
 	}
-	return bs - ws
+	return int64(lead*w.FlatLead) * (filled - start) / (cells*100 - start)
+}
+
+// squareBonus sums the entries of table for the squares in bits.
+func squareBonus(table []int, bits uint64) int64 {
+	var v int64
+	for i, b := range table {
+		if bits&(1<<uint(i)) != 0 {
+			v += int64(b)
+		}
+	}
+	return v
+}
+
+// centerControl returns the number of rings inside the edge of the
+// board of each of the squares in bits, summed.
+func (ai *MinimaxAI) centerControl(bits uint64) int {
+	n := 0
+	for d := 0; d < ai.c.Rings-1; d++ {
+		n += (ai.c.Rings - 1 - d) * bitboard.Popcount(bits&ai.c.Center[d])
+	}
+	return n
 }
 
 func (ai *MinimaxAI) scoreGroups(gs []uint64, ws *Weights) int {
@@ -136,60 +378,110 @@ func (ai *MinimaxAI) scoreGroups(gs []uint64, ws *Weights) int {
 	return sc
 }
 
-func ExplainScore(m *MinimaxAI, out io.Writer, p *tak.Position) {
-	tw := tabwriter.NewWriter(out, 4, 8, 1, '\t', 0)
-	fmt.Fprintf(tw, "\twhite\tblack\n")
-	var scores [2]struct {
-		flats    int
-		standing int
-		caps     int
+// slideDirections returns the number of directions in which the
+// stack on square i can slide at least one stone: those in which the
+// neighboring square exists and holds neither a capstone nor, unless
+// the stack is topped by a capstone, a wall.
+func (ai *MinimaxAI) slideDirections(p *tak.Position, i int) int {
+	blocked := p.Caps | p.Standing
+	if p.Caps&(1<<uint(i)) != 0 {
+		blocked = p.Caps
+	}
+	size := ai.cfg.Size
+	x, y := tak.Coords(i, size)
+	n := 0
+	if x > 0 && blocked&(1<<uint(i-1)) == 0 {
+		n++
+	}
+	if x < size-1 && blocked&(1<<uint(i+1)) == 0 {
+		n++
+	}
+	if y > 0 && blocked&(1<<uint(i-size)) == 0 {
+		n++
+	}
+	if y < size-1 && blocked&(1<<uint(i+size)) == 0 {
+		n++
+	}
+	return n
+}
 
-		stones   int
-		captured int
+// blocking returns the number of our walls adjacent to an
+// opponent's road group, and the number of opponent walls adjacent
+// to both one of our road groups and one of our capstones.
+func (ai *MinimaxAI) blocking(p *tak.Position, us, them uint64, ours, theirs []uint64) (walls, caps int) {
+	var og, tg uint64
+	for _, g := range ours {
+		og |= g
+	}
+	for _, g := range theirs {
+		tg |= g
+	}
+	walls = bitboard.Popcount(us & p.Standing & bitboard.Grow(&ai.c, ai.c.Mask, tg))
+	if c := us & p.Caps; c != 0 && og != 0 {
+		threats := them & p.Standing & bitboard.Grow(&ai.c, ai.c.Mask, og)
+		caps = bitboard.Popcount(threats & bitboard.Grow(&ai.c, ai.c.Mask, c))
 	}
+	return walls, caps
+}
 
-	scores[0].flats = bitboard.Popcount(p.White &^ p.Caps &^ p.Standing)
-	scores[1].flats = bitboard.Popcount(p.Black &^ p.Caps &^ p.Standing)
-	scores[0].standing = bitboard.Popcount(p.White & p.Standing)
-	scores[1].standing = bitboard.Popcount(p.Black & p.Standing)
-	scores[0].caps = bitboard.Popcount(p.White & p.Caps)
-	scores[1].caps = bitboard.Popcount(p.Black & p.Caps)
+// ExplainScore writes a table of the features of p that the default
+// evaluation scores, for each player, as ExplainScoreWith does with
+// the default options.
+func ExplainScore(m *MinimaxAI, out io.Writer, p *tak.Position) {
+	ExplainScoreWith(m, out, p, StaticOptions{})
+}
 
+// ExplainScoreWith writes a table of the features of p that the
+// default evaluation scores, for each player, followed by the
+// evaluation itself, from White's point of view, as returned by
+// StaticEvaluate with opts. If opts.Ranked is set, the table instead
+// lists the terms of the evaluation, largest first, with their values
+// and shares as returned by RankTerms.
+func ExplainScoreWith(m *MinimaxAI, out io.Writer, p *tak.Position, opts StaticOptions) {
+	if opts.Ranked {
+		explainRanked(m, out, p, opts)
+		return
+	}
+	tw := tabwriter.NewWriter(out, 4, 8, 1, '\t', 0)
+	fmt.Fprintf(tw, "\twhite\tblack\n")
+	var mat [2]tak.MaterialCount
+	mat[0], mat[1] = p.Material()
+	var mobility [2]int
 	for i, h := range p.Height {
 		if h <= 1 {
 			continue
 		}
-		s := p.Stacks[i] & ((1 << (h - 1)) - 1)
-		bf := bitboard.Popcount(s)
-		wf := int(h) - bf - 1
-		scores[0].stones += wf
-		scores[1].stones += bf
-
 		captured := int(h - 1)
 		if captured > p.Size()-1 {
 			captured = p.Size() - 1
 		}
+		c := 1
 		if p.White&(1<<uint(i)) != 0 {
-			scores[0].captured += captured
-		} else {
-			scores[1].captured += captured
+			c = 0
 		}
+		mobility[c] += captured * m.slideDirections(p, i)
 	}
 
-	fmt.Fprintf(tw, "flats\t%d\t%d\n", scores[0].flats, scores[1].flats)
-	fmt.Fprintf(tw, "standing\t%d\t%d\n", scores[0].standing, scores[1].standing)
-	fmt.Fprintf(tw, "caps\t%d\t%d\n", scores[0].caps, scores[1].caps)
-	fmt.Fprintf(tw, "captured\t%d\t%d\n", scores[0].captured, scores[1].captured)
-	fmt.Fprintf(tw, "stones\t%d\t%d\n", scores[0].stones, scores[1].stones)
+	fmt.Fprintf(tw, "flats\t%d\t%d\n", mat[0].Flats, mat[1].Flats)
+	fmt.Fprintf(tw, "standing\t%d\t%d\n", mat[0].Walls, mat[1].Walls)
+	fmt.Fprintf(tw, "caps\t%d\t%d\n", mat[0].Capstones, mat[1].Capstones)
+	fmt.Fprintf(tw, "captured\t%d\t%d\n", mat[0].Captured, mat[1].Captured)
+	fmt.Fprintf(tw, "stones\t%d\t%d\n", mat[0].Stones, mat[1].Stones)
+	fmt.Fprintf(tw, "mobility\t%d\t%d\n", mobility[0], mobility[1])
 
 	analysis := p.Analysis()
 
-	wr := p.White &^ p.Standing
-	br := p.Black &^ p.Standing
+	wr, br := p.RoadMask(tak.White), p.RoadMask(tak.Black)
 	wl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.Black, wr) &^ wr)
 	bl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.White, br) &^ br)
 
 	fmt.Fprintf(tw, "liberties\t%d\t%d\n", wl, bl)
+	fmt.Fprintf(tw, "center\t%d\t%d\n", m.centerControl(wr), m.centerControl(br))
+
+	wb, wc := m.blocking(p, p.White, p.Black, analysis.WhiteGroups, analysis.BlackGroups)
+	bb, bc := m.blocking(p, p.Black, p.White, analysis.BlackGroups, analysis.WhiteGroups)
+	fmt.Fprintf(tw, "blocking\t%d\t%d\n", wb, bb)
+	fmt.Fprintf(tw, "cap threats\t%d\t%d\n", wc, bc)
 
 	for i, g := range analysis.WhiteGroups {
 		w, h := bitboard.Dimensions(&m.c, g)
@@ -199,5 +491,6 @@ func ExplainScore(m *MinimaxAI, out io.Writer, p *tak.Position) {
 		w, h := bitboard.Dimensions(&m.c, g)
 		fmt.Fprintf(tw, "g%d\t\t%dx%x\n", i, w, h)
 	}
+	fmt.Fprintf(tw, "value\t%d\n", StaticEvaluate(m, p, opts))
 	tw.Flush()
 }
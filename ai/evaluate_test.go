@@ -0,0 +1,594 @@
+package ai
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestEvaluateFlatsTerminal(t *testing.T) {
+	cases := []struct {
+		tps    string
+		winner tak.Color
+	}{
+		{"1,2,1/2,1,2/1,2,1 1 6", tak.White},
+		{"1,2,1/2,1,2/1,2,1 2 5", tak.White},
+		{"2,1,2/1,2,1/2,1,2 1 6", tak.Black},
+		{"2,1,2/1,2,1/2,1,2 2 5", tak.Black},
+		{"1,2,1/2,1S,2/1,2,1 1 6", tak.NoColor},
+		{"1,2,1/2,1S,2/1,2,1 2 5", tak.NoColor},
+	}
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("parse %q: %v", tc.tps, e)
+		}
+		over, winner := p.GameOver()
+		if !over || winner != tc.winner {
+			t.Errorf("GameOver(%q)=(%v, %s) want (true, %s)",
+				tc.tps, over, winner, tc.winner)
+			continue
+		}
+		if d := p.WinDetails(); d.Reason != tak.FlatsWin {
+			t.Errorf("WinDetails(%q).Reason=%d, want FlatsWin", tc.tps, d.Reason)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+		v := ai.evaluate(ai, p, SearchContext{})
+		switch {
+		case tc.winner == tak.NoColor:
+			if v != 0 {
+				t.Errorf("evaluate(%q)=%d, want 0", tc.tps, v)
+			}
+		case tc.winner == p.ToMove():
+			if v < WinThreshold {
+				t.Errorf("evaluate(%q)=%d, want win", tc.tps, v)
+			}
+		default:
+			if v > -WinThreshold {
+				t.Errorf("evaluate(%q)=%d, want loss", tc.tps, v)
+			}
+		}
+	}
+}
+
+func TestEvaluateEndgameFlats(t *testing.T) {
+	cases := []struct {
+		tps   string
+		delta int64
+	}{
+		// full reserves: no adjustment
+		{"x4/x,1,2,x/x,2,1,x/1,x3 1 8", 0},
+		// black has two stones left and white leads by
+		// one flat
+		{"x4/x,1,2,x/x,2,1,x/222222222221,x3 1 8", 3 * 50},
+		{"x4/x,1,2,x/x,2,1,x/222222222221,x3 2 8", -3 * 50},
+	}
+	w := DefaultWeights
+	w.EndgameFlat, w.EndgameStones = 50, 5
+	eval := MakeEvaluator(&w)
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatal(e)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+		got := eval(ai, p) - DefaultEvaluate(ai, p)
+		if got != tc.delta {
+			t.Errorf("%s: endgame delta=%d want %d", tc.tps, got, tc.delta)
+		}
+	}
+}
+
+func TestEvaluateBlocking(t *testing.T) {
+	cases := []struct {
+		tps          string
+		white, black [2]int
+	}{
+		// no walls
+		{"x4,2/x,1,x,1,x/2,2,2,2,x/1,1,x,1,x/x5 1 6", [2]int{0, 0}, [2]int{0, 0}},
+		// white wall ends black's road
+		{"x4,2/x,1,x,1,x/2,2,2,2,1S/1,1,x,1,x/x5 2 6", [2]int{1, 0}, [2]int{0, 0}},
+		// a wall away from any group does not count
+		{"x4,2/x,1,x,1,x/2,2,2,2,x/1,1,x,1,x/1S,x4 2 6", [2]int{0, 0}, [2]int{0, 0}},
+		// black's capstone can flatten the blocking wall
+		{"x5/x3,2C,x/2,2,2,1S,x/1,1,x,1,x/x5 1 7", [2]int{1, 0}, [2]int{0, 1}},
+		// a capstone that does not touch the wall does not count
+		{"2C,x4/x5/2,2,2,1S,x/1,1,x,1,x/x5 1 7", [2]int{1, 0}, [2]int{0, 0}},
+	}
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("parse %q: %v", tc.tps, e)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+		a := p.Analysis()
+		var w, b [2]int
+		w[0], w[1] = ai.blocking(p, p.White, p.Black, a.WhiteGroups, a.BlackGroups)
+		b[0], b[1] = ai.blocking(p, p.Black, p.White, a.BlackGroups, a.WhiteGroups)
+		if w != tc.white || b != tc.black {
+			t.Errorf("blocking(%q) white=%v black=%v, want %v %v",
+				tc.tps, w, b, tc.white, tc.black)
+		}
+	}
+}
+
+func TestTempoEvaluator(t *testing.T) {
+	ai := NewMinimax(MinimaxConfig{Size: 5})
+	eval := MakeTempoEvaluator(&DefaultWeights, 100)
+	for _, tc := range []struct {
+		tps   string
+		delta int64
+	}{
+		{"x5/x,1,2,x2/x,2,1,x2/x2,1,x2/x5 2 4", 100},
+		// a finished game is not adjusted
+		{"x5/x5/1,1,1,1,1/2,2,2,2,x/x5 2 5", 0},
+	} {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatal(e)
+		}
+		base := DefaultEvaluate(ai, p)
+		if v := eval(ai, p, SearchContext{Ply: 2}); v != base {
+			t.Errorf("%s: even ply: %d != %d", tc.tps, v, base)
+		}
+		if v := eval(ai, p, SearchContext{Ply: 3}); v != base+tc.delta {
+			t.Errorf("%s: odd ply: %d, want %d", tc.tps, v, base+tc.delta)
+		}
+	}
+}
+
+func BenchmarkEvaluateLeaf(b *testing.B) {
+	p, e := ptn.ParseTPS("112S,12,1112S,x2/x2,121C,12S,x/1,21,2,2,2/x,2,1,1,1/2,x3,21 2 24")
+	if e != nil {
+		b.Fatal(e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+	ms := p.AllMoves(nil)
+	buf := p.Clone()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		child, e := p.MoveToAllocated(&ms[i%len(ms)], buf)
+		if e != nil {
+			continue
+		}
+		ai.evaluate(ai, child, SearchContext{})
+	}
+}
+
+// BenchmarkEvaluateTerms measures the default evaluator, with the
+// opt-in terms enabled, with each of its more expensive terms
+// disabled in turn, to show what each costs. The terms' work is
+// skipped when their weights are zero.
+func BenchmarkEvaluateTerms(b *testing.B) {
+	p, e := ptn.ParseTPS("112S,12,1112S,x2/x2,121C,12S,x/1,21,2,2,2/x,2,1,1,1/2,x3,21 2 24")
+	if e != nil {
+		b.Fatal(e)
+	}
+	cases := []struct {
+		name string
+		off  func(w *Weights)
+	}{
+		{"all", func(w *Weights) {}},
+		{"no-liberties", func(w *Weights) { w.Liberties = 0 }},
+		{"no-blocking", func(w *Weights) { w.BlockingWall, w.CapThreat = 0, 0 }},
+		{"no-mobility", func(w *Weights) { w.StackMobility = 0 }},
+		{"none", func(w *Weights) {
+			w.Liberties = 0
+			w.BlockingWall, w.CapThreat = 0, 0
+			w.StackMobility = 0
+		}},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			w := DefaultWeights
+			w.BlockingWall, w.CapThreat = 100, 100
+			w.StackMobility = 5
+			tc.off(&w)
+			eval := MakeEvaluator(&w)
+			ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+			ms := p.AllMoves(nil)
+			buf := p.Clone()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				child, e := p.MoveToAllocated(&ms[i%len(ms)], buf)
+				if e != nil {
+					continue
+				}
+				eval(ai, child)
+			}
+		})
+	}
+}
+
+func TestEvaluateStackMobility(t *testing.T) {
+	cases := []struct {
+		tps   string
+		delta int64
+	}{
+		// a stack boxed into the corner by walls
+		{"x5/x5/x5/2S,x4/221,2S,x3 1 10", 0},
+		// the same stack with room to move
+		{"x5/x5/2S,x4/x5/221,x,2S,x2 1 10", 2 * 2 * 5},
+		// a capstone can flatten the walls
+		{"x5/x5/x5/2S,x4/221C,2S,x3 1 10", 2 * 2 * 5},
+		// but not move onto a capstone
+		{"x5/x5/x5/2C,x4/221C,2S,x3 1 10", 1 * 2 * 5},
+		// the opponent's stacks count against us
+		{"x5/x5/2S,x4/x5/221,x,2S,x2 2 10", -2 * 2 * 5},
+	}
+	w := DefaultWeights
+	w.StackMobility = 5
+	eval := MakeEvaluator(&w)
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("parse %q: %v", tc.tps, e)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+		if got := eval(ai, p) - DefaultEvaluate(ai, p); got != tc.delta {
+			t.Errorf("%s: mobility delta=%d want %d", tc.tps, got, tc.delta)
+		}
+	}
+}
+
+func TestDiffScore(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x,2,x3/x,1,21,x2/x,1,2S,x2/x5 1 6")
+	if e != nil {
+		t.Fatal(e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+	if b := Breakdown(ai, p); b.Value != ai.evaluate(ai, p, SearchContext{}) {
+		t.Errorf("Breakdown.Value=%d evaluate=%d", b.Value, ai.evaluate(ai, p, SearchContext{}))
+	}
+	for _, m := range p.AllMoves(nil) {
+		child, e := p.Move(&m)
+		if e != nil {
+			continue
+		}
+		b := Breakdown(ai, child)
+		if v := ai.evaluate(ai, child, SearchContext{}); b.Value != v {
+			t.Errorf("%s: Breakdown.Value=%d evaluate=%d", ptn.FormatMove(&m), b.Value, v)
+		}
+		d := DiffScore(ai, p, child)
+		if sum := d.Terms.Total(); sum != d.Total() {
+			t.Errorf("%s: sum(Terms)=%d Total=%d", ptn.FormatMove(&m), sum, d.Total())
+		}
+	}
+
+	wall := tak.Move{X: 4, Y: 4, Type: tak.PlaceStanding}
+	child, e := p.Move(&wall)
+	if e != nil {
+		t.Fatal(e)
+	}
+	d := DiffScore(ai, p, child)
+	if got, want := d.Terms[TermStanding], int64(DefaultWeights.Standing); got != want {
+		t.Errorf("standing delta=%d want %d", got, want)
+	}
+	if got, want := d.Terms[TermTempo], -2*int64(DefaultWeights.Tempo); got != want {
+		t.Errorf("tempo delta=%d want %d", got, want)
+	}
+	if got := d.Terms[TermTopFlats]; got != 0 {
+		t.Errorf("flats delta=%d want 0", got)
+	}
+}
+
+func TestMateInPlies(t *testing.T) {
+	// ply 10, White to move
+	p, e := ptn.ParseTPS("x5/x5/x5/x5/x5 1 6")
+	if e != nil {
+		t.Fatal(e)
+	}
+	cases := []struct {
+		v     int64
+		mate  bool
+		plies int
+	}{
+		{0, false, 0},
+		{1000, false, 0},
+		{WinThreshold, false, 0},
+		{maxEval - 11*mateScale + 20, true, 1},
+		{maxEval - 11*mateScale, true, 1},
+		{-(maxEval - 12*mateScale + 5), true, 2},
+		{maxEval - 15*mateScale + mateScale - 1, true, 5},
+	}
+	for _, tc := range cases {
+		if mate, plies := MateInPlies(p, tc.v); mate != tc.mate || plies != tc.plies {
+			t.Errorf("MateInPlies(%d)=(%v, %d), want (%v, %d)", tc.v, mate, plies, tc.mate, tc.plies)
+		}
+	}
+
+	// Black cannot block both of White's roads
+	p, e = ptn.ParseTPS("2,x,2,x,2/x,2,x3/1,1,1,1,x/x5/1,1,1,1,x 2 5")
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, v, _ := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3}).Analyze(p, 0)
+	if mate, plies := MateInPlies(p, v); v > 0 || !mate || plies != 2 {
+		t.Errorf("double threat: v=%d MateInPlies=(%v, %d), want loss in 2", v, mate, plies)
+	}
+}
+
+func TestEvaluateSquares(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x5/x2,1,x2/x5/2,x4 1 2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	center := make([]int, 25)
+	center[2+2*5] = 100
+	w := DefaultWeights
+	w.Squares = center
+	ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+	// 23 of 25 squares are empty
+	if got := MakeEvaluator(&w)(ai, p) - DefaultEvaluate(ai, p); got != 100*23/25 {
+		t.Errorf("center bonus=%d want %d", got, 100*23/25)
+	}
+	w.Squares = center[:9]
+	if got := MakeEvaluator(&w)(ai, p) - DefaultEvaluate(ai, p); got != 0 {
+		t.Errorf("wrong-size table: bonus=%d", got)
+	}
+}
+
+func TestEvaluateBridges(t *testing.T) {
+	p, e := ptn.ParseTPS("2,2,x3/x5/x5/1,x,1,x2/1,x,1,x2 1 4")
+	if e != nil {
+		t.Fatal(e)
+	}
+	w := DefaultWeights
+	w.Bridge = 50
+	ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+	// b1 and b2 each join White's two groups
+	if got := MakeEvaluator(&w)(ai, p) - DefaultEvaluate(ai, p); got != 2*50 {
+		t.Errorf("bridge bonus=%d want %d", got, 2*50)
+	}
+}
+
+func TestEvaluateFlatLead(t *testing.T) {
+	w := DefaultWeights
+	w.FlatLead, w.FlatLeadFill = 100, 50
+	cases := []struct {
+		tps  string
+		want int64
+	}{
+		// 8 of 16 squares occupied: the term has not started
+		{"1,1,1,2/1,1,2,1/x4/x4 1 5", 0},
+		// 12 of 16, with White 2 flats ahead: half weight
+		{"1,2,1,2/1,1,2,1/2,1,1,2/x4 1 7", 2 * 100 * 4 / 8},
+		// Black 2 flats ahead
+		{"2,1,2,1/2,2,1,2/1,2,2,1/x4 2 7", -2 * 100 * 4 / 8},
+	}
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatal(e)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: p.Size(), Weights: &w})
+		b := Breakdown(ai, p)
+		if b.Terminal {
+			t.Fatalf("%s: game over", tc.tps)
+		}
+		if got := b.White[TermFlatLead] - b.Black[TermFlatLead]; got != tc.want {
+			t.Errorf("%s: White's flat lead term=%d want %d", tc.tps, got, tc.want)
+		}
+	}
+}
+
+func TestStaticEvaluateNoTempo(t *testing.T) {
+	white, e := ptn.ParseTPS("x5/x,2,x3/x,1,21,x2/x,1,2S,x2/x5 1 6")
+	if e != nil {
+		t.Fatal(e)
+	}
+	black, e := ptn.ParseTPS("x5/x,2,x3/x,1,21,x2/x,1,2S,x2/x5 2 6")
+	if e != nil {
+		t.Fatal(e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: white.Size()})
+	w := StaticEvaluate(ai, white, StaticOptions{})
+	b := StaticEvaluate(ai, black, StaticOptions{})
+	if w-b != 2*int64(DefaultWeights.Tempo) {
+		t.Errorf("with tempo: white to move=%d black to move=%d", w, b)
+	}
+	if w != WhiteValue(white, ai.evaluate(ai, white, SearchContext{})) {
+		t.Errorf("StaticEvaluate=%d, evaluate=%d", w, ai.evaluate(ai, white, SearchContext{}))
+	}
+	w = StaticEvaluate(ai, white, StaticOptions{NoTempo: true})
+	b = StaticEvaluate(ai, black, StaticOptions{NoTempo: true})
+	if w != b {
+		t.Errorf("without tempo: white to move=%d black to move=%d", w, b)
+	}
+
+	var buf bytes.Buffer
+	ExplainScoreWith(ai, &buf, black, StaticOptions{NoTempo: true})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got, want := strings.Fields(lines[len(lines)-1]), []string{"value", fmt.Sprint(b)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExplainScoreWith ends with %q, want %q", got, want)
+	}
+}
+
+func TestRankTerms(t *testing.T) {
+	p, e := ptn.ParseTPS("112S,12,1112S,x2/x2,121C,12S,x/1,21,2,2,2/x,2,1,1,1/2,x3,21 2 24")
+	if e != nil {
+		t.Fatal(e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+	opts := StaticOptions{NoTempo: true}
+	ranked := RankTerms(ai, p, opts)
+	if len(ranked) == 0 {
+		t.Fatal("no terms")
+	}
+	var total int64
+	var shares float64
+	for i, c := range ranked {
+		if c.Value == 0 || c.Term == TermTempo {
+			t.Errorf("ranked %s=%d", c.Term, c.Value)
+		}
+		if i > 0 && abs(c.Value) > abs(ranked[i-1].Value) {
+			t.Errorf("%s=%d ranked below %s=%d", c.Term, c.Value, ranked[i-1].Term, ranked[i-1].Value)
+		}
+		if (c.Share < 0) != (c.Value < 0) {
+			t.Errorf("%s: share %f has the wrong sign", c.Term, c.Share)
+		}
+		total += c.Value
+		shares += math.Abs(c.Share)
+	}
+	if v := StaticEvaluate(ai, p, opts); total != v {
+		t.Errorf("terms sum to %d, evaluation=%d", total, v)
+	}
+	if math.Abs(shares-1) > 1e-9 {
+		t.Errorf("shares sum to %f", shares)
+	}
+
+	var buf bytes.Buffer
+	ExplainScoreWith(ai, &buf, p, StaticOptions{NoTempo: true, Ranked: true})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(ranked)+2 {
+		t.Fatalf("ranked explanation:\n%s", buf.String())
+	}
+	top := strings.Fields(lines[1])
+	if top[len(top)-2] != fmt.Sprintf("%+d", ranked[0].Value) {
+		t.Errorf("first line %q, want %s=%+d", lines[1], ranked[0].Term, ranked[0].Value)
+	}
+	if got, want := strings.Fields(lines[len(lines)-1]), []string{"value", fmt.Sprint(total)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ranked explanation ends with %q, want %q", got, want)
+	}
+
+	over, e := ptn.ParseTPS("1,1,1/x3/2,2,x 2 3")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if got := RankTerms(NewMinimax(MinimaxConfig{Size: 3}), over, opts); got != nil {
+		t.Errorf("game over: %v", got)
+	}
+}
+
+func TestRoadWinPreference(t *testing.T) {
+	// White wins on the third ply either by road, starting with
+	// b1+, or on flats, starting with a2.
+	p, err := ptn.ParseTPS("1122S,x,1S/x,212,1/1S,11,x 1 17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		roadWin int
+		reason  tak.WinReason
+	}{
+		{100, tak.RoadWin},
+		{-100, tak.FlatsWin},
+	} {
+		w := DefaultWeights
+		w.RoadWin = tc.roadWin
+		m := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, Deterministic: true, Weights: &w})
+		pv, v, _ := m.Analyze(p, 0)
+		if mate, plies := MateInPlies(p, v); !mate || plies != 3 || v < 0 {
+			t.Errorf("RoadWin=%d: pv=%s v=%d, want a win in 3", tc.roadWin, formatpv(pv), v)
+			continue
+		}
+		end := p
+		for i := range pv {
+			if end, err = end.Move(&pv[i]); err != nil {
+				t.Fatalf("RoadWin=%d: pv=%s: %v", tc.roadWin, formatpv(pv), err)
+			}
+		}
+		if d := end.WinDetails(); !d.Over || d.Winner != tak.White || d.Reason != tc.reason {
+			t.Errorf("RoadWin=%d: pv=%s ends %+v", tc.roadWin, formatpv(pv), d)
+		}
+	}
+
+	// the bonus never changes the ply a win is valued at
+	won, err := ptn.ParseTPS("1,1,1/x3/2,2,x 2 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := DefaultWeights
+	w.RoadWin = 1 << 20
+	v, _ := terminalValue(won, w.RoadWin)
+	plain, _ := terminalValue(won, 0)
+	// Black is to move, and lost
+	if plain-v != maxWinBonus {
+		t.Errorf("bonus=%d, want %d", plain-v, maxWinBonus)
+	}
+	if _, plies := MateInPlies(won, v); plies != 0 {
+		t.Errorf("MateInPlies=%d with a large bonus", plies)
+	}
+
+	// nor does it with the most reserve stones a game can have
+	won, err = ptn.ParseTPSWith("1,1,1/x3/2,2,x 2 3", tak.Config{Pieces: tak.MaxPieces})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ = terminalValue(won, w.RoadWin)
+	if _, plies := MateInPlies(won, v); plies != 0 {
+		t.Errorf("MateInPlies=%d with %d reserve stones", plies, won.WhiteStones())
+	}
+}
+
+func TestEvaluateCenterControl(t *testing.T) {
+	// White's flat is on a square two rings from the center in
+	// one, and one ring in the other; both squares have four
+	// empty neighbors, and nothing else about them is scored
+	outer, e := ptn.ParseTPS("x7/x7/x7/x7/x,1,x5/x7/x6,2 2 2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	inner, e := ptn.ParseTPS("x7/x7/x7/x7/x2,1,x4/x7/x6,2 2 2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: 7})
+	if a, b := DefaultEvaluate(ai, outer), DefaultEvaluate(ai, inner); a != b {
+		t.Fatalf("without CenterControl: outer=%d inner=%d", a, b)
+	}
+	w := DefaultWeights
+	w.CenterControl = 30
+	eval := MakeEvaluator(&w)
+	// Black, to move, would rather White's flat were farther out
+	if got := eval(ai, outer) - eval(ai, inner); got != 30 {
+		t.Errorf("outer-inner=%d, want %d", got, 30)
+	}
+	b := Breakdown(NewMinimax(MinimaxConfig{Size: 7, Weights: &w}), inner)
+	if b.White[TermCenter] != 2*30 || b.Black[TermCenter] != 0 {
+		t.Errorf("center terms: white=%d black=%d", b.White[TermCenter], b.Black[TermCenter])
+	}
+}
+
+func TestEvaluateCapturedColor(t *testing.T) {
+	w := DefaultWeights
+	w.CapturedOwn, w.CapturedEnemy = -10, 40
+	ai := NewMinimax(MinimaxConfig{Size: 5, Weights: &w})
+	cases := []struct {
+		tps          string
+		white, black int64
+		captured     int64
+	}{
+		// White buries a Black flat, or one of its own
+		{"x5/x5/x2,21,x2/x5/x4,2 2 3", 25 + 40, 0, 1},
+		{"x5/x5/x2,11,x2/x5/x4,2 2 3", 25 - 10, 0, 1},
+		// Black buries a White flat
+		{"x5/x5/x2,12,x2/x5/x4,1 1 4", 0, 25 + 40, 1},
+		// only the top four stones below the top are counted,
+		// and Black's at the bottom is not among them
+		{"x5/x5/x2,2111111,x2/x5/x4,2 2 8", 4 * (25 - 10), 0, 4},
+	}
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatal(e)
+		}
+		b := Breakdown(ai, p)
+		if b.White[TermCaptured] != tc.white || b.Black[TermCaptured] != tc.black {
+			t.Errorf("%s: captured white=%d black=%d, want %d %d",
+				tc.tps, b.White[TermCaptured], b.Black[TermCaptured], tc.white, tc.black)
+		}
+		// by default, the color of the captives does not matter
+		def := Breakdown(NewMinimax(MinimaxConfig{Size: 5}), p)
+		if def.White[TermCaptured]+def.Black[TermCaptured] != 25*tc.captured {
+			t.Errorf("%s: default captured white=%d black=%d",
+				tc.tps, def.White[TermCaptured], def.Black[TermCaptured])
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package ai
 
 import (
-	"fmt"
 	"log"
 	"math"
 	"math/rand"
@@ -14,12 +13,29 @@ import (
 	"github.com/nelhage/taktician/tak"
 )
 
-type MonteCarloAI struct {
-	limit time.Duration
-	c     float64
-	r     *rand.Rand
+const defaultPlayouts = 10000
 
+type MonteCarloConfig struct {
 	Debug int
+	Seed  int64
+
+	// Limit caps the time spent per move, in addition to the
+	// limit passed to GetMove.
+	Limit time.Duration
+	// Playouts caps the number of random playouts per move. If
+	// both Playouts and the time limit are zero, a default of
+	// 10000 playouts is used.
+	Playouts int
+	// C is the UCT exploration constant.
+	C float64
+}
+
+type MonteCarloAI struct {
+	cfg MonteCarloConfig
+	r   *rand.Rand
+
+	moves   []tak.Move
+	scratch [2]*tak.Position
 }
 
 type tree struct {
@@ -38,12 +54,19 @@ func (ai *MonteCarloAI) GetMove(p *tak.Position, limit time.Duration) tak.Move {
 	}
 	ai.populate(tree)
 	start := time.Now()
-	if ai.limit < limit {
-		limit = ai.limit
+	if ai.cfg.Limit != 0 && (limit == 0 || ai.cfg.Limit < limit) {
+		limit = ai.cfg.Limit
+	}
+	playouts := ai.cfg.Playouts
+	if playouts == 0 && limit == 0 {
+		playouts = defaultPlayouts
 	}
-	for time.Now().Sub(start) < limit {
+	for i := 0; playouts == 0 || i < playouts; i++ {
+		if limit != 0 && time.Now().Sub(start) >= limit {
+			break
+		}
 		node := ai.descend(tree)
-		if ai.Debug > 3 {
+		if ai.cfg.Debug > 3 {
 			var s []string
 			t := node
 			for t.parent != nil {
@@ -58,15 +81,15 @@ func (ai *MonteCarloAI) GetMove(p *tak.Position, limit time.Duration) tak.Move {
 	}
 	best := tree.children[0]
 	for _, c := range tree.children {
-		if ai.Debug > 2 {
+		if ai.cfg.Debug > 2 {
 			log.Printf("[mcts][%s]: n=%d w=%d", ptn.FormatMove(&c.move), c.simulations, c.wins)
 		}
 		if c.simulations > best.simulations {
 			best = c
 		}
 	}
-	if ai.Debug > 1 {
-		fmt.Printf("[mcts] evaluated simulations=%d wins=%d", tree.simulations, tree.wins)
+	if ai.cfg.Debug > 1 {
+		log.Printf("[mcts] evaluated simulations=%d wins=%d", tree.simulations, tree.wins)
 	}
 	return best.move
 }
@@ -99,7 +122,7 @@ func (ai *MonteCarloAI) descend(t *tree) *tree {
 			s = 10
 		} else {
 			s = float64(c.wins)/float64(c.simulations) +
-				ai.c*math.Sqrt(math.Log(float64(t.simulations))/float64(c.simulations))
+				ai.cfg.C*math.Sqrt(math.Log(float64(t.simulations))/float64(c.simulations))
 		}
 		if s > val {
 			best = c
@@ -111,23 +134,30 @@ func (ai *MonteCarloAI) descend(t *tree) *tree {
 
 const maxMoves = 300
 
+// evaluate performs a single random playout from t, returning
+// whether the player to move at t won. Playouts alternate between
+// two preallocated scratch positions, so they do not allocate.
 func (ai *MonteCarloAI) evaluate(t *tree) bool {
 	p := t.position
+	if ai.scratch[0] == nil || ai.scratch[0].Size() != p.Size() {
+		ai.scratch[0] = p.Clone()
+		ai.scratch[1] = p.Clone()
+	}
 	for i := 0; i < maxMoves; i++ {
-		moves := p.AllMoves(nil)
+		moves := p.AllMoves(ai.moves[:0])
+		ai.moves = moves
 		var next *tak.Position
-		for {
+		for len(moves) > 0 {
 			r := ai.r.Int31n(int32(len(moves)))
-			m := moves[r]
 			var e error
-			if next, e = p.Move(&m); e == nil {
+			if next, e = p.MoveToAllocated(&moves[r], ai.scratch[i%2]); e == nil {
 				break
 			}
 			moves[0], moves[r] = moves[r], moves[0]
 			moves = moves[1:]
 		}
 		if next == nil {
-			if ai.Debug > 3 {
+			if ai.cfg.Debug > 3 {
 				log.Printf("[mcts][aborted due looping]")
 				cli.RenderBoard(os.Stderr, p)
 			}
@@ -151,10 +181,12 @@ func (ai *MonteCarloAI) update(t *tree, win bool) {
 	}
 }
 
-func NewMonteCarlo(limit time.Duration) *MonteCarloAI {
+func NewMonteCarlo(cfg MonteCarloConfig) *MonteCarloAI {
+	if cfg.C == 0 {
+		cfg.C = 0.7
+	}
 	return &MonteCarloAI{
-		limit: limit,
-		c:     0.7,
-		r:     rand.New(rand.NewSource(0)),
+		cfg: cfg,
+		r:   rand.New(rand.NewSource(cfg.Seed)),
 	}
 }
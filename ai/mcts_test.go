@@ -0,0 +1,21 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+)
+
+func TestMonteCarloPlayouts(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	ai := NewMonteCarlo(MonteCarloConfig{Playouts: 200, Seed: 1})
+	m := ai.GetMove(p, 0)
+	if _, e := p.Move(&m); e != nil {
+		t.Fatalf("mcts returned illegal move: %s: %v", ptn.FormatMove(&m), e)
+	}
+	again := NewMonteCarlo(MonteCarloConfig{Playouts: 200, Seed: 1}).GetMove(p, 0)
+	if !m.Equal(&again) {
+		t.Errorf("same seed produced %s and %s",
+			ptn.FormatMove(&m), ptn.FormatMove(&again))
+	}
+}
@@ -2,9 +2,13 @@ package ai
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"time"
+	"unsafe"
 
 	"github.com/nelhage/taktician/bitboard"
 	"github.com/nelhage/taktician/ptn"
@@ -18,11 +22,61 @@ const (
 
 	tableSize uint64 = (1 << 20)
 
+	// maxStack is the least depth of search stack allocated;
+	// engines configured to search deeper get a deeper stack.
+	// Singular extensions are limited to the stack's depth.
 	maxStack = 10
+
+	// singularDepth is the shallowest depth at which Singular
+	// extensions are tried, and singularMargin how far below the
+	// table value every other move must fall for the table move
+	// to count as singular.
+	singularDepth        = 4
+	singularMargin int64 = 150
+
+	// defaultStableExitMargin is the default for
+	// MinimaxConfig.StableExitMargin.
+	defaultStableExitMargin = 200
+
+	// nullMoveReduction is how much shallower than the node
+	// itself the null move is searched, and so the least depth at
+	// which it is tried is nullMoveReduction+1.
+	nullMoveReduction = 2
+	// nullMoveKey is mixed into the table's hashes below a null
+	// move. Hashes do not include the player to move, and the
+	// positions below a null move have the other player to move
+	// than the same boards elsewhere in the search.
+	nullMoveKey uint64 = 0x9e3779b97f4a7c15
 )
 
 type EvaluationFunc func(m *MinimaxAI, p *tak.Position) int64
 
+// SearchContext describes the node of the search tree at which a
+// position is being evaluated.
+type SearchContext struct {
+	// Ply is the number of moves between the root of the search
+	// and the evaluated position; when it is odd, the player to
+	// move at the root made the last move.
+	Ply int
+	// Depth is the nominal depth of the current iteration.
+	Depth int
+	// PV is set if the position was searched with an open
+	// window, and so may lie on the principal variation.
+	PV bool
+}
+
+// ContextEvaluationFunc is an EvaluationFunc that is also told where
+// in the search the evaluation is taking place.
+type ContextEvaluationFunc func(m *MinimaxAI, p *tak.Position, ctx SearchContext) int64
+
+// WithContext adapts f to a ContextEvaluationFunc that ignores its
+// context.
+func (f EvaluationFunc) WithContext() ContextEvaluationFunc {
+	return func(m *MinimaxAI, p *tak.Position, _ SearchContext) int64 {
+		return f(m, p)
+	}
+}
+
 type MinimaxAI struct {
 	cfg  MinimaxConfig
 	rand *rand.Rand
@@ -32,17 +86,70 @@ type MinimaxAI struct {
 
 	heatMap []uint64
 
-	evaluate EvaluationFunc
+	evaluate ContextEvaluationFunc
 
-	table []tableEntry
-	stack [maxStack]struct {
-		p     *tak.Position
-		moves [100]tak.Move
-	}
+	// cancel, if non-nil, aborts the search in progress when
+	// closed; aborted records that this has happened.
+	cancel  <-chan struct{}
+	aborted bool
+	// deadline, if non-zero, also aborts the search in progress
+	// once it passes.
+	deadline time.Time
+	// now is the clock against which time limits are measured:
+	// time.Now, except in tests.
+	now func() time.Time
+
+	// path holds the hashes of the positions on the current
+	// search path, indexed by ply, to detect repetitions.
+	path []uint64
+
+	// history holds the positions of the game before the
+	// position being analyzed; see SetHistory.
+	history map[historyKey]bool
+	// repetitions counts the repetitions found by the search,
+	// whether of positions earlier on the search path or in the
+	// game history, so that values depending on them are kept
+	// out of the transposition table: they depend on the path to
+	// the position, and not just on the position itself.
+	repetitions uint64
+
+	// scratch is a position to apply moves to when only their
+	// legality matters.
+	scratch *tak.Position
+
+	table    []tableBucket
+	ttFilled uint64
+	gen      uint32
+	stack    []stackFrame
+
+	// moves is the configured MoveSource.
+	moves MoveSource
+
+	// evalCache is the evaluation cache, if configured.
+	evalCache []evalEntry
+
+	// nullKey is nullMoveKey while searching below a null move,
+	// and zero otherwise.
+	nullKey uint64
 }
 
+// stackFrame holds the storage used by the search at one ply.
+type stackFrame struct {
+	p     *tak.Position
+	moves [100]tak.Move
+	keys  []moveKey
+	sort  sortMoves
+}
+
+// tableBucket holds two entries for positions hashing to the same
+// slot. The first is depth-preferred: it is only replaced by a
+// deeper search, or once it is left over from an earlier call to
+// Analyze. The second is always replaced.
+type tableBucket [2]tableEntry
+
 type tableEntry struct {
 	hash  uint64
+	gen   uint32
 	depth int
 	value int64
 	bound boundType
@@ -58,6 +165,18 @@ const (
 	upperBound = iota
 )
 
+func (b boundType) String() string {
+	switch b {
+	case lowerBound:
+		return "lower"
+	case exactBound:
+		return "exact"
+	case upperBound:
+		return "upper"
+	}
+	return fmt.Sprintf("boundType(%d)", b)
+}
+
 type Stats struct {
 	Depth     int
 	Generated uint64
@@ -73,6 +192,50 @@ type Stats struct {
 	AllNodes uint64
 
 	TTHits uint64
+	// TTStores counts transposition-table writes, and
+	// TTReplacements the subset of those that evicted an entry
+	// for a different position. TTCollisions counts lookups
+	// that found a slot occupied by a different position.
+	TTStores       uint64
+	TTReplacements uint64
+	TTCollisions   uint64
+	// TTFill is the fraction of table slots in use at the end of
+	// the iteration.
+	TTFill float64
+	// TTIllegal counts table entries whose stored move was not
+	// legal in the position being searched, which means a hash
+	// collision.
+	TTIllegal uint64
+
+	Reduced    uint64
+	ReSearched uint64
+	// Singular counts table moves extended by a ply because
+	// they were found to be singular.
+	Singular uint64
+	// EvalCacheHits counts the evaluations, included in
+	// Evaluated, answered from the evaluation cache.
+	EvalCacheHits uint64
+	// NullMoves counts null-move searches, and NullCuts those
+	// that cut the node off.
+	NullMoves uint64
+	NullCuts  uint64
+
+	// CompletedDepth is the depth of the last iteration of
+	// iterative deepening that ran to completion, and
+	// TimePerDepth the wall time taken by each completed
+	// iteration, in order. Unlike the counters, they cover the
+	// whole analysis.
+	CompletedDepth int
+	TimePerDepth   []time.Duration
+
+	// Forced is set if the search was skipped because the root
+	// position had an immediately winning move or only one legal
+	// move.
+	Forced bool
+	// StableExit is set if iterative deepening stopped short of
+	// the configured depth because the best move was stable; see
+	// MinimaxConfig.StableExitPlies.
+	StableExit bool
 }
 
 type MinimaxConfig struct {
@@ -84,37 +247,245 @@ type MinimaxConfig struct {
 	NoSort  bool
 	NoTable bool
 
+	// MoveSource, if set, generates and orders the moves at
+	// interior nodes instead of DefaultMoveSource, to experiment
+	// with move ordering.
+	MoveSource MoveSource
+
+	// MaxCarry, if positive, limits the search to slides that
+	// carry at most that many stones, as generated by
+	// tak.Position.AllMovesCarry, at the root and by
+	// DefaultMoveSource. Tall stacks have many ways to spread,
+	// so a small limit speeds up searches of positions that have
+	// them, but the moves it leaves out may be the best ones,
+	// including winning ones. Zero, the default, searches every
+	// legal move.
+	MaxCarry int
+
+	// LMR enables late-move reductions: quiet moves ordered
+	// late in the move list are searched one ply shallower, and
+	// re-searched at full depth only if they raise alpha.
+	LMR bool
+
+	// Singular enables singular extensions: at nodes where the
+	// table move is much better than every alternative, as
+	// judged by a reduced-depth search of the alternatives, the
+	// table move is searched one ply deeper. This helps find deep
+	// forcing lines, at the cost of the verification searches.
+	Singular bool
+
+	// NullMove enables null-move pruning: at nodes outside the
+	// principal variation, the player to move first tries
+	// passing, searched with a reduced depth, and if even that
+	// fails high the node is cut off without searching any
+	// moves. The assumption that a move is better than passing
+	// fails near the end of the game, so it is not tried when
+	// the position is tak.Position.NearZugzwang, or when passing
+	// would let the opponent complete a road by placing a stone
+	// (see tak.Position.HasRoadThreat).
+	NullMove bool
+
+	// Deterministic disables seeding from the wall clock and
+	// searches root moves in PTN order instead of shuffling
+	// them, so that equal-valued moves are chosen stably. Results
+	// can still vary if a time limit cuts the search short.
+	//
+	// With Deterministic or a nonzero Seed, a fresh MinimaxAI
+	// analyzing a position with no time limit visits exactly the
+	// same nodes every time, so its Stats counters are
+	// reproducible. What remains nondeterministic is anything
+	// timed: a limit or clock passed to Analyze, which decide
+	// whether to start and when to abandon an iteration, and
+	// cancellation of AnalyzeStream. An engine also carries its
+	// transposition table and move-ordering history from one
+	// analysis to the next, so only its first analysis is
+	// reproducible.
+	Deterministic bool
+
+	// RandSource, if set, supplies the source of every random
+	// decision the engine makes: the order of the root moves, and
+	// the choice among moves under Temperature. It is called at
+	// the start of each analysis with the seed that would
+	// otherwise seed a rand.NewSource, chosen as described at
+	// Deterministic, so that tests can replay or script the
+	// engine's choices.
+	RandSource func(seed int64) rand.Source
+
+	// OnIteration, if set, is called after each iteration of
+	// iterative deepening completes, with the depth searched,
+	// the value and principal variation found, and the
+	// iteration's statistics.
+	OnIteration func(depth int, v int64, pv []tak.Move, st Stats)
+	// WhiteIteration reports values to OnIteration from White's
+	// perspective, as WhiteValue does, instead of from the
+	// perspective of the side to move.
+	WhiteIteration bool
+
 	Evaluate EvaluationFunc
+	// Weights, if set, configures the default evaluator, which is
+	// used unless Evaluate or EvaluateContext is set. Breakdown
+	// and DiffScore always use it; it defaults to DefaultWeights.
+	Weights *Weights
+	// Contempt is how much worse than an even position the
+	// engine considers a draw, whether by repeating a position
+	// within the search or by a tie on flats. A positive value
+	// makes the engine prefer to play on in positions it judges
+	// slightly worse than even.
+	Contempt int64
+
+	// TimeMargin is how much of a search's time limit to hold in
+	// reserve. When searching under a limit, an iteration of
+	// iterative deepening that is still running once less than
+	// TimeMargin of the limit remains is abandoned, and the
+	// previous iteration's result returned. The first iteration
+	// always runs to completion.
+	TimeMargin time.Duration
+
+	// StableExitPlies, if positive, ends iterative deepening
+	// early once the best move has stayed the same for that many
+	// iterations after the one that first chose it, with the
+	// value changing by no more than StableExitMargin from each
+	// of those iterations to the next. StableExitMargin defaults
+	// to defaultStableExitMargin.
+	StableExitPlies  int
+	StableExitMargin int64
+
+	// Temperature, if positive, makes GetMove choose at random,
+	// using the seeded random source, among the moves whose value
+	// is within Temperature of the best move's, instead of always
+	// playing the best move. Forced wins and losses are always
+	// played out exactly.
+	Temperature int64
+
+	// TableMemory, if positive, limits the transposition table
+	// to about that many bytes, instead of the default of 1<<20
+	// entries. A smaller table makes the search less efficient,
+	// but does not otherwise change it.
+	TableMemory int64
+
+	// EvalCache, if positive, caches the evaluations of up to
+	// that many leaves of the search, by position, so that a leaf
+	// reached again through a transposition is not evaluated
+	// again. The evaluator must depend only on the position; the
+	// cache is not used with EvaluateContext.
+	EvalCache int
+
+	// Logger, if set, receives the engine's debug output, as
+	// enabled by Debug, instead of the standard logger.
+	Logger *log.Logger
+
+	// EvaluateContext, if set, is used instead of Evaluate.
+	EvaluateContext ContextEvaluationFunc
 }
 
+// NewMinimax returns an engine for cfg. It panics if cfg.Size is not
+// between tak.MinSize and tak.MaxSize; callers whose size comes from
+// user input should use NewMinimaxChecked instead.
 func NewMinimax(cfg MinimaxConfig) *MinimaxAI {
-	m := &MinimaxAI{cfg: cfg}
+	m, err := NewMinimaxChecked(cfg)
+	if err != nil {
+		panic("NewMinimax: " + err.Error())
+	}
+	return m
+}
+
+// NewMinimaxChecked is like NewMinimax, but returns an error, rather
+// than panicking, if cfg.Size is unsupported.
+func NewMinimaxChecked(cfg MinimaxConfig) (*MinimaxAI, error) {
+	if cfg.Size < tak.MinSize || cfg.Size > tak.MaxSize {
+		return nil, fmt.Errorf("unsupported board size: %d", cfg.Size)
+	}
+	m := &MinimaxAI{cfg: cfg, now: time.Now}
 	m.precompute()
-	m.evaluate = cfg.Evaluate
-	if m.evaluate == nil {
-		m.evaluate = DefaultEvaluate
+	switch {
+	case cfg.EvaluateContext != nil:
+		m.evaluate = cfg.EvaluateContext
+	case cfg.Evaluate != nil:
+		m.evaluate = cfg.Evaluate.WithContext()
+	case cfg.Weights != nil:
+		m.evaluate = MakeEvaluator(cfg.Weights).WithContext()
+	default:
+		m.evaluate = DefaultEvaluate.WithContext()
 	}
 	m.heatMap = make([]uint64, m.cfg.Size*m.cfg.Size)
-	m.table = make([]tableEntry, tableSize)
+	m.moves = cfg.MoveSource
+	if m.moves == nil {
+		m.moves = DefaultMoveSource
+	}
+	m.table = make([]tableBucket, m.tableBuckets())
+	if cfg.EvalCache > 0 && cfg.EvaluateContext == nil {
+		m.evalCache = make([]evalEntry, cfg.EvalCache)
+	}
+	depth := maxStack
+	if cfg.Depth >= depth {
+		depth = cfg.Depth + 1
+	}
+	m.path = make([]uint64, depth+1)
+	m.stack = make([]stackFrame, depth)
 	for i := range m.stack {
 		m.stack[i].p = tak.Alloc(m.cfg.Size)
 	}
-	return m
+	m.scratch = tak.Alloc(m.cfg.Size)
+	return m, nil
+}
+
+// tableBuckets returns the number of buckets to allocate for the
+// transposition table: enough for tableSize entries, or as many as
+// fit in cfg.TableMemory, if that is set.
+func (m *MinimaxAI) tableBuckets() int {
+	if m.cfg.TableMemory <= 0 {
+		return int(tableSize / 2)
+	}
+	n := m.cfg.TableMemory / int64(unsafe.Sizeof(tableBucket{}))
+	if n < 1 {
+		n = 1
+	}
+	return int(n)
 }
 
 func (m *MinimaxAI) ttGet(h uint64) *tableEntry {
 	if m.cfg.NoTable {
 		return nil
 	}
-	te := &m.table[h%tableSize]
-	if te.hash != h {
-		return nil
+	b := &m.table[h%uint64(len(m.table))]
+	for i := range b {
+		if b[i].hash == h {
+			return &b[i]
+		}
 	}
-	return te
+	if b[0].hash != 0 {
+		m.st.TTCollisions++
+	}
+	return nil
 }
 
-func (m *MinimaxAI) ttPut(h uint64) *tableEntry {
-	return &m.table[h%tableSize]
+// ttLegal reports whether te's move is legal in p, counting it in
+// Stats.TTIllegal if not. A stored move must be checked before it is
+// trusted, since te may belong to a different position with the same
+// hash; moveGenerator does the same check as it applies the move.
+func (m *MinimaxAI) ttLegal(p *tak.Position, te *tableEntry) bool {
+	if _, e := p.MoveToAllocated(&te.m, m.scratch); e != nil {
+		m.st.TTIllegal++
+		return false
+	}
+	return true
+}
+
+func (m *MinimaxAI) ttPut(h uint64, depth int) *tableEntry {
+	b := &m.table[h%uint64(len(m.table))]
+	te := &b[1]
+	if b[0].hash == h || b[0].hash == 0 ||
+		b[0].gen != m.gen || b[0].depth <= depth {
+		te = &b[0]
+	}
+	te.gen = m.gen
+	m.st.TTStores++
+	if te.hash == 0 {
+		m.ttFilled++
+	} else if te.hash != h {
+		m.st.TTReplacements++
+	}
+	return te
 }
 
 func (m *MinimaxAI) precompute() {
@@ -136,47 +507,358 @@ func formatpv(ms []tak.Move) string {
 }
 
 func (m *MinimaxAI) GetMove(p *tak.Position, limit time.Duration) tak.Move {
-	ms, _, _ := m.Analyze(p, limit)
-	return ms[0]
+	var deadline time.Time
+	if limit != 0 {
+		deadline = m.now().Add(limit - m.cfg.TimeMargin)
+	}
+	ms, v, st := m.Analyze(p, limit)
+	if len(ms) == 0 {
+		return tak.Move{}
+	}
+	if m.cfg.Temperature <= 0 || st.Forced || v > WinThreshold || v < -WinThreshold {
+		return ms[0]
+	}
+	cands := m.nearBest(p, st.Depth, v-m.cfg.Temperature, deadline)
+	if len(cands) == 0 {
+		return ms[0]
+	}
+	return cands[m.rand.Intn(len(cands))]
 }
 
+// nearBest returns the moves in p whose value, searched to `depth`,
+// is at least `bound`. Each move is tested with a null-window search
+// one ply shallower, which the table from the preceding search of p
+// makes cheap. If the search is interrupted, or runs past deadline
+// (if nonzero), it returns nil.
+func (m *MinimaxAI) nearBest(p *tak.Position, depth int, bound int64, deadline time.Time) []tak.Move {
+	m.deadline = deadline
+	m.aborted = false
+	m.path[0] = p.Hash()
+	var out []tak.Move
+	for _, mv := range p.AllMoves(nil) {
+		child, e := p.Move(&mv)
+		if e != nil {
+			continue
+		}
+		_, v := m.minimax(child, 1, depth-1, nil, -bound, -bound+1)
+		if m.aborted {
+			return nil
+		}
+		if -v >= bound {
+			out = append(out, mv)
+		}
+	}
+	if m.cfg.Debug > 0 {
+		m.logf("[minimax] near best: bound=%d candidates=%s", bound, formatpv(out))
+	}
+	return out
+}
+
+// EvaluateMoves searches every legal move in p and returns its
+// value, from White's perspective, keyed by the move in PTN. Like
+// Analyze, it deepens iteratively to the configured depth or until
+// limit (if nonzero) runs out, and returns the values from the
+// deepest iteration to complete. Every iteration searches each move
+// with a full window, sharing the transposition table and move
+// ordering between moves and with the iterations before. If the
+// game is over in p, the map is empty.
+func (m *MinimaxAI) EvaluateMoves(p *tak.Position, limit time.Duration) map[string]int64 {
+	if m.cfg.Size != p.Size() {
+		panic("EvaluateMoves: wrong size")
+	}
+	m.begin(context.Background())
+	out := make(map[string]int64)
+	if over, _ := p.GameOver(); over {
+		return out
+	}
+	type rootMove struct {
+		key   string
+		child *tak.Position
+	}
+	var moves []rootMove
+	for _, mv := range p.AllMoves(nil) {
+		child, e := p.Move(&mv)
+		if e != nil {
+			continue
+		}
+		moves = append(moves, rootMove{ptn.FormatMove(&mv), child})
+	}
+
+	top := m.now()
+	for depth := 1; depth <= m.cfg.Depth; depth++ {
+		m.deadline = time.Time{}
+		if limit != 0 && depth > 1 {
+			m.deadline = top.Add(limit - m.cfg.TimeMargin)
+		}
+		vals := make(map[string]int64, len(moves))
+		for _, rm := range moves {
+			m.path[0] = p.Hash()
+			_, v := m.minimax(rm.child, 1, depth-1, nil, minEval-1, maxEval+1)
+			if m.aborted {
+				if m.cfg.Debug > 0 {
+					m.logf("[minimax] evaluate moves: aborted: depth=%d", depth)
+				}
+				return out
+			}
+			vals[rm.key] = WhiteValue(p, -v)
+		}
+		out = vals
+		if m.cfg.Debug > 0 {
+			m.logf("[minimax] evaluate moves: depth=%d moves=%d time=%s evaluated=%d",
+				depth, len(moves), m.now().Sub(top), m.st.Evaluated)
+		}
+	}
+	return out
+}
+
+// Analyze searches p for up to limit (or to the configured depth,
+// if limit is 0) and returns the principal variation, its value,
+// and search statistics. The value is from the perspective of the
+// side to move; use AnalyzeWhite for a value that is positive when
+// White is ahead.
+//
+// If the game is already over in p, Analyze returns no moves and the
+// value of the result, without searching, and GetMove returns the
+// zero Move.
 func (m *MinimaxAI) Analyze(p *tak.Position, limit time.Duration) ([]tak.Move, int64, Stats) {
+	return m.analyze(context.Background(), p, limit, limit, m.onIteration(p))
+}
+
+// AnalyzeWhite is like Analyze, but returns the value from White's
+// perspective.
+func (m *MinimaxAI) AnalyzeWhite(p *tak.Position, limit time.Duration) ([]tak.Move, int64, Stats) {
+	pv, v, st := m.Analyze(p, limit)
+	return pv, WhiteValue(p, v), st
+}
+
+// WhiteValue converts v, a value for p from the perspective of the
+// side to move as returned by Analyze, to one from White's
+// perspective.
+func WhiteValue(p *tak.Position, v int64) int64 {
+	if p.ToMove() == tak.Black {
+		return -v
+	}
+	return v
+}
+
+// onIteration returns the OnIteration callback to use when
+// analyzing p, applying WhiteIteration.
+func (m *MinimaxAI) onIteration(p *tak.Position) func(int, int64, []tak.Move, Stats) {
+	cb := m.cfg.OnIteration
+	if cb == nil || !m.cfg.WhiteIteration {
+		return cb
+	}
+	return func(depth int, v int64, pv []tak.Move, st Stats) {
+		cb(depth, WhiteValue(p, v), pv, st)
+	}
+}
+
+// Iteration reports the result of one iteration of iterative
+// deepening.
+type Iteration struct {
+	Depth int
+	PV    []tak.Move
+	Value int64
+	Stats Stats
+	// Mate, if Value is a forced win or loss, is the number of
+	// plies until the game ends, as returned by MateInPlies.
+	Mate int
+}
+
+// AnalyzeStream analyzes `p` in the background, sending the result
+// of each completed iteration of iterative deepening on the returned
+// channel. The channel is closed once the search reaches the
+// configured depth, finds a forced win or loss, or `ctx` is
+// cancelled; an iteration interrupted by cancellation is not
+// reported.
+//
+// The MinimaxAI must not be used for anything else until the
+// channel is closed.
+func (m *MinimaxAI) AnalyzeStream(ctx context.Context, p *tak.Position) <-chan Iteration {
+	out := make(chan Iteration)
+	go func() {
+		defer close(out)
+		cb := m.onIteration(p)
+		m.analyze(ctx, p, 0, 0, func(depth int, v int64, pv []tak.Move, st Stats) {
+			if cb != nil {
+				cb(depth, v, pv, st)
+			}
+			it := Iteration{Depth: depth, PV: append([]tak.Move(nil), pv...), Value: v, Stats: st}
+			_, it.Mate = MateInPlies(p, v)
+			select {
+			case out <- it:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return out
+}
+
+// AnalyzeClock is like Analyze, but budgets its time from a game
+// clock. If the best move is still changing between iterations, the
+// search may continue past the nominal budget, up to the hard limit
+// computed by tc.Budget.
+func (m *MinimaxAI) AnalyzeClock(p *tak.Position, tc TimeControl) ([]tak.Move, int64, Stats) {
+	soft, hard := tc.Budget(p)
+	return m.analyze(context.Background(), p, soft, hard, m.onIteration(p))
+}
+
+// logf writes debug output to the configured Logger.
+func (m *MinimaxAI) logf(format string, args ...interface{}) {
+	if m.cfg.Logger != nil {
+		m.cfg.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// weights returns the weights configured for the default evaluator.
+func (m *MinimaxAI) weights() *Weights {
+	if m.cfg.Weights != nil {
+		return m.cfg.Weights
+	}
+	return &DefaultWeights
+}
+
+// HeatMap returns a copy of the engine's history of cutoffs, indexed
+// by tak.SquareIndex. Each move that causes a beta cutoff adds
+// 2^depth to its square, and every value is halved at the start of
+// each analysis, so large values mark the squares that mattered most
+// in recent searches. The engine uses it to order moves.
+func (m *MinimaxAI) HeatMap() []uint64 {
+	return append([]uint64(nil), m.heatMap...)
+}
+
+// DumpTT writes the line stored in the transposition table from p,
+// one ply per line, for debugging. It stops at the first position
+// with no table entry, at the end of the game, or on a repetition.
+func (m *MinimaxAI) DumpTT(p *tak.Position, w io.Writer) {
+	seen := make(map[uint64]bool)
+	for ply := 1; ; ply++ {
+		if seen[p.Hash()] {
+			fmt.Fprintf(w, "%d: repeated position %s\n", ply, ptn.FormatTPS(p))
+			return
+		}
+		seen[p.Hash()] = true
+		te := m.ttGet(p.Hash())
+		if te == nil {
+			fmt.Fprintf(w, "%d: no entry %s\n", ply, ptn.FormatTPS(p))
+			return
+		}
+		fmt.Fprintf(w, "%d: %s value=%d bound=%s depth=%d %s\n",
+			ply, ptn.FormatMove(&te.m), te.value, te.bound, te.depth, ptn.FormatTPS(p))
+		if over, _ := p.GameOver(); over {
+			return
+		}
+		next, e := p.Move(&te.m)
+		if e != nil {
+			fmt.Fprintf(w, "%d: illegal move: %v\n", ply, e)
+			return
+		}
+		p = next
+	}
+}
+
+func (m *MinimaxAI) analyze(
+	ctx context.Context,
+	p *tak.Position,
+	limit, extended time.Duration,
+	report func(depth int, v int64, pv []tak.Move, st Stats),
+) ([]tak.Move, int64, Stats) {
 	if m.cfg.Size != p.Size() {
 		panic("Analyze: wrong size")
 	}
-	for i, v := range m.heatMap {
-		m.heatMap[i] = v / 2
-	}
+	m.begin(ctx)
 
-	var seed = m.cfg.Seed
-	if seed == 0 {
-		seed = time.Now().Unix()
+	if over, winner := p.GameOver(); over {
+		m.st = Stats{Evaluated: 1, Terminal: 1}
+		if winner == tak.NoColor {
+			return nil, m.drawValue(0), m.st
+		}
+		return nil, m.evaluate(m, p, SearchContext{}), m.st
 	}
-	m.rand = rand.New(rand.NewSource(seed))
-	if m.cfg.Debug > 0 {
-		log.Printf("seed=%d", seed)
+
+	if ms, v, ok := m.forcedMove(p); ok {
+		m.st = Stats{Depth: 1, CompletedDepth: 1, Forced: true}
+		if m.cfg.Debug > 0 {
+			m.logf("[minimax] forced: val=%d pv=%s", v, formatpv(ms))
+		}
+		if report != nil {
+			report(1, v, ms, m.st)
+		}
+		return ms, v, m.st
 	}
 
 	var ms []tak.Move
 	var v int64
-	top := time.Now()
+	top := m.now()
 	var prevEval uint64
 	var branchSum uint64
+	// stable counts the iterations since the best move last
+	// changed, or the value last swung by more than the margin
+	stable := 0
+	margin := m.cfg.StableExitMargin
+	if margin == 0 {
+		margin = defaultStableExitMargin
+	}
 	base := 0
 	te := m.ttGet(p.Hash())
-	if te != nil && te.bound == exactBound {
+	if te != nil && te.bound == exactBound && m.ttLegal(p, te) {
+		// A legal move does not prove the entry is ours: it
+		// may belong to another position with the same hash.
+		// Use it to order the search, but always search at
+		// least the final depth from the root, and start over
+		// if that search is abandoned.
 		base = te.depth
-		ms = []tak.Move{te.m}
+		if base >= m.cfg.Depth {
+			base = m.cfg.Depth - 1
+		}
+		ms, v = []tak.Move{te.m}, te.value
 	}
 
 	for i := 1; i+base <= m.cfg.Depth; i++ {
-		m.st = Stats{Depth: i + base}
-		start := time.Now()
+		prevMs, prevV, prevSt := ms, v, m.st
+		m.st = Stats{Depth: i + base, TimePerDepth: prevSt.TimePerDepth}
+		m.deadline = time.Time{}
+		if limit != 0 && len(ms) > 0 {
+			m.deadline = top.Add(extended - m.cfg.TimeMargin)
+		}
+		start := m.now()
+		var prev tak.Move
+		if len(ms) > 0 {
+			prev = ms[0]
+		}
 		ms, v = m.minimax(p, 0, i+base, ms, minEval-1, maxEval+1)
-		timeUsed := time.Now().Sub(top)
-		timeMove := time.Now().Sub(start)
+		if m.aborted {
+			if m.cfg.Debug > 0 {
+				m.logf("[minimax] aborted: depth=%d", i+base)
+			}
+			if base > 0 && i == 1 {
+				// Nothing has completed but the table's
+				// seed, which may not be ours. Start over
+				// from depth 1, which runs without a
+				// deadline.
+				base, i = 0, 0
+				ms, v, m.st = nil, 0, Stats{}
+				m.aborted = false
+				continue
+			}
+			ms, v, m.st = prevMs, prevV, prevSt
+			break
+		}
+		unstable := i > 1 && !prev.Equal(&ms[0])
+		if i > 1 && !unstable && v-prevV <= margin && prevV-v <= margin {
+			stable++
+		} else {
+			stable = 0
+		}
+		timeUsed := m.now().Sub(top)
+		timeMove := m.now().Sub(start)
+		m.st.TimePerDepth = append(m.st.TimePerDepth, timeMove)
+		m.st.CompletedDepth = i + base
+		m.st.TTFill = float64(m.ttFilled) / float64(2*len(m.table))
 		if m.cfg.Debug > 0 {
-			log.Printf("[minimax] deepen: depth=%d val=%d pv=%s time=%s total=%s evaluated=%d tt=%d branch=%d",
+			m.logf("[minimax] deepen: depth=%d val=%d pv=%s time=%s total=%s evaluated=%d tt=%d branch=%d",
 				base+i, v, formatpv(ms),
 				timeMove,
 				timeUsed,
@@ -186,7 +868,7 @@ func (m *MinimaxAI) Analyze(p *tak.Position, limit time.Duration) ([]tak.Move, i
 			)
 		}
 		if m.cfg.Debug > 1 {
-			log.Printf("[minimax]  stats: visited=%d evaluated=%d terminal=%d cut=%d cut0=%d(%2.2f) cut1=%d(%2.2f) m/cut=%2.2f m/ms=%f all=%d",
+			m.logf("[minimax]  stats: visited=%d evaluated=%d terminal=%d cut=%d cut0=%d(%2.2f) cut1=%d(%2.2f) m/cut=%2.2f m/ms=%f all=%d reduced=%d research=%d singular=%d",
 				m.st.Visited,
 				m.st.Evaluated,
 				m.st.Terminal,
@@ -197,7 +879,33 @@ func (m *MinimaxAI) Analyze(p *tak.Position, limit time.Duration) ([]tak.Move, i
 				float64(m.st.Cut0+m.st.Cut1)/float64(m.st.CutNodes+1),
 				float64(m.st.CutSearch)/float64(m.st.CutNodes-m.st.Cut0-m.st.Cut1+1),
 				float64(m.st.Visited+m.st.Evaluated)/float64(timeMove.Seconds()*1000),
-				m.st.AllNodes)
+				m.st.AllNodes,
+				m.st.Reduced,
+				m.st.ReSearched,
+				m.st.Singular)
+			if m.cfg.NullMove {
+				m.logf("[minimax]  null: tried=%d cut=%d", m.st.NullMoves, m.st.NullCuts)
+			}
+			m.logf("[minimax]  table: hits=%d stores=%d replaced=%d collisions=%d illegal=%d fill=%2.4f evalhits=%d",
+				m.st.TTHits,
+				m.st.TTStores,
+				m.st.TTReplacements,
+				m.st.TTCollisions,
+				m.st.TTIllegal,
+				m.st.TTFill,
+				m.st.EvalCacheHits)
+		}
+		if m.cfg.StableExitPlies > 0 && stable >= m.cfg.StableExitPlies && i+base != m.cfg.Depth {
+			m.st.StableExit = true
+			if m.cfg.Debug > 0 {
+				m.logf("[minimax] stable: depth=%d move=%s", i+base, ptn.FormatMove(&ms[0]))
+			}
+		}
+		if report != nil {
+			report(i+base, v, ms, m.st)
+		}
+		if m.st.StableExit {
+			break
 		}
 		if i > 1 {
 			branchSum += m.st.Evaluated / (prevEval + 1)
@@ -217,10 +925,15 @@ func (m *MinimaxAI) Analyze(p *tak.Position, limit time.Duration) ([]tak.Move, i
 				// returns a deep move
 				branch = 20
 			}
-			estimate := timeUsed + time.Now().Sub(start)*time.Duration(branch)
-			if estimate > limit {
+			estimate := timeUsed + m.now().Sub(start)*time.Duration(branch)
+			budget := limit
+			if unstable && extended > limit {
+				budget = extended
+			}
+			budget -= m.cfg.TimeMargin
+			if estimate > budget {
 				if m.cfg.Debug > 0 {
-					log.Printf("[minimax] time cutoff: depth=%d used=%s estimate=%s",
+					m.logf("[minimax] time cutoff: depth=%d used=%s estimate=%s",
 						i, timeUsed, estimate)
 				}
 				break
@@ -230,23 +943,202 @@ func (m *MinimaxAI) Analyze(p *tak.Position, limit time.Duration) ([]tak.Move, i
 	return ms, v, m.st
 }
 
+// begin prepares for a new search, which ctx may cancel.
+func (m *MinimaxAI) begin(ctx context.Context) {
+	for i, v := range m.heatMap {
+		m.heatMap[i] = v / 2
+	}
+	m.gen++
+	m.cancel = ctx.Done()
+	m.aborted = false
+	m.st = Stats{}
+
+	var seed = m.cfg.Seed
+	if seed == 0 && !m.cfg.Deterministic {
+		seed = time.Now().Unix()
+	}
+	if m.cfg.RandSource != nil {
+		m.rand = rand.New(m.cfg.RandSource(seed))
+	} else {
+		m.rand = rand.New(rand.NewSource(seed))
+	}
+	if m.cfg.Debug > 0 {
+		m.logf("seed=%d", seed)
+	}
+}
+
+// forcedMove checks whether p, which must not be over, needs no
+// search: if the player to move can complete a road, it returns that
+// move, and otherwise, if there is only one legal move, it returns
+// that.
+func (m *MinimaxAI) forcedMove(p *tak.Position) ([]tak.Move, int64, bool) {
+	for _, mv := range p.RoadCompletions(p.ToMove()) {
+		child, e := p.Move(&mv)
+		if e != nil {
+			continue
+		}
+		if over, winner := child.GameOver(); over && winner == p.ToMove() {
+			v, _ := terminalValue(child, m.weights().RoadWin)
+			return []tak.Move{mv}, -v, true
+		}
+	}
+	var only tak.Move
+	var child *tak.Position
+	for _, mv := range p.AllMoves(nil) {
+		next, e := p.MoveToAllocated(&mv, m.scratch)
+		if e != nil {
+			continue
+		}
+		if child != nil {
+			return nil, 0, false
+		}
+		only, child = mv, next.Clone()
+	}
+	if child == nil {
+		return nil, 0, false
+	}
+	return []tak.Move{only}, -m.evaluate(m, child, SearchContext{Ply: 1, Depth: 1}), true
+}
+
+// historyKey identifies a position for repetition, since hashes do
+// not include the player to move.
+type historyKey struct {
+	hash   uint64
+	toMove tak.Color
+}
+
+// SetHistory records the positions played earlier in the game, so
+// that later analyses score any move leading back to one of them as
+// a draw, as they do repetitions within the search. It replaces any
+// earlier history; nil clears it. Values that depend on the history
+// are never stored in the transposition table, so the table stays
+// valid across changes to the history.
+func (m *MinimaxAI) SetHistory(history []*tak.Position) {
+	m.history = nil
+	if len(history) != 0 {
+		m.history = make(map[historyKey]bool, len(history))
+		for _, p := range history {
+			m.history[historyKey{p.Hash(), p.ToMove()}] = true
+		}
+	}
+}
+
+// repeated records p as the position at `ply` on the search path,
+// and reports whether it already occurred earlier on the path, or,
+// below the root, earlier in the game.
+func (ai *MinimaxAI) repeated(p *tak.Position, ply int) bool {
+	h := p.Hash()
+	ai.path[ply] = h
+	for i := ply - 2; i >= 0; i -= 2 {
+		if ai.path[i] == h {
+			ai.repetitions++
+			return true
+		}
+	}
+	if ply > 0 && ai.history != nil && ai.history[historyKey{h, p.ToMove()}] {
+		ai.repetitions++
+		return true
+	}
+	return false
+}
+
+// drawValue returns the value of a drawn position, `ply` plies from
+// the root, to the player to move there.
+func (ai *MinimaxAI) drawValue(ply int) int64 {
+	if ply%2 == 0 {
+		return -ai.cfg.Contempt
+	}
+	return ai.cfg.Contempt
+}
+
+// singular reports whether te's move is singular in p: whether
+// every other move, searched to half of `depth`, fails low against a
+// bound singularMargin below the table's value for p.
+func (ai *MinimaxAI) singular(p *tak.Position, ply, depth int, te *tableEntry) bool {
+	if te.bound == upperBound || te.depth < depth-3 ||
+		te.value > WinThreshold || te.value < -WinThreshold ||
+		!ai.ttLegal(p, te) {
+		return false
+	}
+	β := te.value - singularMargin
+	mg := moveGenerator{
+		ai:    ai,
+		ply:   ply,
+		depth: depth / 2,
+		p:     p,
+	}
+	for m, child := mg.Next(); child != nil; m, child = mg.Next() {
+		if m.Equal(&te.m) {
+			continue
+		}
+		_, v := ai.minimax(child, ply+1, depth/2-1, nil, -β, -β+1)
+		if ai.aborted || -v >= β {
+			return false
+		}
+	}
+	ai.st.Singular++
+	return true
+}
+
+// nullMove reports whether passing in p, searched with a depth
+// reduced by nullMoveReduction, still fails high against β.
+func (ai *MinimaxAI) nullMove(p *tak.Position, ply, depth int, β int64) bool {
+	ai.st.NullMoves++
+	child := p.PassToAllocated(ai.stack[ply].p)
+	ai.nullKey = nullMoveKey
+	_, v := ai.minimax(child, ply+1, depth-1-nullMoveReduction, nil, -β, -β+1)
+	ai.nullKey = 0
+	if ai.aborted || -v < β {
+		return false
+	}
+	ai.st.NullCuts++
+	return true
+}
+
 func (ai *MinimaxAI) minimax(
 	p *tak.Position,
 	ply, depth int,
 	pv []tak.Move,
 	α, β int64) ([]tak.Move, int64) {
-	over, _ := p.GameOver()
+	repetitions := ai.repetitions
+	over, winner := p.GameOver()
+	if (over && winner == tak.NoColor) || ai.repeated(p, ply) {
+		ai.st.Evaluated++
+		ai.st.Terminal++
+		return nil, ai.drawValue(ply)
+	}
 	if depth == 0 || over {
 		ai.st.Evaluated++
+		ctx := SearchContext{
+			Ply:   ply,
+			Depth: ai.st.Depth,
+			PV:    β > α+1,
+		}
 		if over {
 			ai.st.Terminal++
+			return nil, ai.evaluate(ai, p, ctx)
 		}
-		return nil, ai.evaluate(ai, p)
+		return nil, ai.evaluateLeaf(p, ctx)
 	}
 
 	ai.st.Visited++
+	if ai.st.Visited%256 == 0 {
+		if ai.cancel != nil {
+			select {
+			case <-ai.cancel:
+				ai.aborted = true
+			default:
+			}
+		}
+		if !ai.deadline.IsZero() && ai.now().After(ai.deadline) {
+			ai.aborted = true
+		}
+	}
+	if ai.aborted {
+		return nil, 0
+	}
 
-	te := ai.ttGet(p.Hash())
+	te := ai.ttGet(p.Hash() ^ ai.nullKey)
 	if te != nil {
 		teSuffices := false
 		if te.depth >= depth {
@@ -261,15 +1153,33 @@ func (ai *MinimaxAI) minimax(
 			(te.value > WinThreshold || te.value < -WinThreshold) {
 			teSuffices = true
 		}
-		if teSuffices {
-			_, e := p.Move(&te.m)
-			if e == nil {
+		// never cut off at the root, whose result must come
+		// from a search of p itself
+		if teSuffices && ply > 0 {
+			if ai.ttLegal(p, te) {
 				ai.st.TTHits++
 				return []tak.Move{te.m}, te.value
 			}
 			te = nil
 		}
 	}
+	if te != nil {
+		// te points into the table, whose slot the searches
+		// below (null move, singular verification, the moves
+		// themselves) may overwrite with another position
+		saved := *te
+		te = &saved
+	}
+	if ai.cfg.NullMove && ai.nullKey == 0 && ply > 0 && β == α+1 &&
+		depth > nullMoveReduction && β < WinThreshold && β > -WinThreshold &&
+		!p.NearZugzwang() && !p.HasRoadThreat(p.ToMove().Flip()) &&
+		ai.nullMove(p, ply, depth, β) {
+		return nil, β
+	}
+	extend := false
+	if ai.cfg.Singular && te != nil && depth >= singularDepth && ply+depth < len(ai.stack) {
+		extend = ai.singular(p, ply, depth, te)
+	}
 	mg := moveGenerator{
 		ai:    ai,
 		ply:   ply,
@@ -292,16 +1202,32 @@ func (ai *MinimaxAI) minimax(
 			newpv = best[1:]
 		}
 		if i > 1 {
-			ms, v = ai.minimax(child, ply+1, depth-1, newpv, -α-1, -α)
+			d := depth - 1
+			if ai.cfg.LMR && i > 4 && depth >= 3 && quietMove(p, child) {
+				ai.st.Reduced++
+				d--
+			}
+			ms, v = ai.minimax(child, ply+1, d, newpv, -α-1, -α)
+			if d < depth-1 && -v > α {
+				ai.st.ReSearched++
+				ms, v = ai.minimax(child, ply+1, depth-1, newpv, -α-1, -α)
+			}
 			if -v > α && -v < β {
 				ms, v = ai.minimax(child, ply+1, depth-1, newpv, -β, -α)
 			}
 		} else {
-			ms, v = ai.minimax(child, ply+1, depth-1, newpv, -β, -α)
+			d := depth - 1
+			if extend && m.Equal(&te.m) {
+				d++
+			}
+			ms, v = ai.minimax(child, ply+1, d, newpv, -β, -α)
+		}
+		if ai.aborted {
+			return best, α
 		}
 		v = -v
 		if ai.cfg.Debug > 2 && ply == 0 {
-			log.Printf("[minimax] search: depth=%d ply=%d m=%s pv=%s window=(%d,%d) ms=%s v=%d evaluated=%d",
+			ai.logf("[minimax] search: depth=%d ply=%d m=%s pv=%s window=(%d,%d) ms=%s v=%d evaluated=%d",
 				depth, ply, ptn.FormatMove(&m), formatpv(newpv), α, β, formatpv(ms), v, ai.st.Evaluated)
 		}
 
@@ -324,7 +1250,8 @@ func (ai *MinimaxAI) minimax(
 				default:
 					ai.st.CutSearch += uint64(i + 1)
 				}
-				ai.heatMap[m.X+m.Y*ai.cfg.Size] += (1 << uint(depth))
+				ai.heatMap[tak.SquareIndex(m.X, m.Y, ai.cfg.Size)] += (1 << uint(depth))
+				ai.moves.Cutoff(ai, p, m, ply, depth)
 				if ai.cfg.Debug > 3 && i > 20 && depth >= 3 {
 					var tm tak.Move
 					td := 0
@@ -332,7 +1259,7 @@ func (ai *MinimaxAI) minimax(
 						tm = te.m
 						td = te.depth
 					}
-					log.Printf("[minimax] late cutoff depth=%d m=%d pv=%s te=%d:%s killer=%s pos=%q",
+					ai.logf("[minimax] late cutoff depth=%d m=%d pv=%s te=%d:%s killer=%s pos=%q",
 						depth, i, formatpv(pv), td, ptn.FormatMove(&tm), ptn.FormatMove(&m), ptn.FormatTPS(p),
 					)
 				}
@@ -341,8 +1268,14 @@ func (ai *MinimaxAI) minimax(
 		}
 	}
 
-	te = ai.ttPut(p.Hash())
-	te.hash = p.Hash()
+	if ai.repetitions != repetitions {
+		// α depends on the path to p, or on the game
+		// history, which may differ when the entry is next
+		// used
+		return best, α
+	}
+	te = ai.ttPut(p.Hash()^ai.nullKey, depth)
+	te.hash = p.Hash() ^ ai.nullKey
 	te.depth = depth
 	te.m = best[0]
 	te.value = α
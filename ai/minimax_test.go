@@ -1,9 +1,17 @@
 package ai
 
 import (
+	"bytes"
+	"context"
 	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/nelhage/taktician/ptn"
 	"github.com/nelhage/taktician/tak"
@@ -12,6 +20,25 @@ import (
 var size = flag.Int("size", 5, "board size to benchmark")
 var depth = flag.Int("depth", 4, "minimax search depth")
 
+const (
+	// midgameTPS is a 5x5 middle game, White to move, in which
+	// Black threatens a road: the searches of most tests have
+	// something to find, but neither side is winning outright.
+	midgameTPS = `2,x4/x2,2,x2/x,2,2,x2/x2,12,2,1/1,1,21,2,1 1 9`
+	// openingTPS is a quiet 5x5 opening, where many moves are of
+	// nearly equal value.
+	openingTPS = `x5/x5/x5/x5/2,x3,1 1 2`
+)
+
+// parseTPS parses tps, failing the test if it is invalid.
+func parseTPS(tb testing.TB, tps string) *tak.Position {
+	p, err := ptn.ParseTPS(tps)
+	if err != nil {
+		tb.Fatalf("ParseTPS(%q): %v", tps, err)
+	}
+	return p
+}
+
 func BenchmarkMinimax(b *testing.B) {
 	var cfg = tak.Config{Size: *size}
 	p := tak.New(cfg)
@@ -48,3 +75,979 @@ func TestRegression(t *testing.T) {
 		t.Fatalf("ai returned illegal move: %s: %s", ptn.FormatMove(&m), e)
 	}
 }
+
+func TestTableDepthPreferred(t *testing.T) {
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 3})
+	ai.gen = 1
+	buckets := uint64(len(ai.table))
+	deep, shallow := uint64(17), 17+buckets
+
+	te := ai.ttPut(deep, 5)
+	te.hash, te.depth = deep, 5
+	te = ai.ttPut(shallow, 1)
+	te.hash, te.depth = shallow, 1
+
+	if te := ai.ttGet(deep); te == nil || te.depth != 5 {
+		t.Fatalf("deep entry evicted by shallow store: %#v", te)
+	}
+	if te := ai.ttGet(shallow); te == nil || te.depth != 1 {
+		t.Fatalf("shallow entry not stored: %#v", te)
+	}
+
+	ai.gen++
+	other := 17 + 2*buckets
+	te = ai.ttPut(other, 1)
+	te.hash, te.depth = other, 1
+	if te := ai.ttGet(deep); te != nil {
+		t.Fatalf("stale deep entry survived a new generation: %#v", te)
+	}
+	if te := ai.ttGet(other); te == nil {
+		t.Fatalf("store into stale slot failed")
+	}
+}
+
+func TestSlideGain(t *testing.T) {
+	p, err := ptn.ParseTPS("x5/x5/2,x,2S,x2/x,1,x3/x,21C,2,1,x 1 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		move string
+		gain int
+	}{
+		{"b1>", 0},
+		{"2b1>", 1},
+		{"2b1>11", -1},
+		{"2b1<11", 0},
+		{"b2+", 0},
+		{"b2<", 0},
+		{"d1<", 1},
+		{"d1+", 0},
+		{"c1", 0},
+	}
+	for _, tc := range cases {
+		m, err := ptn.ParseMove(tc.move)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slideGain(p, &m); got != tc.gain {
+			t.Errorf("slideGain(%s)=%d want %d", tc.move, got, tc.gain)
+		}
+	}
+
+	p, err = ptn.ParseTPS("x5/x5/x,1C,2S,x2/x5/x5 1 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := ptn.ParseMove("b3>")
+	if got := slideGain(p, &m); got != 2 {
+		t.Errorf("capstone flattening: slideGain=%d want 2", got)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true}
+	want, wv, _ := NewMinimax(cfg).Analyze(p, 0)
+	for i := 0; i < 3; i++ {
+		pv, v, _ := NewMinimax(cfg).Analyze(p, 0)
+		if formatpv(pv) != formatpv(want) || v != wv {
+			t.Fatalf("run %d: pv=%s v=%d, first run pv=%s v=%d",
+				i, formatpv(pv), v, formatpv(want), wv)
+		}
+	}
+}
+
+func TestOnIteration(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	var depths []int
+	var last []tak.Move
+	var lastV int64
+	ai := NewMinimax(MinimaxConfig{
+		Size:  p.Size(),
+		Depth: 3,
+		OnIteration: func(depth int, v int64, pv []tak.Move, st Stats) {
+			if st.Depth != depth {
+				t.Errorf("depth=%d st.Depth=%d", depth, st.Depth)
+			}
+			depths = append(depths, depth)
+			last, lastV = pv, v
+		},
+	})
+	pv, v, _ := ai.Analyze(p, 0)
+	if len(depths) != 3 || depths[0] != 1 || depths[2] != 3 {
+		t.Errorf("depths=%v", depths)
+	}
+	if formatpv(last) != formatpv(pv) || lastV != v {
+		t.Errorf("last iteration pv=%s v=%d, Analyze pv=%s v=%d",
+			formatpv(last), lastV, formatpv(pv), v)
+	}
+}
+
+func TestAnalyzeWhite(t *testing.T) {
+	p, err := ptn.ParseTPS(
+		`2,x4/x2,2,x2/x,2,2,x2/x2,12,2,1/1,1,21,2,1 2 9`,
+	)
+	if err != nil {
+		panic(err)
+	}
+	var lastV int64
+	cfg := MinimaxConfig{
+		Size:          p.Size(),
+		Depth:         3,
+		Deterministic: true,
+	}
+	_, v, _ := NewMinimax(cfg).Analyze(p, 0)
+	cfg.WhiteIteration = true
+	cfg.OnIteration = func(depth int, v int64, pv []tak.Move, st Stats) {
+		lastV = v
+	}
+	_, wv, _ := NewMinimax(cfg).AnalyzeWhite(p, 0)
+	if v == 0 || wv != -v {
+		t.Errorf("Analyze=%d AnalyzeWhite=%d", v, wv)
+	}
+	if lastV != wv {
+		t.Errorf("OnIteration v=%d, want %d", lastV, wv)
+	}
+	if WhiteValue(p, wv) != v {
+		t.Errorf("WhiteValue(%d)=%d, want %d", wv, WhiteValue(p, wv), v)
+	}
+}
+
+func TestAnalyzeStream(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3})
+	var depths []int
+	for it := range ai.AnalyzeStream(context.Background(), p) {
+		if len(it.PV) == 0 {
+			t.Errorf("depth=%d: empty pv", it.Depth)
+		}
+		depths = append(depths, it.Depth)
+	}
+	if len(depths) != 3 || depths[2] != 3 {
+		t.Errorf("depths=%v", depths)
+	}
+
+	ai = NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 9})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	for it := range ai.AnalyzeStream(ctx, p) {
+		if len(it.PV) == 0 {
+			t.Errorf("depth=%d: empty pv", it.Depth)
+		}
+	}
+	if elapsed := time.Now().Sub(start); elapsed > time.Second {
+		t.Errorf("cancelled search took %s", elapsed)
+	}
+}
+
+func TestTimeMargin(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	const tick = 50 * time.Microsecond
+	// search runs against a clock that stands still until the
+	// fourth iteration and then advances a tick per leaf, so that
+	// the first three iterations predict nothing of its cost. It
+	// returns the number of leaves the fourth iteration evaluated
+	// before it was abandoned.
+	search := func(cfg MinimaxConfig, limit time.Duration) ([]tak.Move, int64, Stats, int) {
+		var clock time.Time
+		leaves := 0
+		cfg.Size = p.Size()
+		cfg.Deterministic = true
+		cfg.EvaluateContext = func(m *MinimaxAI, p *tak.Position, ctx SearchContext) int64 {
+			if ctx.Depth >= 4 {
+				leaves++
+				clock = clock.Add(tick)
+			}
+			return DefaultEvaluate(m, p)
+		}
+		ai := NewMinimax(cfg)
+		ai.now = func() time.Time { return clock }
+		pv, v, st := ai.Analyze(p, limit)
+		return pv, v, st, leaves
+	}
+
+	wantPV, wantV, _, _ := search(MinimaxConfig{Depth: 3}, 0)
+	var leaves [2]int
+	for i, margin := range []time.Duration{0, 500 * time.Millisecond} {
+		pv, v, st, n := search(MinimaxConfig{Depth: 4, TimeMargin: margin}, time.Second)
+		leaves[i] = n
+		if st.Depth != 3 || st.CompletedDepth != 3 || len(st.TimePerDepth) != 3 {
+			t.Errorf("margin=%s: Depth=%d CompletedDepth=%d TimePerDepth=%v, want the third iteration's result",
+				margin, st.Depth, st.CompletedDepth, st.TimePerDepth)
+		}
+		if v != wantV || len(pv) == 0 || !pv[0].Equal(&wantPV[0]) {
+			t.Errorf("margin=%s: pv=%s v=%d, want pv=%s v=%d",
+				margin, formatpv(pv), v, formatpv(wantPV), wantV)
+		}
+		// the search is abandoned only once the deadline
+		// passes, not cut off before the last iteration
+		if limit := int((time.Second - margin) / tick); n < limit {
+			t.Errorf("margin=%s: aborted after %d leaves, before the deadline at %d",
+				margin, n, limit)
+		}
+	}
+	if leaves[1] >= leaves[0] {
+		t.Errorf("leaves with margin=%d, without=%d", leaves[1], leaves[0])
+	}
+}
+
+func TestTimePerDepth(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	start := time.Now()
+	_, _, st := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 4}).Analyze(p, 0)
+	elapsed := time.Since(start)
+	if st.CompletedDepth != 4 || len(st.TimePerDepth) != 4 {
+		t.Fatalf("CompletedDepth=%d TimePerDepth=%v", st.CompletedDepth, st.TimePerDepth)
+	}
+	var total time.Duration
+	for _, d := range st.TimePerDepth {
+		total += d
+	}
+	if total > elapsed {
+		t.Errorf("iterations took %s, longer than the analysis's %s", total, elapsed)
+	}
+}
+
+func TestStatsReused(t *testing.T) {
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 3})
+	for i, tps := range []string{midgameTPS, openingTPS, midgameTPS} {
+		_, _, st := ai.Analyze(parseTPS(t, tps), 0)
+		if st.CompletedDepth != 3 || len(st.TimePerDepth) == 0 || len(st.TimePerDepth) > 3 {
+			t.Errorf("analysis %d: CompletedDepth=%d TimePerDepth=%v",
+				i, st.CompletedDepth, st.TimePerDepth)
+		}
+		if i == 1 && len(st.TimePerDepth) != 3 {
+			t.Errorf("new position: TimePerDepth=%v, want three iterations", st.TimePerDepth)
+		}
+	}
+}
+
+func TestHeatMap(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3})
+	ai.Analyze(p, 0)
+	hm := ai.HeatMap()
+	if len(hm) != p.Size()*p.Size() {
+		t.Fatalf("len(HeatMap())=%d", len(hm))
+	}
+	var sum uint64
+	for i := range hm {
+		sum += hm[i]
+		hm[i] = 12345
+	}
+	if sum == 0 {
+		t.Errorf("HeatMap() is all zero after a search")
+	}
+	for _, v := range ai.HeatMap() {
+		if v == 12345 {
+			t.Fatalf("HeatMap() shares storage with the engine")
+		}
+	}
+}
+
+func TestDumpTT(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x5/x,1,2,x2/x5/x5 1 2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, Seed: 1})
+	pv, v, _ := ai.Analyze(p, time.Minute)
+	var buf bytes.Buffer
+	ai.DumpTT(p, &buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := fmt.Sprintf("1: %s value=%d bound=exact depth=3 %s",
+		ptn.FormatMove(&pv[0]), v, ptn.FormatTPS(p))
+	if lines[0] != want {
+		t.Errorf("DumpTT:\n%s\nwant first line %q", buf.String(), want)
+	}
+	if len(lines) < len(pv) {
+		t.Errorf("DumpTT printed %d lines, pv has %d moves:\n%s",
+			len(lines), len(pv), buf.String())
+	}
+}
+
+func TestEvaluateContext(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x,1,2,x2/x,2,1,x2/x2,1,x2/x5 2 4")
+	if e != nil {
+		t.Fatal(e)
+	}
+	var calls, pvNodes int
+	depth := 0
+	eval := func(m *MinimaxAI, p *tak.Position, ctx SearchContext) int64 {
+		if ctx.Depth == 3 {
+			calls++
+		}
+		if ctx.PV {
+			pvNodes++
+		}
+		if over, _ := p.GameOver(); !over && ctx.Ply != ctx.Depth {
+			t.Errorf("evaluated at ply=%d in depth=%d search", ctx.Ply, ctx.Depth)
+		}
+		if ctx.Depth > depth {
+			depth = ctx.Depth
+		}
+		return DefaultEvaluate(m, p)
+	}
+	ai := NewMinimax(MinimaxConfig{
+		Size: p.Size(), Depth: 3, Deterministic: true,
+		EvaluateContext: eval,
+	})
+	_, v, st := ai.Analyze(p, time.Minute)
+	if uint64(calls) != st.Evaluated || depth != 3 || pvNodes == 0 {
+		t.Errorf("calls=%d evaluated=%d depth=%d pv=%d", calls, st.Evaluated, depth, pvNodes)
+	}
+	plain := NewMinimax(MinimaxConfig{
+		Size: p.Size(), Depth: 3, Deterministic: true,
+		Evaluate: DefaultEvaluate,
+	})
+	if _, want, _ := plain.Analyze(p, time.Minute); want != v {
+		t.Errorf("Evaluate=%d, EvaluateContext=%d", want, v)
+	}
+}
+
+func TestContempt(t *testing.T) {
+	// White can shuffle its stone on a1 back and forth; the
+	// evaluation below rewards black for shuffling its stone
+	// on e5 in response, so that the line ends in a
+	// repetition. Placing on c3 instead is slightly worse than
+	// even for white.
+	p, e := ptn.ParseTPS("x4,2/x5/x5/x5/1,x4 1 2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	placed := func(q *tak.Position, c tak.Color) bool {
+		s0, c0 := p.Reserves(c)
+		s1, c1 := q.Reserves(c)
+		return s1+c1 < s0+c0
+	}
+	// whiteAt reports whether the bottom stone at (x,y) is
+	// white, i.e. white's stone there has not been moved.
+	whiteAt := func(p *tak.Position, x, y int) bool {
+		sq := p.At(x, y)
+		return len(sq) > 0 && sq[len(sq)-1].Color() == tak.White
+	}
+	eval := func(m *MinimaxAI, p *tak.Position) int64 {
+		var v int64
+		home := whiteAt(p, 0, 0)
+		switch {
+		case home && whiteAt(p, 2, 2):
+			v = -10
+		case placed(p, tak.White):
+			v = -1000
+		case placed(p, tak.Black):
+			v = 1000
+		case !home:
+			v = -100
+		default:
+			v = 100
+		}
+		if p.ToMove() == tak.Black {
+			return -v
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		contempt int64
+		v        int64
+		shuffle  bool
+	}{
+		{0, 0, true},
+		{50, -10, false},
+	} {
+		ai := NewMinimax(MinimaxConfig{
+			Size: 5, Depth: 4, Deterministic: true,
+			Evaluate: eval, Contempt: tc.contempt,
+		})
+		pv, v, _ := ai.Analyze(p, time.Minute)
+		shuffle := pv[0].X == 0 && pv[0].Y == 0 && pv[0].Type >= tak.SlideLeft
+		if v != tc.v || shuffle != tc.shuffle {
+			t.Errorf("contempt=%d: pv=%s v=%d, want v=%d shuffle=%v",
+				tc.contempt, formatpv(pv), v, tc.v, tc.shuffle)
+		}
+		// the root's value depends on the repetitions found
+		// below it, which another path to the same position
+		// may not allow
+		if te := ai.ttGet(p.Hash()); te != nil && te.depth == 4 {
+			t.Errorf("contempt=%d: stored the root's value %d", tc.contempt, te.value)
+		}
+	}
+}
+
+func TestReproducibleNodes(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	for _, cfg := range []MinimaxConfig{
+		{Size: 5, Depth: 4, Seed: 7},
+		{Size: 5, Depth: 4, Deterministic: true},
+	} {
+		pv, v, st := NewMinimax(cfg).Analyze(p, 0)
+		for i := 0; i < 3; i++ {
+			again, av, ast := NewMinimax(cfg).Analyze(p, 0)
+			if av != v || !again[0].Equal(&pv[0]) ||
+				ast.Visited != st.Visited || ast.Evaluated != st.Evaluated ||
+				ast.TTHits != st.TTHits || ast.CutNodes != st.CutNodes {
+				t.Errorf("seed=%d deterministic=%v: %s v=%d %+v, then %s v=%d %+v",
+					cfg.Seed, cfg.Deterministic,
+					formatpv(pv), v, st, formatpv(again), av, ast)
+			}
+		}
+	}
+}
+
+func TestStableExit(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	var moves []tak.Move
+	cfg := MinimaxConfig{
+		Size: 5, Depth: 6, Deterministic: true,
+		StableExitPlies: 2, StableExitMargin: 1 << 20,
+		OnIteration: func(depth int, v int64, pv []tak.Move, st Stats) {
+			moves = append(moves, pv[0])
+		},
+	}
+	pv, _, st := NewMinimax(cfg).Analyze(p, 0)
+	// the search should stop at the first iteration whose move
+	// is the same as the two before it
+	want := -1
+	for i := 2; i < len(moves); i++ {
+		if moves[i].Equal(&moves[i-1]) && moves[i-1].Equal(&moves[i-2]) {
+			want = i + 1
+			break
+		}
+	}
+	if want < 0 || want >= cfg.Depth {
+		t.Fatalf("moves never settled: %s", formatpv(moves))
+	}
+	if !st.StableExit || st.CompletedDepth != want || len(moves) != want || !pv[0].Equal(&moves[want-1]) {
+		t.Errorf("exit=%v depth=%d pv=%s iterations=%s, want exit at depth %d",
+			st.StableExit, st.CompletedDepth, formatpv(pv), formatpv(moves), want)
+	}
+
+	moves = nil
+	cfg.StableExitPlies = 0
+	if _, _, st := NewMinimax(cfg).Analyze(p, 0); st.StableExit || st.CompletedDepth != cfg.Depth {
+		t.Errorf("disabled: exit=%v depth=%d", st.StableExit, st.CompletedDepth)
+	}
+}
+
+func TestTableIllegalMove(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x5/x,1,2,x2/x5/x5 1 2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, Seed: 1})
+	// Simulate a hash collision: an exact, deep entry for the
+	// root whose move is not legal there.
+	bad := tak.Move{X: 1, Y: 2, Type: tak.PlaceFlat}
+	te := ai.ttPut(p.Hash(), 10)
+	te.hash = p.Hash()
+	te.depth = 10
+	te.m = bad
+	te.value = 100
+	te.bound = exactBound
+
+	var illegal uint64
+	ai.cfg.OnIteration = func(depth int, v int64, pv []tak.Move, st Stats) {
+		illegal += st.TTIllegal
+	}
+	pv, _, st := ai.Analyze(p, time.Minute)
+	if len(pv) == 0 || pv[0].Equal(&bad) {
+		t.Fatalf("pv=%s", formatpv(pv))
+	}
+	if _, e := p.Move(&pv[0]); e != nil {
+		t.Errorf("illegal pv move %s: %v", ptn.FormatMove(&pv[0]), e)
+	}
+	if st.Depth != 3 || illegal == 0 {
+		t.Errorf("depth=%d illegal=%d", st.Depth, illegal)
+	}
+}
+
+func TestTableCollidingMove(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x5/x,1,2,x2/x5/x5 1 2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 3, Deterministic: true}
+	want, wantV, _ := NewMinimax(cfg).Analyze(p, 0)
+
+	// Simulate a hash collision with an entry whose move is legal
+	// here, deeper than the search and claiming a win.
+	collide := func(ai *MinimaxAI) {
+		bad := tak.Move{X: 4, Y: 4, Type: tak.PlaceFlat}
+		if bad.Equal(&want[0]) {
+			t.Fatalf("colliding move is the best move")
+		}
+		te := ai.ttPut(p.Hash(), 10)
+		te.hash = p.Hash()
+		te.depth = 10
+		te.m = bad
+		te.value = WinThreshold + 1
+		te.bound = exactBound
+	}
+
+	ai := NewMinimax(cfg)
+	collide(ai)
+	pv, v, st := ai.Analyze(p, 0)
+	if len(pv) == 0 || !pv[0].Equal(&want[0]) || v != wantV {
+		t.Errorf("pv=%s v=%d, want %s v=%d", formatpv(pv), v, formatpv(want), wantV)
+	}
+	if st.Visited == 0 {
+		t.Errorf("did not search the root")
+	}
+
+	// With a time limit, on a clock that passes it on every
+	// reading, the search from the seed is abandoned; the result
+	// must come from a search of this position, not the table.
+	cfg.Depth = 1
+	want, wantV, _ = NewMinimax(cfg).Analyze(p, 0)
+	cfg.Depth = 3
+	ai = NewMinimax(cfg)
+	collide(ai)
+	var clock time.Time
+	ai.now = func() time.Time {
+		clock = clock.Add(time.Hour)
+		return clock
+	}
+	pv, v, st = ai.Analyze(p, time.Second)
+	if len(pv) == 0 || !pv[0].Equal(&want[0]) || v != wantV {
+		t.Errorf("limit: pv=%s v=%d, want %s v=%d", formatpv(pv), v, formatpv(want), wantV)
+	}
+	if st.CompletedDepth != 1 {
+		t.Errorf("limit: CompletedDepth=%d, want 1", st.CompletedDepth)
+	}
+}
+
+func TestSingular(t *testing.T) {
+	// puzzle1 from tests/data/ai: White has a road in five moves
+	// that a plain search only proves at depth 7.
+	p, err := ptn.ParseTPS(
+		`2,x2,121C,1/x2,2,12,1/x2,2,12S,2/x3,1,1/x4,1 1 2`,
+	)
+	if err != nil {
+		panic(err)
+	}
+	search := func(depth int, singular bool) (int64, Stats, uint64) {
+		var total uint64
+		ai := NewMinimax(MinimaxConfig{
+			Size:          p.Size(),
+			Depth:         depth,
+			Singular:      singular,
+			Deterministic: true,
+			OnIteration: func(depth int, v int64, pv []tak.Move, st Stats) {
+				total += st.Visited + st.Evaluated
+			},
+		})
+		_, v, st := ai.Analyze(p, 0)
+		return v, st, total
+	}
+	if v, _, _ := search(5, false); v > WinThreshold {
+		t.Fatalf("plain depth 5 found win v=%d", v)
+	}
+	v, st, singular := search(5, true)
+	if v < WinThreshold {
+		t.Fatalf("singular depth 5 v=%d, want win", v)
+	}
+	if st.Singular == 0 {
+		t.Errorf("no singular extensions")
+	}
+	v, _, plain := search(7, false)
+	if v < WinThreshold {
+		t.Fatalf("plain depth 7 v=%d, want win", v)
+	}
+	if singular >= plain {
+		t.Errorf("singular searched %d nodes, plain %d", singular, plain)
+	}
+}
+
+func TestTemperature(t *testing.T) {
+	p := parseTPS(t, openingTPS)
+	const temp = 50
+	_, best, _ := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, Seed: 1}).Analyze(p, 0)
+	seen := make(map[string]bool)
+	for seed := int64(1); seed <= 10; seed++ {
+		cfg := MinimaxConfig{Size: p.Size(), Depth: 3, Seed: seed, Temperature: temp}
+		m := NewMinimax(cfg).GetMove(p, 0)
+		if again := NewMinimax(cfg).GetMove(p, 0); !again.Equal(&m) {
+			t.Errorf("seed %d: played %s then %s", seed, ptn.FormatMove(&m), ptn.FormatMove(&again))
+		}
+		seen[ptn.FormatMove(&m)] = true
+
+		child, e := p.Move(&m)
+		if e != nil {
+			t.Fatalf("seed %d: illegal move %s: %v", seed, ptn.FormatMove(&m), e)
+		}
+		_, v, _ := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 2, Seed: 1}).Analyze(child, 0)
+		if -v < best-temp {
+			t.Errorf("seed %d: %s v=%d, best %d", seed, ptn.FormatMove(&m), -v, best)
+		}
+	}
+	if len(seen) < 2 {
+		t.Errorf("temperature %d always played %v", temp, seen)
+	}
+
+	// a road in one is always completed
+	p = parseTPS(t, `x5/x5/1,1,1,1,x/x5/2,2,2,x2 1 5`)
+	for seed := int64(1); seed <= 5; seed++ {
+		m := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, Seed: seed, Temperature: maxEval}).GetMove(p, 0)
+		child, e := p.Move(&m)
+		if e != nil {
+			t.Fatalf("seed %d: illegal move %s: %v", seed, ptn.FormatMove(&m), e)
+		}
+		if over, winner := child.GameOver(); !over || winner != tak.White {
+			t.Errorf("seed %d: played %s, which does not win", seed, ptn.FormatMove(&m))
+		}
+	}
+}
+
+func TestTemperatureLimit(t *testing.T) {
+	p := parseTPS(t, openingTPS)
+	const limit = 200 * time.Millisecond
+	m := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: maxStack, Seed: 1, Temperature: 50})
+	start := time.Now()
+	mv := m.GetMove(p, limit)
+	if d := time.Since(start); d > limit+limit/2 {
+		t.Errorf("GetMove took %s with a limit of %s", d, limit)
+	}
+	if _, err := p.Move(&mv); err != nil {
+		t.Errorf("illegal move %s: %v", ptn.FormatMove(&mv), err)
+	}
+}
+
+func TestForcedMove(t *testing.T) {
+	p, err := ptn.ParseTPS(`x5/x5/1,1,1,1,x/x5/2,2,2,x2 1 5`)
+	if err != nil {
+		panic(err)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 5})
+	pv, v, st := ai.Analyze(p, 0)
+	if !st.Forced || st.Visited != 0 {
+		t.Errorf("road in one: forced=%v visited=%d", st.Forced, st.Visited)
+	}
+	if v < WinThreshold {
+		t.Errorf("road in one: v=%d, want win", v)
+	}
+	child, e := p.Move(&pv[0])
+	if e != nil {
+		t.Fatalf("illegal move %s: %v", ptn.FormatMove(&pv[0]), e)
+	}
+	if over, winner := child.GameOver(); !over || winner != tak.White {
+		t.Errorf("played %s, which does not win", ptn.FormatMove(&pv[0]))
+	}
+
+	// Black's road threat does not force White's reply
+	p, err = ptn.ParseTPS(`x5/x5/2,2,2,2,x/x5/1,1,1,x2 1 5`)
+	if err != nil {
+		panic(err)
+	}
+	if _, _, st := ai.Analyze(p, 0); st.Forced {
+		t.Errorf("opponent's threat: forced")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	var buf bytes.Buffer
+	NewMinimax(MinimaxConfig{
+		Size:   p.Size(),
+		Depth:  2,
+		Debug:  1,
+		Logger: log.New(&buf, "", 0),
+	}).Analyze(p, 0)
+	if !strings.Contains(buf.String(), "[minimax] deepen: depth=2") {
+		t.Errorf("debug output missing from logger:\n%s", buf.String())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	ai := NewMinimax(MinimaxConfig{
+		Size:          p.Size(),
+		Depth:         2,
+		Deterministic: true,
+		Contempt:      1 << 20,
+	})
+	best, _, _ := ai.Analyze(p, 0)
+
+	// the best move returns to a position from earlier in
+	// the game, which the engine considers a bad draw
+	prev, err := p.Move(&best[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ai.SetHistory([]*tak.Position{prev})
+	if ai.ttGet(p.Hash()) == nil {
+		t.Error("SetHistory cleared the table")
+	}
+	ai.ClearTable()
+	pv, v, _ := ai.Analyze(p, 0)
+	if pv[0].Equal(&best[0]) {
+		t.Errorf("with history: repeated the position with %s", ptn.FormatMove(&pv[0]))
+	}
+	if v <= -1<<20 {
+		t.Errorf("with history: v=%d, a draw", v)
+	}
+	if ai.ttGet(p.Hash()) != nil {
+		t.Error("with history: stored a value that depends on the history")
+	}
+
+	ai.SetHistory(nil)
+	if pv, _, _ := ai.Analyze(p, 0); !pv[0].Equal(&best[0]) {
+		t.Errorf("history cleared: played %s, want %s", ptn.FormatMove(&pv[0]), ptn.FormatMove(&best[0]))
+	}
+}
+
+func TestAnalyzeGameOver(t *testing.T) {
+	cases := []struct {
+		tps  string
+		sign int64
+	}{
+		// White has a road, and Black is to move
+		{"x5/x5/1,1,1,1,1/x5/2,2,2,2,x 2 6", -1},
+		// a full board, tied on flats
+		{"1,2,1/2,1S,2/1,2,1 1 6", 0},
+	}
+	for _, tc := range cases {
+		p, err := ptn.ParseTPS(tc.tps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3})
+		pv, v, _ := ai.Analyze(p, 0)
+		if len(pv) != 0 {
+			t.Errorf("%s: pv=%s", tc.tps, formatpv(pv))
+		}
+		switch {
+		case tc.sign < 0 && v > -WinThreshold,
+			tc.sign == 0 && v != 0:
+			t.Errorf("%s: v=%d", tc.tps, v)
+		}
+		if m := ai.GetMove(p, 0); m.Type != 0 {
+			t.Errorf("%s: GetMove=%s", tc.tps, ptn.FormatMove(&m))
+		}
+	}
+}
+
+func TestOpeningSwap(t *testing.T) {
+	for size := 3; size <= 6; size++ {
+		p := tak.New(tak.Config{Size: size})
+		ai := NewMinimax(MinimaxConfig{Size: size, Depth: 3, Deterministic: true})
+		for ply := 0; ply < 2; ply++ {
+			m := ai.GetMove(p, 0)
+			if m.Type != tak.PlaceFlat {
+				t.Fatalf("size=%d ply=%d: played %s, want a flat", size, ply, ptn.FormatMove(&m))
+			}
+			next, e := p.Move(&m)
+			if e != nil {
+				t.Fatalf("size=%d ply=%d: illegal move %s: %v", size, ply, ptn.FormatMove(&m), e)
+			}
+			if c := next.Top(m.X, m.Y).Color(); c != p.ToMove().Flip() {
+				t.Errorf("size=%d ply=%d: placed a %s stone", size, ply, c)
+			}
+			p = next
+		}
+	}
+}
+
+func TestDeepStack(t *testing.T) {
+	p, err := ptn.ParseTPS("x3/1,x,2/x3 1 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: 3, Depth: 14, Singular: true})
+	if len(ai.stack) < 14 || len(ai.path) < 15 {
+		t.Fatalf("depth 14: stack=%d path=%d", len(ai.stack), len(ai.path))
+	}
+	// search the last few plies of a deep search directly
+	if pv, _ := ai.minimax(p, 11, 3, nil, minEval-1, maxEval+1); len(pv) == 0 {
+		t.Errorf("no move at ply 11")
+	}
+}
+
+func TestNewMinimaxChecked(t *testing.T) {
+	if _, err := NewMinimaxChecked(MinimaxConfig{Size: 5}); err != nil {
+		t.Errorf("size 5: %v", err)
+	}
+	for _, size := range []int{0, tak.MinSize - 1, tak.MaxSize + 1} {
+		if m, err := NewMinimaxChecked(MinimaxConfig{Size: size}); err == nil || m != nil {
+			t.Errorf("size %d: m=%v err=%v", size, m, err)
+		}
+	}
+}
+
+func TestTableMemory(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	const budget = 1 << 16
+	small := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true, TableMemory: budget})
+	if n := len(small.table) * int(unsafe.Sizeof(tableBucket{})); n == 0 || n > budget {
+		t.Fatalf("table of %d bytes, want at most %d", n, budget)
+	}
+	pv, v, st := small.Analyze(p, 0)
+	if st.TTFill > 1 {
+		t.Errorf("fill=%f", st.TTFill)
+	}
+	full := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true})
+	fpv, fv, _ := full.Analyze(p, 0)
+	if !pv[0].Equal(&fpv[0]) || v != fv {
+		t.Errorf("small table: %s=%d, full table: %s=%d",
+			ptn.FormatMove(&pv[0]), v, ptn.FormatMove(&fpv[0]), fv)
+	}
+}
+
+func TestDepthBeyondStack(t *testing.T) {
+	// walls on every square but two, so that the only moves are
+	// slides into the holes and placements that fill the board;
+	// the tree is narrow enough to search deeply
+	p, err := ptn.ParseTPS("1S,2S,1S,2S/2S,1S,2S,1S/1S,2S,x,x/2S,1S,2S,1S 1 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const depth = 15
+	deepest := 0
+	ai := NewMinimax(MinimaxConfig{
+		Size:          p.Size(),
+		Depth:         depth,
+		Deterministic: true,
+		EvaluateContext: func(m *MinimaxAI, p *tak.Position, ctx SearchContext) int64 {
+			if ctx.Ply > deepest {
+				deepest = ctx.Ply
+			}
+			return 0
+		},
+	})
+	_, _, st := ai.Analyze(p, 0)
+	if st.Depth != depth || st.CompletedDepth != depth {
+		t.Errorf("depth=%d completed=%d, want %d", st.Depth, st.CompletedDepth, depth)
+	}
+	if deepest != depth {
+		t.Errorf("deepest evaluation at ply %d, want %d", deepest, depth)
+	}
+}
+
+func TestNullMove(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	cfg := MinimaxConfig{Size: 5, Depth: 5, Deterministic: true}
+	_, _, plain := NewMinimax(cfg).Analyze(p, 0)
+	cfg.NullMove = true
+	_, _, st := NewMinimax(cfg).Analyze(p, 0)
+	if st.NullMoves == 0 || st.NullCuts == 0 || st.Visited >= plain.Visited {
+		t.Errorf("null moves=%d cuts=%d visited=%d, plain visited=%d",
+			st.NullMoves, st.NullCuts, st.Visited, plain.Visited)
+	}
+}
+
+// TestNullMoveZugzwang searches a walled-in, nearly full board,
+// where every move the player to move has is worse than passing. A
+// null move fails high there, above the position's true value, so
+// null-move pruning would cut it off wrongly; without the
+// NearZugzwang gate, the search below plays d2+, valued at 5.
+func TestNullMoveZugzwang(t *testing.T) {
+	p := parseTPS(t, `1,2,1S,x/2,1212S,2,2/2S,11,1S,1/x,1,x,1S 1 12`)
+	if !p.NearZugzwang() || p.HasRoadThreat(tak.Black) {
+		t.Fatal("null moves are not gated by NearZugzwang alone")
+	}
+
+	m := NewMinimax(MinimaxConfig{Size: 4, Depth: 3, Deterministic: true})
+	_, v := m.minimax(p, 1, 3, nil, minEval-1, maxEval+1)
+	if !m.nullMove(p, 1, 3, v+1) {
+		t.Errorf("passing does not fail high against %d", v+1)
+	}
+
+	cfg := MinimaxConfig{Size: 4, Depth: 5, Deterministic: true}
+	wantPV, want, _ := NewMinimax(cfg).Analyze(p, 0)
+	cfg.NullMove = true
+	pv, v, _ := NewMinimax(cfg).Analyze(p, 0)
+	if v != want || !pv[0].Equal(&wantPV[0]) {
+		t.Errorf("null move: %s %d, want %s %d",
+			ptn.FormatMove(&pv[0]), v, ptn.FormatMove(&wantPV[0]), want)
+	}
+}
+
+// countingSource counts the random numbers drawn from it.
+type countingSource struct {
+	rand.Source
+	n int
+}
+
+func (s *countingSource) Int63() int64 {
+	s.n++
+	return s.Source.Int63()
+}
+
+func TestRandSource(t *testing.T) {
+	p := parseTPS(t, openingTPS)
+	var seeds []int64
+	src := &countingSource{}
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 3, Seed: 7,
+		RandSource: func(seed int64) rand.Source {
+			seeds = append(seeds, seed)
+			src.Source = rand.NewSource(seed)
+			return src
+		},
+	}
+	NewMinimax(cfg).Analyze(p, 0)
+	if !reflect.DeepEqual(seeds, []int64{7}) || src.n == 0 {
+		t.Errorf("seeds=%v draws=%d", seeds, src.n)
+	}
+
+	// different seeds choose different moves under temperature
+	// (see TestTemperature), but not if the source ignores them
+	cfg.Temperature = 50
+	cfg.RandSource = func(int64) rand.Source { return rand.NewSource(42) }
+	want := NewMinimax(cfg).GetMove(p, 0)
+	for seed := int64(1); seed <= 10; seed++ {
+		cfg.Seed = seed
+		if m := NewMinimax(cfg).GetMove(p, 0); !m.Equal(&want) {
+			t.Fatalf("seed %d: played %s, want %s", seed, ptn.FormatMove(&m), ptn.FormatMove(&want))
+		}
+	}
+}
+
+func TestEvaluateMoves(t *testing.T) {
+	p, err := ptn.ParseTPS(
+		`x5/x2,2,x2/x,1,1,x2/x2,2,x2/x5 1 3`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const depth = 3
+	cfg := MinimaxConfig{Size: p.Size(), Depth: depth, Deterministic: true}
+	vals := NewMinimax(cfg).EvaluateMoves(p, 0)
+	if len(vals) != len(p.AllMoves(nil)) {
+		t.Fatalf("got %d values for %d moves", len(vals), len(p.AllMoves(nil)))
+	}
+
+	// each value is that of the move's position searched on its
+	// own, and the best is the value of p
+	cfg.Depth = depth - 1
+	best := int64(minEval)
+	for _, mv := range p.AllMoves(nil) {
+		child, _ := p.Move(&mv)
+		_, want, _ := NewMinimax(cfg).AnalyzeWhite(child, 0)
+		if got := vals[ptn.FormatMove(&mv)]; got != want {
+			t.Errorf("%s: got %d, want %d", ptn.FormatMove(&mv), got, want)
+		}
+		if want > best {
+			best = want
+		}
+	}
+	cfg.Depth = depth
+	if _, v, _ := NewMinimax(cfg).AnalyzeWhite(p, 0); v != best {
+		t.Errorf("best move value %d, Analyze value %d", best, v)
+	}
+
+	// a time limit returns the deepest complete iteration
+	cfg.Depth = maxStack
+	start := time.Now()
+	if vals := NewMinimax(cfg).EvaluateMoves(p, 50*time.Millisecond); len(vals) != len(p.AllMoves(nil)) {
+		t.Errorf("limited: got %d values", len(vals))
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("limited: took %s", d)
+	}
+
+	over, _ := ptn.ParseTPS(`1,1,1/x3/2,2,x 2 3`)
+	cfg.Size = 3
+	if vals := NewMinimax(cfg).EvaluateMoves(over, 0); len(vals) != 0 {
+		t.Errorf("game over: %v", vals)
+	}
+}
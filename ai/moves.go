@@ -3,9 +3,53 @@ package ai
 import (
 	"sort"
 
+	"github.com/nelhage/taktician/bitboard"
+	"github.com/nelhage/taktician/ptn"
 	"github.com/nelhage/taktician/tak"
 )
 
+// MoveSource generates the moves to search at each node of the
+// search but the root, in the order to search them. Ordering matters
+// to alpha-beta search: the sooner it tries the best move, the more
+// of the rest it can cut off.
+//
+// The search always tries the move from the transposition table and
+// the principal variation from the previous iteration first; the
+// moves from the source follow, skipping those two. The root's moves
+// are searched in random order, or PTN order if Deterministic is set.
+type MoveSource interface {
+	// Moves appends the moves to search in p, at `ply` plies
+	// from the root with `depth` plies left to search, to buf,
+	// and returns the result. Illegal moves are skipped, so it
+	// may return any superset of the legal moves, as
+	// tak.Position.AllMoves does.
+	Moves(m *MinimaxAI, p *tak.Position, ply, depth int, buf []tak.Move) []tak.Move
+	// Cutoff is called when move causes a beta cutoff in p,
+	// for sources that order moves by their history.
+	Cutoff(m *MinimaxAI, p *tak.Position, move tak.Move, ply, depth int)
+}
+
+// DefaultMoveSource is the MoveSource used unless
+// MinimaxConfig.MoveSource is set. It generates moves subject to
+// MinimaxConfig.MaxCarry, and orders them as described at moveKey,
+// by the engine's HeatMap, except at the last ply before the leaves,
+// where ordering does not pay for itself, or if NoSort is set.
+var DefaultMoveSource MoveSource = heatMapSource{}
+
+type heatMapSource struct{}
+
+func (heatMapSource) Moves(m *MinimaxAI, p *tak.Position, ply, depth int, buf []tak.Move) []tak.Move {
+	ms := p.AllMovesCarry(buf, m.cfg.MaxCarry)
+	if depth > 1 && !m.cfg.NoSort {
+		m.sortMoves(p, ply, ms)
+	}
+	return ms
+}
+
+// Cutoff does nothing, since the search maintains the HeatMap
+// itself.
+func (heatMapSource) Cutoff(*MinimaxAI, *tak.Position, tak.Move, int, int) {}
+
 type moveGenerator struct {
 	ai    *MinimaxAI
 	ply   int
@@ -19,17 +63,71 @@ type moveGenerator struct {
 	i  int
 }
 
-type sortMoves struct{ m *moveGenerator }
+// moveKey is the sort key of a move at an interior node. Spreads,
+// slides that give up material without creating a road threat, go
+// last; the rest are ordered by the history of their squares, and
+// then by their estimated gain.
+type moveKey struct {
+	spread bool
+	heat   uint64
+	gain   int
+}
+
+type sortMoves struct {
+	ms   []tak.Move
+	keys []moveKey
+}
 
-func (s sortMoves) Len() int { return len(s.m.ms) }
+func (s sortMoves) Len() int { return len(s.ms) }
 func (s sortMoves) Less(i, j int) bool {
-	ii := s.m.ms[i].X + s.m.ms[i].Y*s.m.ai.cfg.Size
-	ji := s.m.ms[j].X + s.m.ms[j].Y*s.m.ai.cfg.Size
-	return s.m.ai.heatMap[ii] > s.m.ai.heatMap[ji]
+	ki, kj := &s.keys[i], &s.keys[j]
+	if ki.spread != kj.spread {
+		return kj.spread
+	}
+	if ki.heat != kj.heat {
+		return ki.heat > kj.heat
+	}
+	return ki.gain > kj.gain
 }
 func (s sortMoves) Swap(i, j int) {
-	s.m.ms[i], s.m.ms[j] = s.m.ms[j], s.m.ms[i]
+	s.ms[i], s.ms[j] = s.ms[j], s.ms[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// sortMoves orders ms, the moves in p at `ply`, by their moveKeys.
+func (ai *MinimaxAI) sortMoves(p *tak.Position, ply int, ms []tak.Move) {
+	f := &ai.stack[ply]
+	keys := f.keys[:0]
+	threat, threatKnown := false, false
+	for i := range ms {
+		m := &ms[i]
+		k := moveKey{
+			heat: ai.heatMap[tak.SquareIndex(m.X, m.Y, ai.cfg.Size)],
+			gain: slideGain(p, m),
+		}
+		if k.gain < 0 {
+			if !threatKnown {
+				threat = p.HasRoadThreat(p.ToMove())
+				threatKnown = true
+			}
+			k.spread = !ai.createsThreat(p, m, threat)
+		}
+		keys = append(keys, k)
+	}
+	f.keys = keys
+	// sort through the frame, so that the sort.Interface does
+	// not allocate
+	f.sort = sortMoves{ms, keys}
+	sort.Stable(&f.sort)
+}
+
+type byPTN []tak.Move
+
+func (b byPTN) Len() int { return len(b) }
+func (b byPTN) Less(i, j int) bool {
+	return ptn.FormatMove(&b[i]) < ptn.FormatMove(&b[j])
 }
+func (b byPTN) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
 
 func (mg *moveGenerator) Next() (m tak.Move, p *tak.Position) {
 	for {
@@ -54,14 +152,16 @@ func (mg *moveGenerator) Next() (m tak.Move, p *tak.Position) {
 			fallthrough
 		case 2:
 			mg.i++
-			mg.ms = mg.p.AllMoves(mg.ai.stack[mg.ply].moves[:0])
-			if mg.ply == 0 {
+			buf := mg.ai.stack[mg.ply].moves[:0]
+			if mg.ply > 0 {
+				mg.ms = mg.ai.moves.Moves(mg.ai, mg.p, mg.ply, mg.depth, buf)
+			} else if mg.ms = mg.p.AllMovesCarry(buf, mg.ai.cfg.MaxCarry); mg.ai.cfg.Deterministic {
+				sort.Sort(byPTN(mg.ms))
+			} else {
 				for i := len(mg.ms) - 1; i > 0; i-- {
 					j := mg.ai.rand.Int31n(int32(i))
 					mg.ms[j], mg.ms[i] = mg.ms[i], mg.ms[j]
 				}
-			} else if mg.depth > 1 && !mg.ai.cfg.NoSort {
-				sort.Sort(sortMoves{mg})
 			}
 			fallthrough
 		default:
@@ -82,5 +182,118 @@ func (mg *moveGenerator) Next() (m tak.Move, p *tak.Position) {
 		if e == nil {
 			return m, child
 		}
+		if mg.i == 1 {
+			// the table move was illegal; see ttLegal
+			mg.ai.st.TTIllegal++
+		}
+	}
+}
+
+// createsThreat reports whether m, a move in p, wins the game or
+// gives the mover a road threat, where `had` says whether they had
+// one already.
+func (ai *MinimaxAI) createsThreat(p *tak.Position, m *tak.Move, had bool) bool {
+	child, e := p.MoveToAllocated(m, ai.scratch)
+	if e != nil {
+		return false
+	}
+	if over, winner := child.GameOver(); over {
+		return winner == p.ToMove()
+	}
+	return !had && child.HasRoadThreat(p.ToMove())
+}
+
+// quietMove reports whether the move from p to child is a candidate
+// for reduction: it neither ends the game nor takes control of any
+// of the opponent's stacks.
+func quietMove(p, child *tak.Position) bool {
+	if over, _ := child.GameOver(); over {
+		return false
+	}
+	var before, after uint64
+	if p.ToMove() == tak.White {
+		before, after = p.Black, child.Black
+	} else {
+		before, after = p.White, child.White
+	}
+	return bitboard.Popcount(after) >= bitboard.Popcount(before)
+}
+
+// slideGain is a static estimate of the material won by a slide,
+// used to order moves without playing them out. It counts the
+// change in the number of squares controlled by the mover minus the
+// number controlled by the opponent, plus one for a capstone
+// flattening an enemy wall. Placements score zero.
+func slideGain(p *tak.Position, m *tak.Move) int {
+	var dx, dy int
+	switch m.Type {
+	case tak.SlideLeft:
+		dx = -1
+	case tak.SlideRight:
+		dx = 1
+	case tak.SlideUp:
+		dy = 1
+	case tak.SlideDown:
+		dy = -1
+	default:
+		return 0
+	}
+	size := p.Size()
+	us, them := p.White, p.Black
+	if p.ToMove() == tak.Black {
+		us, them = them, us
+	}
+	i := uint(tak.SquareIndex(m.X, m.Y, size))
+	ct := 0
+	for _, c := range m.Slides {
+		ct += int(c)
+	}
+	h := int(p.Height[i])
+	if ct > h {
+		return 0
+	}
+	// ours reports whether the j'th piece from the top of the
+	// source stack belongs to the mover
+	ours := func(j int) bool {
+		if j == 0 {
+			return true
+		}
+		black := p.Stacks[i]&(1<<uint(j-1)) != 0
+		return black == (p.ToMove() == tak.Black)
+	}
+
+	gain := 0
+	switch {
+	case ct == h:
+		gain--
+	case !ours(ct):
+		gain -= 2
+	}
+
+	x, y := m.X, m.Y
+	for _, c := range m.Slides {
+		x += dx
+		y += dy
+		if x < 0 || x >= size || y < 0 || y >= size {
+			return 0
+		}
+		ct -= int(c)
+		bit := uint64(1) << uint(tak.SquareIndex(x, y, size))
+		if ours(ct) {
+			switch {
+			case them&bit != 0:
+				gain += 2
+			case us&bit == 0:
+				gain++
+			}
+		} else if us&bit != 0 {
+			gain -= 2
+		} else if them&bit == 0 {
+			gain--
+		}
+		if ct == 0 && p.Caps&(1<<i) != 0 && p.Standing&them&bit != 0 {
+			gain++
+		}
 	}
+	return gain
 }
@@ -0,0 +1,210 @@
+package ai
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+// sortedKeys sorts the moves in p as an interior node would, and
+// returns them with their keys.
+func sortedKeys(m *MinimaxAI, p *tak.Position) ([]tak.Move, []moveKey) {
+	ms := p.AllMoves(nil)
+	m.sortMoves(p, 1, ms)
+	return ms, m.stack[1].keys
+}
+
+func TestSpreadOrdering(t *testing.T) {
+	p, err := ptn.ParseTPS("x4/x4/x,1,1,1/121,2,2,x 1 6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3})
+	ms, keys := sortedKeys(m, p)
+	spread := make(map[string]bool)
+	for i := range ms {
+		if i > 0 && keys[i-1].spread && !keys[i].spread {
+			t.Errorf("%s sorted after a spread", ptn.FormatMove(&ms[i]))
+		}
+		spread[ptn.FormatMove(&ms[i])] = keys[i].spread
+	}
+	// a1+ gives up a1 but completes a road; d2< gives up d2 for
+	// nothing
+	if spread["a1+"] || !spread["d2<"] || spread["a2"] {
+		t.Errorf("spreads: a1+=%v d2<=%v a2=%v", spread["a1+"], spread["d2<"], spread["a2"])
+	}
+}
+
+func TestSacrificialSpread(t *testing.T) {
+	// Black wins with a spread that gives up material and
+	// creates no immediate threat, so is ordered last.
+	cases := []struct {
+		tps  string
+		move string
+	}{
+		{"x2,2S,1222221,x/1C,112S,1,x2/x2,1S,x2/x2,2,12111112C,1/2,2,2,112,1 2 35", "5d2-"},
+		{"x3,2,x/1,1,1S,2212C,2/x2,1,2,x/x2,2,x2/1,1,1C,221212S,x 2 15", "3d4-111"},
+		{"112,2,x,1S,2/12,2,x3/12121S,212,112,x2/x,12C,21,2,1/x,1,x,1C,2 2 28", "b2+"},
+	}
+	for _, tc := range cases {
+		p, err := ptn.ParseTPS(tc.tps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, Deterministic: true})
+		ms, keys := sortedKeys(m, p)
+		for i := range ms {
+			if ptn.FormatMove(&ms[i]) == tc.move && !keys[i].spread {
+				t.Errorf("%s: %s is not a spread", tc.tps, tc.move)
+			}
+		}
+		pv, v, _ := m.Analyze(p, 0)
+		if len(pv) == 0 || ptn.FormatMove(&pv[0]) != tc.move || v < WinThreshold {
+			t.Errorf("%s: pv=%s v=%d, want %s winning", tc.tps, formatpv(pv), v, tc.move)
+		}
+		// the root's moves are not sorted, so search p as an
+		// interior node too, where the spread is tried last
+		pv, v = m.minimax(p, 1, 3, nil, minEval-1, maxEval+1)
+		if len(pv) == 0 || ptn.FormatMove(&pv[0]) != tc.move || v < WinThreshold {
+			t.Errorf("%s: interior: pv=%s v=%d, want %s winning", tc.tps, formatpv(pv), v, tc.move)
+		}
+	}
+}
+
+// noSpreadSource orders moves as DefaultMoveSource does, but
+// without putting spreads last.
+type noSpreadSource struct{}
+
+func (noSpreadSource) Moves(m *MinimaxAI, p *tak.Position, ply, depth int, buf []tak.Move) []tak.Move {
+	ms := p.AllMovesCarry(buf, m.cfg.MaxCarry)
+	if depth > 1 {
+		keys := make([]moveKey, len(ms))
+		for i := range ms {
+			keys[i] = moveKey{
+				heat: m.heatMap[tak.SquareIndex(ms[i].X, ms[i].Y, m.cfg.Size)],
+				gain: slideGain(p, &ms[i]),
+			}
+		}
+		sort.Stable(sortMoves{ms, keys})
+	}
+	return ms
+}
+
+func (noSpreadSource) Cutoff(*MinimaxAI, *tak.Position, tak.Move, int, int) {}
+
+func TestSpreadOrderingStats(t *testing.T) {
+	// a position with several tall stacks, and so many spreads
+	p := parseTPS(t, "x2,2S,1222221,x/1C,112S,1,x2/x2,1S,x2/x2,2,12111112C,1/2,2,2,112,1 1 35")
+	// without the table, alpha-beta finds the same value
+	// however it orders moves
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true, NoTable: true}
+	_, want, last := NewMinimax(cfg).Analyze(p, 0)
+	cfg.MoveSource = noSpreadSource{}
+	_, v, inPlace := NewMinimax(cfg).Analyze(p, 0)
+	if v != want {
+		t.Errorf("value=%d, want %d", v, want)
+	}
+	if last.Visited+last.Evaluated >= inPlace.Visited+inPlace.Evaluated || last.CutNodes >= inPlace.CutNodes {
+		t.Errorf("spreads last: nodes=%d cuts=%d; in place: nodes=%d cuts=%d",
+			last.Visited+last.Evaluated, last.CutNodes, inPlace.Visited+inPlace.Evaluated, inPlace.CutNodes)
+	}
+}
+
+// historySource orders moves purely by how often the same move has
+// caused a cutoff.
+type historySource struct {
+	history map[string]int
+	calls   int
+}
+
+func (h *historySource) Moves(m *MinimaxAI, p *tak.Position, ply, depth int, buf []tak.Move) []tak.Move {
+	h.calls++
+	ms := p.AllMoves(buf)
+	sort.Stable(byHistory{ms, h.history})
+	return ms
+}
+
+type byHistory struct {
+	ms      []tak.Move
+	history map[string]int
+}
+
+func (b byHistory) Len() int { return len(b.ms) }
+func (b byHistory) Less(i, j int) bool {
+	return b.history[ptn.FormatMove(&b.ms[i])] > b.history[ptn.FormatMove(&b.ms[j])]
+}
+func (b byHistory) Swap(i, j int) { b.ms[i], b.ms[j] = b.ms[j], b.ms[i] }
+
+func (h *historySource) Cutoff(m *MinimaxAI, p *tak.Position, move tak.Move, ply, depth int) {
+	h.history[ptn.FormatMove(&move)] += depth * depth
+}
+
+func TestMoveSource(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	// without the table, alpha-beta finds the same value
+	// however it orders moves
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true, NoTable: true}
+	_, want, plain := NewMinimax(cfg).Analyze(p, 0)
+	src := &historySource{history: make(map[string]int)}
+	cfg.MoveSource = src
+	_, v, st := NewMinimax(cfg).Analyze(p, 0)
+	if v != want {
+		t.Errorf("value=%d, want %d", v, want)
+	}
+	if src.calls == 0 || len(src.history) == 0 {
+		t.Errorf("MoveSource not used: calls=%d cutoffs=%d", src.calls, len(src.history))
+	}
+	if st.Visited == plain.Visited {
+		t.Errorf("visited %d nodes with either source", st.Visited)
+	}
+}
+
+func TestDefaultMoveSourceAllocs(t *testing.T) {
+	p, err := ptn.ParseTPS("x4/x4/x,1,1,1/121,2,2,x 1 6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3})
+	for _, depth := range []int{1, 3} {
+		allocs := testing.AllocsPerRun(100, func() {
+			mg := moveGenerator{ai: m, ply: 1, depth: depth, p: p}
+			for _, child := mg.Next(); child != nil; _, child = mg.Next() {
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("depth=%d: generating moves allocated %v times", depth, allocs)
+		}
+	}
+}
+
+func TestMaxCarry(t *testing.T) {
+	// Black's quickest win carries five stones
+	p, err := ptn.ParseTPS("x2,2S,1222221,x/1C,112S,1,x2/x2,1S,x2/x2,2,12111112C,1/2,2,2,112,1 2 35")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 3, Deterministic: true}
+	pv, want, full := NewMinimax(cfg).Analyze(p, 0)
+	if len(pv) == 0 || ptn.FormatMove(&pv[0]) != "5d2-" || want < WinThreshold {
+		t.Fatalf("full: pv=%s v=%d", formatpv(pv), want)
+	}
+	cfg.MaxCarry = 3
+	pv, v, st := NewMinimax(cfg).Analyze(p, 0)
+	if st.Visited+st.Evaluated >= full.Visited+full.Evaluated {
+		t.Errorf("MaxCarry=3 searched %d nodes, all moves %d",
+			st.Visited+st.Evaluated, full.Visited+full.Evaluated)
+	}
+	// the search misses the quickest win
+	if len(pv) == 0 || v >= want {
+		t.Fatalf("MaxCarry=3: pv=%s v=%d, all moves v=%d", formatpv(pv), v, want)
+	}
+	carry := 0
+	for _, d := range pv[0].Slides {
+		carry += int(d)
+	}
+	if carry > 3 {
+		t.Errorf("MaxCarry=3 played %s", ptn.FormatMove(&pv[0]))
+	}
+}
@@ -0,0 +1,46 @@
+package ai
+
+import (
+	"time"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// PuzzleConfig configures FindPuzzle.
+type PuzzleConfig struct {
+	// Minimax configures the search; its Size is set from the
+	// position.
+	Minimax MinimaxConfig
+	// Limit bounds the search's time, as for Analyze; 0 searches
+	// to Minimax.Depth.
+	Limit time.Duration
+	// Margin is how far every other move's value must fall below
+	// the best move's. Whatever the margin, no other move may
+	// win.
+	Margin int64
+}
+
+// FindPuzzle reports whether p makes a tactics puzzle: whether the
+// player to move has a winning move, found within the configured
+// search, that is the only one. After the best move is found, every
+// other legal move is tested, to the same depth, against the best
+// move's value less cfg.Margin; if any reaches it, or wins too, p is
+// rejected.
+func FindPuzzle(p *tak.Position, cfg PuzzleConfig) (tak.Move, bool) {
+	mcfg := cfg.Minimax
+	mcfg.Size = p.Size()
+	m := NewMinimax(mcfg)
+	pv, v, st := m.Analyze(p, cfg.Limit)
+	if len(pv) == 0 || v < WinThreshold {
+		return tak.Move{}, false
+	}
+	bound := v - cfg.Margin
+	if bound > WinThreshold {
+		bound = WinThreshold
+	}
+	cands := m.nearBest(p, st.Depth, bound, time.Time{})
+	if len(cands) != 1 || !cands[0].Equal(&pv[0]) {
+		return tak.Move{}, false
+	}
+	return pv[0], true
+}
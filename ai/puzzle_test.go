@@ -0,0 +1,43 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+)
+
+func TestFindPuzzle(t *testing.T) {
+	cases := []struct {
+		name string
+		tps  string
+		ok   bool
+		move string
+	}{
+		// c2 completes White's road, and anything else lets
+		// Black complete theirs
+		{"road in one", "x3/1,1,x/2,2,x 1 3", true, "c2"},
+		// White can complete the road with a flat or the
+		// capstone
+		{"two roads", "x5/x5/1,1,1,1,x/x5/2,2,2,x2 1 5", false, ""},
+		// puzzle1 from tests/data/ai: several moves win
+		{"puzzle1", "2,x2,121C,1/x2,2,12,1/x2,2,12S,2/x3,1,1/x4,1 1 2", false, ""},
+		// nobody is winning
+		{"opening", "x5/x5/x5/x5/x5 1 1", false, ""},
+	}
+	for _, tc := range cases {
+		p, err := ptn.ParseTPS(tc.tps)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		m, ok := FindPuzzle(p, PuzzleConfig{
+			Minimax: MinimaxConfig{Depth: 5, Deterministic: true},
+		})
+		if ok != tc.ok {
+			t.Errorf("%s: ok=%v move=%s", tc.name, ok, ptn.FormatMove(&m))
+			continue
+		}
+		if ok && ptn.FormatMove(&m) != tc.move {
+			t.Errorf("%s: move=%s, want %s", tc.name, ptn.FormatMove(&m), tc.move)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ai
+
+import (
+	"time"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+// Report is the result of AnalyzeTPS, laid out to be encoded as
+// JSON for scripts and services. Moves are in PTN, and values are
+// from White's perspective.
+type Report struct {
+	TPS    string `json:"tps"`
+	Size   int    `json:"size"`
+	ToMove string `json:"to_move"`
+
+	// Over is set if the game has already ended, in which case
+	// there is no move or PV and Winner is set unless it was a
+	// draw.
+	Over bool `json:"over"`
+
+	Move  string   `json:"move,omitempty"`
+	PV    []string `json:"pv"`
+	Value int64    `json:"value"`
+
+	// Mate is set if Value is a forced win, for Winner, in
+	// MatePlies plies.
+	Mate      bool   `json:"mate"`
+	MatePlies int    `json:"mate_plies,omitempty"`
+	Winner    string `json:"winner,omitempty"`
+
+	Stats ReportStats `json:"stats"`
+}
+
+// ReportStats summarizes the search behind a Report.
+type ReportStats struct {
+	Depth     int     `json:"depth"`
+	Visited   uint64  `json:"visited"`
+	Evaluated uint64  `json:"evaluated"`
+	TTHits    uint64  `json:"tt_hits"`
+	Forced    bool    `json:"forced"`
+	Seconds   float64 `json:"seconds"`
+}
+
+// AnalyzeTPS analyzes the position given in TPS, as Analyze does,
+// with a MinimaxAI configured by cfg and a time limit of limit. The
+// board size is taken from the TPS, overriding cfg.Size.
+func AnalyzeTPS(tps string, cfg MinimaxConfig, limit time.Duration) (*Report, error) {
+	p, err := ptn.ParseTPS(tps)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Size = p.Size()
+	start := time.Now()
+	pv, v, st := NewMinimax(cfg).Analyze(p, limit)
+	return NewReport(p, pv, v, st, time.Since(start)), nil
+}
+
+// NewReport builds the Report of an analysis of p that took elapsed
+// and returned pv, v and st, as from Analyze or an Iteration of
+// AnalyzeStream; v is from the perspective of the player to move.
+func NewReport(p *tak.Position, pv []tak.Move, v int64, st Stats, elapsed time.Duration) *Report {
+	r := &Report{
+		TPS:    ptn.FormatTPS(p),
+		Size:   p.Size(),
+		ToMove: p.ToMove().String(),
+		PV:     []string{},
+		Value:  WhiteValue(p, v),
+		Stats: ReportStats{
+			Depth:     st.CompletedDepth,
+			Visited:   st.Visited,
+			Evaluated: st.Evaluated,
+			TTHits:    st.TTHits,
+			Forced:    st.Forced,
+			Seconds:   elapsed.Seconds(),
+		},
+	}
+	if over, winner := p.GameOver(); over {
+		r.Over = true
+		if winner != tak.NoColor {
+			r.Winner = winner.String()
+		}
+		return r
+	}
+	for i := range pv {
+		r.PV = append(r.PV, ptn.FormatMove(&pv[i]))
+	}
+	if len(r.PV) > 0 {
+		r.Move = r.PV[0]
+	}
+	if mate, plies := MateInPlies(p, v); mate {
+		r.Mate = true
+		r.MatePlies = plies
+		if v > 0 {
+			r.Winner = p.ToMove().String()
+		} else {
+			r.Winner = p.ToMove().Flip().String()
+		}
+	}
+	return r
+}
@@ -0,0 +1,51 @@
+package ai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAnalyzeTPS(t *testing.T) {
+	cfg := MinimaxConfig{Depth: 3, Deterministic: true}
+
+	r, err := AnalyzeTPS("x3/1,1,x/2,2,x 1 3", cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size != 3 || r.ToMove != "white" || r.Move != "c2" ||
+		!r.Mate || r.MatePlies != 1 || r.Winner != "white" || r.Value < WinThreshold {
+		t.Errorf("road in one: %+v", r)
+	}
+
+	r, err = AnalyzeTPS("x6/x6/x6/x6/x6/x5,2 2 1", cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size != 6 || r.ToMove != "black" || r.Move == "" || r.Mate ||
+		len(r.PV) == 0 || r.PV[0] != r.Move || r.Stats.Depth != 3 {
+		t.Errorf("opening: %+v", r)
+	}
+	buf, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back map[string]interface{}
+	if err := json.Unmarshal(buf, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back["move"] != r.Move || back["tps"] != r.TPS {
+		t.Errorf("json: %s", buf)
+	}
+
+	r, err = AnalyzeTPS("1,1,1/x3/2,2,x 2 3", cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Over || r.Winner != "white" || r.Move != "" || len(r.PV) != 0 {
+		t.Errorf("game over: %+v", r)
+	}
+
+	if _, err := AnalyzeTPS("x3/x3 1 1", cfg, 0); err == nil {
+		t.Errorf("accepted a malformed TPS")
+	}
+}
@@ -0,0 +1,191 @@
+package ai
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// tableMagic begins a saved transposition table, followed by the
+// format version.
+const (
+	tableMagic   = "TKTT"
+	tableVersion = 1
+)
+
+// tableHeader describes a saved transposition table.
+type tableHeader struct {
+	Magic   [4]byte
+	Version uint32
+	Size    uint32
+	Slots   uint32
+	Entries uint32
+}
+
+// savedEntry is a transposition-table entry as saved, without its
+// move's drops, which follow it.
+type savedEntry struct {
+	Slot   uint32
+	Hash   uint64
+	Depth  int32
+	Value  int64
+	Bound  uint8
+	X, Y   uint8
+	Type   uint8
+	Slides uint8
+}
+
+// ClearTable empties the transposition table. Otherwise, the table
+// is kept from one analysis to the next, so that searches of related
+// positions can reuse earlier work.
+func (m *MinimaxAI) ClearTable() {
+	for i := range m.table {
+		m.table[i] = tableBucket{}
+	}
+	m.ttFilled = 0
+}
+
+// Probe returns what the transposition table holds for p, without
+// searching: the value stored, from the perspective of the player to
+// move, the depth it was searched to, whether the value is an
+// "exact" one or a "lower" or "upper" bound, and the best move found.
+// It returns false if there is no entry for p, or if the entry's move
+// is not legal in p, which means it belongs to a different position
+// with the same hash. Probe does not change the table or the search
+// statistics.
+//
+// The table is reused across searches, so an entry may be left from
+// an earlier search of an unrelated position, and its value may
+// reflect a game history or evaluation since changed; entries are
+// only meaningful relative to the most recent search.
+func (m *MinimaxAI) Probe(p *tak.Position) (value int64, depth int, bound string, move tak.Move, ok bool) {
+	if m.cfg.NoTable || p.Size() != m.cfg.Size {
+		return 0, 0, "", tak.Move{}, false
+	}
+	h := p.Hash()
+	b := &m.table[h%uint64(len(m.table))]
+	for i := range b {
+		te := &b[i]
+		if te.hash != h {
+			continue
+		}
+		if _, e := p.MoveToAllocated(&te.m, m.scratch); e != nil {
+			return 0, 0, "", tak.Move{}, false
+		}
+		return te.value, te.depth, te.bound.String(), te.m, true
+	}
+	return 0, 0, "", tak.Move{}, false
+}
+
+// SaveTable writes the transposition table to w, to be restored by
+// LoadTable into an engine for the same board size, for instance to
+// continue analyzing a correspondence game after a restart.
+func (m *MinimaxAI) SaveTable(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	h := tableHeader{
+		Version: tableVersion,
+		Size:    uint32(m.cfg.Size),
+		Slots:   uint32(2 * len(m.table)),
+	}
+	for b := range m.table {
+		for i := range m.table[b] {
+			if m.table[b][i].hash != 0 {
+				h.Entries++
+			}
+		}
+	}
+	copy(h.Magic[:], tableMagic)
+	if err := binary.Write(bw, binary.BigEndian, &h); err != nil {
+		return err
+	}
+	for b := range m.table {
+		for i := range m.table[b] {
+			te := &m.table[b][i]
+			if te.hash == 0 {
+				continue
+			}
+			e := savedEntry{
+				Slot:   uint32(2*b + i),
+				Hash:   te.hash,
+				Depth:  int32(te.depth),
+				Value:  te.value,
+				Bound:  uint8(te.bound),
+				X:      uint8(te.m.X),
+				Y:      uint8(te.m.Y),
+				Type:   uint8(te.m.Type),
+				Slides: uint8(len(te.m.Slides)),
+			}
+			if err := binary.Write(bw, binary.BigEndian, &e); err != nil {
+				return err
+			}
+			if _, err := bw.Write(te.m.Slides); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// LoadTable replaces the transposition table with one written by
+// SaveTable. It returns an error, leaving the table empty, if r does
+// not hold a table saved for this engine's board size.
+func (m *MinimaxAI) LoadTable(r io.Reader) error {
+	m.ClearTable()
+	err := m.loadTable(bufio.NewReader(r))
+	if err != nil {
+		m.ClearTable()
+	}
+	return err
+}
+
+func (m *MinimaxAI) loadTable(r io.Reader) error {
+	var h tableHeader
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return fmt.Errorf("reading table header: %v", err)
+	}
+	switch {
+	case string(h.Magic[:]) != tableMagic:
+		return errors.New("not a saved transposition table")
+	case h.Version != tableVersion:
+		return fmt.Errorf("unsupported table version %d", h.Version)
+	case int(h.Size) != m.cfg.Size:
+		return fmt.Errorf("table is for size %d, not %d", h.Size, m.cfg.Size)
+	case int(h.Slots) != 2*len(m.table):
+		return fmt.Errorf("table has %d slots, not %d", h.Slots, 2*len(m.table))
+	}
+	for n := uint32(0); n < h.Entries; n++ {
+		var e savedEntry
+		if err := binary.Read(r, binary.BigEndian, &e); err != nil {
+			return fmt.Errorf("reading entry %d: %v", n, err)
+		}
+		if e.Slot >= h.Slots || int(e.X) >= m.cfg.Size || int(e.Y) >= m.cfg.Size ||
+			int(e.Slides) > m.cfg.Size || boundType(e.Bound) > upperBound {
+			return fmt.Errorf("corrupt entry %d", n)
+		}
+		te := &m.table[e.Slot/2][e.Slot%2]
+		*te = tableEntry{
+			hash:  e.Hash,
+			gen:   m.gen,
+			depth: int(e.Depth),
+			value: e.Value,
+			bound: boundType(e.Bound),
+			m: tak.Move{
+				X:    int(e.X),
+				Y:    int(e.Y),
+				Type: tak.MoveType(e.Type),
+			},
+		}
+		if e.Slides > 0 {
+			te.m.Slides = make([]byte, e.Slides)
+			if _, err := io.ReadFull(r, te.m.Slides); err != nil {
+				return fmt.Errorf("reading entry %d: %v", n, err)
+			}
+		}
+		m.ttFilled++
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package ai
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestSaveTable(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	cfg := MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true}
+	first := NewMinimax(cfg)
+	pv, v, cold := first.Analyze(p, 0)
+
+	var buf bytes.Buffer
+	if err := first.SaveTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	second := NewMinimax(cfg)
+	if err := second.LoadTable(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if second.ttFilled != first.ttFilled {
+		t.Errorf("loaded %d entries, saved %d", second.ttFilled, first.ttFilled)
+	}
+	wpv, wv, warm := second.Analyze(p, 0)
+	if wv != v || !wpv[0].Equal(&pv[0]) {
+		t.Errorf("warm search: pv=%s v=%d, cold pv=%s v=%d", formatpv(wpv), wv, formatpv(pv), v)
+	}
+	if warm.Visited+warm.Evaluated >= cold.Visited+cold.Evaluated {
+		t.Errorf("warm search visited %d nodes, cold %d",
+			warm.Visited+warm.Evaluated, cold.Visited+cold.Evaluated)
+	}
+
+	// a fresh engine, since searching updates the history that
+	// orders moves, which ClearTable keeps
+	third := NewMinimax(cfg)
+	if err := third.LoadTable(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	third.ClearTable()
+	if _, _, st := third.Analyze(p, 0); st.TTHits != cold.TTHits {
+		t.Errorf("after ClearTable: %d table hits, cold search %d", st.TTHits, cold.TTHits)
+	}
+
+	other := NewMinimax(MinimaxConfig{Size: 6})
+	if err := other.LoadTable(bytes.NewReader(saved)); err == nil {
+		t.Errorf("loaded a size-5 table into a size-6 engine")
+	}
+	if err := second.LoadTable(bytes.NewReader(saved[:len(saved)/2])); err == nil {
+		t.Errorf("loaded a truncated table")
+	}
+	if second.ttFilled != 0 {
+		t.Errorf("failed load left %d entries", second.ttFilled)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	p := parseTPS(t, midgameTPS)
+	ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 4, Deterministic: true})
+	if _, _, _, _, ok := ai.Probe(p); ok {
+		t.Errorf("empty table: found an entry")
+	}
+	pv, v, st := ai.Analyze(p, 0)
+	value, depth, bound, m, ok := ai.Probe(p)
+	if !ok || value != v || depth != 4 || bound != "exact" || !m.Equal(&pv[0]) {
+		t.Errorf("root: ok=%v value=%d depth=%d bound=%s move=%s, want %d at depth 4, %s",
+			ok, value, depth, bound, ptn.FormatMove(&m), v, ptn.FormatMove(&pv[0]))
+	}
+	child, err := p.Move(&pv[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, depth, _, _, ok := ai.Probe(child); !ok || depth != 3 {
+		t.Errorf("child: ok=%v depth=%d", ok, depth)
+	}
+	if ai.st.TTHits != st.TTHits || ai.st.TTCollisions != st.TTCollisions {
+		t.Errorf("Probe changed the statistics")
+	}
+
+	// an entry whose move is illegal belongs to another position
+	te := ai.ttGet(p.Hash())
+	te.m = tak.Move{X: 0, Y: 0, Type: tak.PlaceFlat}
+	if _, _, _, _, ok := ai.Probe(p); ok {
+		t.Errorf("illegal move: found an entry")
+	}
+}
@@ -4,6 +4,14 @@ type Constants struct {
 	Size       uint
 	L, R, T, B uint64
 	Mask       uint64
+
+	// Center holds the board in Rings concentric rings, by
+	// distance from the center: Center[0] is the central square,
+	// or the central four on an even board, and Center[Rings-1]
+	// the edge of the board. A square's distance is the larger of
+	// its horizontal and vertical distances.
+	Center [4]uint64
+	Rings  int
 }
 
 func Precompute(size uint) Constants {
@@ -16,9 +24,28 @@ func Precompute(size uint) Constants {
 	c.T = ((1 << size) - 1) << (size * (size - 1))
 	c.B = (1 << size) - 1
 	c.Mask = 1<<(size*size) - 1
+	c.Rings = int(size+1) / 2
+	for y := uint(0); y < size; y++ {
+		for x := uint(0); x < size; x++ {
+			d := ringDistance(x, size)
+			if dy := ringDistance(y, size); dy > d {
+				d = dy
+			}
+			c.Center[d] |= 1 << (x + y*size)
+		}
+	}
 	return c
 }
 
+// ringDistance returns how many rings the row or column i of a board
+// of the given size lies outside the central one.
+func ringDistance(i, size uint) int {
+	if 2*i < size {
+		i = size - 1 - i
+	}
+	return int(2*i+1-size) / 2
+}
+
 func Popcount(x uint64) int {
 	// bit population count, see
 	// http://graphics.stanford.edu/~seander/bithacks.html#CountBitsSetParallel
@@ -90,3 +117,57 @@ func Dimensions(c *Constants, bits uint64) (w, h int) {
 	}
 	return w, h
 }
+
+// HasRoad reports whether pieces connects opposite edges of the
+// board, through orthogonally adjacent squares. Diagonal neighbors
+// are not connected.
+func HasRoad(c *Constants, pieces uint64) bool {
+	pieces &= c.Mask
+	if g := Flood(c, pieces, pieces&c.L); g&c.R != 0 {
+		return true
+	}
+	g := Flood(c, pieces, pieces&c.T)
+	return g&c.B != 0
+}
+
+// RoadPath returns the squares of a shortest road through pieces, as
+// a bitboard, or false if there is no road.
+func RoadPath(c *Constants, pieces uint64) (uint64, bool) {
+	pieces &= c.Mask
+	h, hok := roadPath(c, pieces, c.L, c.R)
+	v, vok := roadPath(c, pieces, c.T, c.B)
+	switch {
+	case hok && vok && Popcount(v) < Popcount(h):
+		return v, true
+	case hok:
+		return h, true
+	default:
+		return v, vok
+	}
+}
+
+// roadPath searches breadth-first for a shortest path through pieces
+// from the edge `from` to the edge `to`, and returns its squares.
+func roadPath(c *Constants, pieces, from, to uint64) (uint64, bool) {
+	layers := []uint64{pieces & from}
+	seen := layers[0]
+	for seen&to == 0 {
+		next := Grow(c, pieces, layers[len(layers)-1]) &^ seen
+		if next == 0 {
+			return 0, false
+		}
+		layers = append(layers, next)
+		seen |= next
+	}
+	// walk back from a square on the far edge, taking any
+	// neighbor from each earlier layer
+	last := layers[len(layers)-1] & to
+	bit := last & -last
+	path := bit
+	for i := len(layers) - 2; i >= 0; i-- {
+		prev := Grow(c, c.Mask, bit) & layers[i]
+		bit = prev & -prev
+		path |= bit
+	}
+	return path, true
+}
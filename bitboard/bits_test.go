@@ -39,6 +39,25 @@ func TestPrecompute(t *testing.T) {
 	if c.Mask != ^uint64(0) {
 		t.Error("c.mask(8):", strconv.FormatUint(c.Mask, 2))
 	}
+	if c.Rings != 4 || c.Center[0] != 0x0000001818000000 ||
+		c.Center[3] != 0xff818181818181ff {
+		t.Errorf("c.center(8): rings=%d %x", c.Rings, c.Center)
+	}
+
+	c = Precompute(5)
+	if c.Rings != 3 || c.Center[0] != 1<<12 || c.Center[1] != 0x729c0 {
+		t.Errorf("c.center(5): rings=%d %x", c.Rings, c.Center)
+	}
+	var all uint64
+	for _, ring := range c.Center[:c.Rings] {
+		if all&ring != 0 {
+			t.Errorf("c.center(5): rings overlap: %x", c.Center)
+		}
+		all |= ring
+	}
+	if all != c.Mask || c.Center[2] != c.L|c.R|c.T|c.B {
+		t.Errorf("c.center(5): %x", c.Center)
+	}
 }
 
 func TestFlood(t *testing.T) {
@@ -92,3 +111,63 @@ func TestDimensions(t *testing.T) {
 	}
 
 }
+
+// board builds a bitboard from rows of the board, top row first,
+// with '#' marking a set square.
+func board(rows ...string) uint64 {
+	size := len(rows)
+	var out uint64
+	for i, row := range rows {
+		y := size - 1 - i
+		for x, ch := range row {
+			if ch == '#' {
+				out |= 1 << uint(x+y*size)
+			}
+		}
+	}
+	return out
+}
+
+func TestHasRoad(t *testing.T) {
+	cases := []struct {
+		name   string
+		rows   []string
+		road   bool
+		length int
+	}{
+		{"empty", []string{".....", ".....", ".....", ".....", "....."}, false, 0},
+		{"row", []string{".....", ".....", "#####", ".....", "....."}, true, 5},
+		{"column", []string{"..#..", "..#..", "..#..", "..#..", "..#.."}, true, 5},
+		{"gap", []string{".....", ".....", "##.##", ".....", "....."}, false, 0},
+		{"diagonal", []string{"#....", ".#...", "..#..", "...#.", "....#"}, false, 0},
+		{"diagonal link", []string{".....", "###..", "...##", ".....", "....."}, false, 0},
+		{"staircase", []string{"##...", ".##..", "..##.", "...##", "....#"}, true, 8},
+		{"winding", []string{"#....", "#.###", "#.#.#", "###.#", "....#"}, true, 7},
+		{"crossing", []string{"..#..", "..#..", "#####", "..#..", "..#.."}, true, 5},
+		{"ring", []string{"#####", "#...#", "#...#", "#...#", "#####"}, true, 5},
+		{"3x3", []string{".#.", "##.", "#.."}, true, 4},
+		{"3x3 diagonal", []string{"..#", ".#.", "#.."}, false, 0},
+	}
+	for _, tc := range cases {
+		c := Precompute(uint(len(tc.rows)))
+		pieces := board(tc.rows...)
+		if got := HasRoad(&c, pieces); got != tc.road {
+			t.Errorf("%s: HasRoad=%v", tc.name, got)
+		}
+		path, ok := RoadPath(&c, pieces)
+		if ok != tc.road {
+			t.Errorf("%s: RoadPath ok=%v", tc.name, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if path&^pieces != 0 || !HasRoad(&c, path) {
+			t.Errorf("%s: RoadPath=%s is not a road within %s", tc.name,
+				strconv.FormatUint(path, 2), strconv.FormatUint(pieces, 2))
+		}
+		if n := Popcount(path); n != tc.length {
+			t.Errorf("%s: RoadPath has %d squares, want %d", tc.name, n, tc.length)
+		}
+	}
+}
@@ -91,5 +91,6 @@ func RenderBoard(out io.Writer, p *tak.Position) {
 	}
 	fmt.Fprintf(w, "\n")
 	w.Flush()
-	fmt.Fprintf(out, "stones: W:%d B:%d\n", p.WhiteStones(), p.BlackStones())
+	fmt.Fprintf(out, "stones: W:%d B:%d caps: W:%d B:%d\n",
+		p.WhiteStones(), p.BlackStones(), p.WhiteCaps(), p.BlackCaps())
 }
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// RenderBoardOpts configures RenderBoardWith.
+type RenderBoardOpts struct {
+	// Unicode draws a grid around the squares using box-drawing
+	// characters.
+	Unicode bool
+	// Color colors each piece by its owner using ANSI escapes.
+	Color bool
+	// ShowStacks lists every piece in a stack, from the top
+	// down, instead of only the top piece and the stack's
+	// height.
+	ShowStacks bool
+}
+
+const (
+	ansiWhite = "\x1b[97m"
+	ansiBlack = "\x1b[90m"
+	ansiReset = "\x1b[0m"
+)
+
+// RenderBoardWith renders p to out like RenderBoard, formatted
+// according to opts.
+func RenderBoardWith(out io.Writer, p *tak.Position, opts RenderBoardOpts) {
+	cells := make([][]string, p.Size())
+	plain := make([][]string, p.Size())
+	width := 1
+	for y := 0; y < p.Size(); y++ {
+		cells[y] = make([]string, p.Size())
+		plain[y] = make([]string, p.Size())
+		for x := 0; x < p.Size(); x++ {
+			plain[y][x] = renderSquare(p.At(x, y), opts.ShowStacks, false)
+			cells[y][x] = renderSquare(p.At(x, y), opts.ShowStacks, opts.Color)
+			if len(plain[y][x]) > width {
+				width = len(plain[y][x])
+			}
+		}
+	}
+
+	rule := func(left, mid, right string) {
+		fmt.Fprintf(out, "   %s", left)
+		for x := 0; x < p.Size(); x++ {
+			if x > 0 {
+				fmt.Fprint(out, mid)
+			}
+			fmt.Fprint(out, strings.Repeat("─", width+2))
+		}
+		fmt.Fprintln(out, right)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "[%s to play]\n", p.ToMove())
+	if opts.Unicode {
+		rule("┌", "┬", "┐")
+	}
+	for y := p.Size() - 1; y >= 0; y-- {
+		fmt.Fprintf(out, "%2c ", '1'+y)
+		for x := 0; x < p.Size(); x++ {
+			if opts.Unicode {
+				fmt.Fprint(out, "│")
+			}
+			pad := strings.Repeat(" ", width-len(plain[y][x]))
+			fmt.Fprintf(out, " %s%s ", cells[y][x], pad)
+		}
+		if opts.Unicode {
+			fmt.Fprint(out, "│")
+		}
+		fmt.Fprintln(out)
+		if opts.Unicode && y > 0 {
+			rule("├", "┼", "┤")
+		}
+	}
+	if opts.Unicode {
+		rule("└", "┴", "┘")
+	}
+	fmt.Fprint(out, "   ")
+	for x := 0; x < p.Size(); x++ {
+		if opts.Unicode {
+			fmt.Fprint(out, " ")
+		}
+		fmt.Fprintf(out, " %c%s ", 'a'+x, strings.Repeat(" ", width-1))
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "stones: W:%d B:%d caps: W:%d B:%d\n",
+		p.WhiteStones(), p.BlackStones(), p.WhiteCaps(), p.BlackCaps())
+}
+
+// renderSquare formats sq for RenderBoardWith.
+func renderSquare(sq tak.Square, stacks, color bool) string {
+	if len(sq) == 0 {
+		return "."
+	}
+	piece := func(p tak.Piece) string {
+		if !color {
+			return p.String()
+		}
+		if p.Color() == tak.White {
+			return ansiWhite + p.String() + ansiReset
+		}
+		return ansiBlack + p.String() + ansiReset
+	}
+	if !stacks {
+		if len(sq) == 1 {
+			return piece(sq[0])
+		}
+		return fmt.Sprintf("%s%d", piece(sq[0]), len(sq))
+	}
+	bits := make([]string, len(sq))
+	for i, p := range sq {
+		bits[i] = piece(p)
+	}
+	return strings.Join(bits, ",")
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+)
+
+func TestRenderBoardWith(t *testing.T) {
+	p, err := ptn.ParseTPS("2,x2,121C,1/x2,2,12,1/x2,2,12S,2/x3,1,1/x4,1 1 2")
+	if err != nil {
+		panic(err)
+	}
+	cases := []struct {
+		opts RenderBoardOpts
+		want []string
+	}{
+		{RenderBoardOpts{}, []string{" 5  B    .    .    WC3  W", " 3  .    .    B    BS2  B"}},
+		{RenderBoardOpts{ShowStacks: true}, []string{"WC,B,W", "BS,W "}},
+		{RenderBoardOpts{Unicode: true}, []string{"┌─────┬", " 5 │ B   │ .   │"}},
+		{RenderBoardOpts{Color: true}, []string{ansiWhite + "WC" + ansiReset + "3  "}},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		RenderBoardWith(&buf, p, tc.opts)
+		for _, w := range tc.want {
+			if !strings.Contains(buf.String(), w) {
+				t.Errorf("%+v: missing %q in:\n%s", tc.opts, w, buf.String())
+			}
+		}
+	}
+}
@@ -16,9 +16,13 @@ import (
 
 var (
 	all     = flag.Bool("all", false, "show all possible moves")
+	stable  = flag.Bool("stable", false, "list -all moves in a stable order")
 	tps     = flag.Bool("tps", false, "render position in tps")
 	quiet   = flag.Bool("quiet", false, "don't print board diagrams")
 	explain = flag.Bool("explain", false, "explain scoring")
+	noTempo = flag.Bool("no-tempo", false, "leave the tempo bonus out of -explain values")
+	ranked  = flag.Bool("ranked", false, "with -explain, rank the evaluation terms by their contribution")
+	dumpTT  = flag.Bool("dump-tt", false, "dump the line stored in the transposition table")
 
 	move  = flag.Int("move", 0, "PTN move number to analyze")
 	final = flag.Bool("final", false, "analyze final position only")
@@ -125,7 +129,7 @@ func analyzeWith(player *ai.MinimaxAI, p *tak.Position) {
 	if !*quiet {
 		cli.RenderBoard(os.Stdout, p)
 		if *explain {
-			ai.ExplainScore(player, os.Stdout, p)
+			ai.ExplainScoreWith(player, os.Stdout, p, ai.StaticOptions{NoTempo: *noTempo, Ranked: *ranked})
 		}
 	}
 	fmt.Printf("AI analysis:\n")
@@ -135,16 +139,27 @@ func analyzeWith(player *ai.MinimaxAI, p *tak.Position) {
 	}
 	fmt.Printf("\n")
 	fmt.Printf(" value=%d\n", val)
+	if mate, plies := ai.MateInPlies(p, val); mate {
+		fmt.Printf(" mate in %d plies\n", plies)
+	}
 	if *tps {
 		fmt.Printf("[TPS \"%s\"]\n", ptn.FormatTPS(p))
 	}
 	if *all {
 		fmt.Printf(" all moves:")
-		for _, m := range p.AllMoves(nil) {
+		ms := p.AllMoves(nil)
+		if *stable {
+			tak.SortMoves(ms)
+		}
+		for _, m := range ms {
 			fmt.Printf(" %s", ptn.FormatMove(&m))
 		}
 		fmt.Printf("\n")
 	}
+	if *dumpTT {
+		fmt.Printf(" table:\n")
+		player.DumpTT(p, os.Stdout)
+	}
 	fmt.Println()
 
 	for _, m := range pv {
@@ -163,7 +178,7 @@ func analyzeWith(player *ai.MinimaxAI, p *tak.Position) {
 		fmt.Println("Resulting position:")
 		cli.RenderBoard(os.Stdout, p)
 		if *explain {
-			ai.ExplainScore(player, os.Stdout, p)
+			ai.ExplainScoreWith(player, os.Stdout, p, ai.StaticOptions{NoTempo: *noTempo, Ranked: *ranked})
 		}
 		fmt.Println()
 		fmt.Println()
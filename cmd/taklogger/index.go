@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -124,25 +122,14 @@ func countMoves(g *ptn.PTN) int {
 	return i
 }
 
+// readPTNs reads the games under d, logging any it can't parse.
 func readPTNs(d string) ([]*ptn.PTN, error) {
-	var out []*ptn.PTN
-	e := filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
-		if !strings.HasSuffix(path, ".ptn") {
-			return nil
+	ptns, e := ptn.ReadDir(d)
+	if de, ok := e.(ptn.DirError); ok {
+		for _, fe := range de {
+			log.Print(fe)
 		}
-		f, e := os.Open(path)
-		if e != nil {
-			log.Printf("open(%s): %v", path, e)
-			return nil
-		}
-		defer f.Close()
-		g, e := ptn.ParsePTN(f)
-		if e != nil {
-			log.Printf("parse(%s): %v", path, e)
-			return nil
-		}
-		out = append(out, g)
-		return nil
-	})
-	return out, e
+		e = nil
+	}
+	return ptns, e
 }
@@ -30,6 +30,11 @@ var (
 	depth = flag.Int("depth", 3, "depth to search")
 	limit = flag.Duration("limit", 0, "search duration")
 
+	resign      = flag.Int64("resign", 0, "resign when an engine's evaluation is below -resign (0: never)")
+	resignPlies = flag.Int("resign-plies", 3, "resign after this many consecutive lost evaluations")
+	draw        = flag.Int64("draw", 0, "agree a draw while both evaluations are within +/-draw (0: never)")
+	drawPlies   = flag.Int("draw-plies", 20, "agree a draw after this many consecutive drawish plies")
+
 	verbose = flag.Bool("verbose", false, "log results per game")
 
 	threads = flag.Int("threads", 4, "number of parallel threads")
@@ -37,16 +42,68 @@ var (
 	out = flag.String("out", "", "directory to write ptns to")
 )
 
+// engine is a player in a game, with the thresholds at which it
+// gives up or agrees to a draw.
+type engine struct {
+	ai *ai.MinimaxAI
+	// resign is how far below zero the engine's evaluation must
+	// stay, for resignPlies of its moves in a row, before it
+	// resigns. Zero disables resignation.
+	resign int64
+	// draw is how close to zero the engine's evaluation must be
+	// for it to accept a draw. Zero disables draws.
+	draw int64
+}
+
 type gameSpec struct {
 	i            int
-	white, black ai.TakPlayer
+	white, black engine
 	p1color      tak.Color
 }
 
+// endReason records how a game finished.
+type endReason int
+
+const (
+	// endOver means the game ended on the board; WinDetails has
+	// the details.
+	endOver endReason = iota
+	// endCutoff means the game reached -cutoff plies.
+	endCutoff
+	// endResigned means the loser's evaluation stayed below its
+	// resign threshold.
+	endResigned
+	// endDrawAgreed means both engines' evaluations stayed within
+	// their draw thresholds for -draw-plies plies.
+	endDrawAgreed
+	// endError means the engine to move returned no move, or an
+	// illegal one; gameResult.err has the details.
+	endError
+)
+
+func (r endReason) String() string {
+	switch r {
+	case endOver:
+		return "over"
+	case endCutoff:
+		return "cutoff"
+	case endResigned:
+		return "resigned"
+	case endDrawAgreed:
+		return "draw"
+	case endError:
+		return "error"
+	}
+	return fmt.Sprintf("endReason(%d)", int(r))
+}
+
 type gameResult struct {
-	spec gameSpec
-	p    *tak.Position
-	ms   []tak.Move
+	spec   gameSpec
+	p      *tak.Position
+	ms     []tak.Move
+	winner tak.Color
+	reason endReason
+	err    error
 }
 
 func main() {
@@ -72,11 +129,12 @@ func main() {
 	}
 
 	var stats [2]struct {
-		wins     int
-		flatWins int
-		roadWins int
+		wins       int
+		flatWins   int
+		roadWins   int
+		resignWins int
 	}
-	var ties int
+	var ties, draws, errors int
 
 	rc := make(chan gameResult)
 
@@ -84,30 +142,37 @@ func main() {
 	for r := range rc {
 		d := r.p.WinDetails()
 		if *verbose {
-			log.Printf("game n=%d plies=%d p1=%s winner=%s wf=%d bf=%d ws=%d bs=%d",
+			log.Printf("game n=%d plies=%d p1=%s winner=%s reason=%s wf=%d bf=%d ws=%d bs=%d",
 				r.spec.i, r.p.MoveNumber(),
-				r.spec.p1color, d.Winner,
+				r.spec.p1color, r.winner, r.reason,
 				d.WhiteFlats,
 				d.BlackFlats,
 				r.p.WhiteStones(),
 				r.p.BlackStones(),
 			)
 		}
-		if d.Over {
+		if r.reason == endError {
+			log.Printf("game n=%d plies=%d: %v", r.spec.i, r.p.MoveNumber(), r.err)
+			errors++
+		} else if r.winner != tak.NoColor {
 			st := &stats[0]
-			if d.Winner == r.spec.p1color.Flip() {
+			if r.winner == r.spec.p1color.Flip() {
 				st = &stats[1]
 			}
 			st.wins++
-			switch d.Reason {
-			case tak.FlatsWin:
+			switch {
+			case r.reason == endResigned:
+				st.resignWins++
+			case d.Reason == tak.FlatsWin:
 				st.flatWins++
-			case tak.RoadWin:
+			case d.Reason == tak.RoadWin:
 				st.roadWins++
 			}
-		}
-		if d.Winner == tak.NoColor {
+		} else {
 			ties++
+			if r.reason == endDrawAgreed {
+				draws++
+			}
 		}
 		if *out != "" {
 			writeGame(*out, &r)
@@ -118,10 +183,10 @@ func main() {
 	log.Printf("p1=%s", j)
 	j, _ = json.Marshal(&weights2)
 	log.Printf("p2=%s", j)
-	log.Printf("done games=%d seed=%d ties=%d p1.wins=%d (%d road/%d flat) p2.wins=%d (%d road/%d flat)",
-		*games, *seed, ties,
-		stats[0].wins, stats[0].roadWins, stats[0].flatWins,
-		stats[1].wins, stats[1].roadWins, stats[1].flatWins)
+	log.Printf("done games=%d seed=%d errors=%d ties=%d (%d agreed) p1.wins=%d (%d road/%d flat/%d resign) p2.wins=%d (%d road/%d flat/%d resign)",
+		*games, *seed, errors, ties, draws,
+		stats[0].wins, stats[0].roadWins, stats[0].flatWins, stats[0].resignWins,
+		stats[1].wins, stats[1].roadWins, stats[1].flatWins, stats[1].resignWins)
 	a, b := int64(stats[0].wins), int64(stats[1].wins)
 	if a < b {
 		a, b = b, a
@@ -133,8 +198,9 @@ func writeGame(d string, r *gameResult) {
 	os.MkdirAll(d, 0755)
 	p := &ptn.PTN{}
 	p.Tags = []ptn.Tag{
-		{"Size", fmt.Sprintf("%d", r.p.Size())},
-		{"Player1", r.spec.p1color.String()},
+		{Name: "Size", Value: fmt.Sprintf("%d", r.p.Size())},
+		{Name: "Player1", Value: r.spec.p1color.String()},
+		{Name: "Result", Value: gameResultString(r)},
 	}
 	for i, m := range r.ms {
 		if i%2 == 0 {
@@ -148,27 +214,93 @@ func writeGame(d string, r *gameResult) {
 
 func worker(games <-chan gameSpec, out chan<- gameResult) {
 	for g := range games {
-		var ms []tak.Move
-		p := tak.New(tak.Config{Size: *size})
-		for i := 0; i < *cutoff; i++ {
-			var m tak.Move
-			if p.ToMove() == tak.White {
-				m = g.white.GetMove(p, *limit)
-			} else {
-				m = g.black.GetMove(p, *limit)
-			}
-			p, _ = p.Move(&m)
-			ms = append(ms, m)
-			if ok, _ := p.GameOver(); ok {
-				break
-			}
+		out <- playGame(g)
+	}
+}
+
+// playGame plays out g, ending it early if the engine to move
+// resigns or both engines agree to a draw.
+func playGame(g gameSpec) gameResult {
+	r := gameResult{
+		spec:   g,
+		p:      tak.New(tak.Config{Size: *size}),
+		reason: endCutoff,
+	}
+	var lost [2]int
+	level := 0
+	for i := 0; i < *cutoff; i++ {
+		e, c := &g.white, 0
+		if r.p.ToMove() == tak.Black {
+			e, c = &g.black, 1
 		}
-		out <- gameResult{
-			spec: g,
-			p:    p,
-			ms:   ms,
+		pv, v, _ := e.ai.Analyze(r.p, *limit)
+
+		if e.resign != 0 && v < -e.resign {
+			lost[c]++
+		} else {
+			lost[c] = 0
+		}
+		if lost[c] >= *resignPlies {
+			r.winner = r.p.ToMove().Flip()
+			r.reason = endResigned
+			return r
+		}
+		if e.draw != 0 && v <= e.draw && v >= -e.draw {
+			level++
+		} else {
+			level = 0
 		}
+		if level >= *drawPlies {
+			r.reason = endDrawAgreed
+			return r
+		}
+
+		if len(pv) == 0 {
+			r.reason = endError
+			r.err = fmt.Errorf("%s returned no move", r.p.ToMove())
+			return r
+		}
+		next, err := r.p.Move(&pv[0])
+		if err != nil {
+			r.reason = endError
+			r.err = fmt.Errorf("%s played illegal move %s: %v",
+				r.p.ToMove(), ptn.FormatMove(&pv[0]), err)
+			return r
+		}
+		r.p = next
+		r.ms = append(r.ms, pv[0])
+		if ok, winner := r.p.GameOver(); ok {
+			r.winner = winner
+			r.reason = endOver
+			return r
+		}
+	}
+	return r
+}
+
+// gameResultString returns the PTN result of a game, or "" if it
+// was cut off or ended in an error.
+func gameResultString(r *gameResult) string {
+	switch {
+	case r.reason == endCutoff, r.reason == endError:
+		return ""
+	case r.winner == tak.NoColor:
+		return "1/2-1/2"
+	case r.reason == endResigned:
+		if r.winner == tak.White {
+			return "1-0"
+		}
+		return "0-1"
+	}
+	d := r.p.WinDetails()
+	code := "F"
+	if d.Reason == tak.RoadWin {
+		code = "R"
+	}
+	if r.winner == tak.White {
+		return code + "-0"
 	}
+	return "0-" + code
 }
 
 func perturbWeights(p float64, w ai.Weights) ai.Weights {
@@ -203,7 +335,7 @@ func runGames(w1, w2 ai.Weights, seed int64, rc chan<- gameResult) {
 	}
 	r := rand.New(rand.NewSource(seed))
 	for g := 0; g < *games; g++ {
-		var white, black ai.TakPlayer
+		var white, black engine
 		w1 := w1
 		w2 := w2
 		if *perturb != 0.0 {
@@ -223,12 +355,14 @@ func runGames(w1, w2 ai.Weights, seed int64, rc chan<- gameResult) {
 			Size:     *size,
 		})
 		seed++
+		e1 := engine{ai: p1, resign: *resign, draw: *draw}
+		e2 := engine{ai: p2, resign: *resign, draw: *draw}
 		var p1color tak.Color
 		if g%2 == 0 {
-			white, black = p1, p2
+			white, black = e1, e2
 			p1color = tak.White
 		} else {
-			black, white = p1, p2
+			black, white = e1, e2
 			p1color = tak.Black
 		}
 
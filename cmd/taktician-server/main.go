@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/service"
+)
+
+var (
+	listen   = flag.String("listen", "localhost:8080", "address to serve analyses on")
+	depth    = flag.Int("depth", service.DefaultDepth, "default minimax depth")
+	limit    = flag.Duration("limit", time.Minute, "maximum time per analysis")
+	maxDepth = flag.Int("max-depth", service.DefaultMaxDepth, "maximum depth a request may ask for")
+	searches = flag.Int("searches", service.DefaultMaxSearches, "analyses to run at once")
+	idle     = flag.Int("idle", service.DefaultMaxIdle, "idle engines to keep for reuse")
+	debug    = flag.Int("debug", 0, "debug level")
+)
+
+func main() {
+	flag.Parse()
+	s := &service.Server{
+		Config:      ai.MinimaxConfig{Depth: *depth, Debug: *debug},
+		MaxLimit:    *limit,
+		MaxDepth:    *maxDepth,
+		MaxSearches: *searches,
+		MaxIdle:     *idle,
+	}
+	log.Printf("serving analyses on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, s))
+}
@@ -0,0 +1,314 @@
+// Package bot plays games on a playtak.com server with a MinimaxAI.
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/playtak"
+	"github.com/nelhage/taktician/tak"
+)
+
+// Config configures a bot.
+type Config struct {
+	// Server is the host:port of the playtak server.
+	Server string
+	// User and Pass log in; an empty User logs in as a guest.
+	User, Pass string
+	// ClientName is sent to the server to identify the bot.
+	ClientName string
+
+	// Size and Time are the size and clock of the games to seek.
+	Size int
+	Time time.Duration
+	// Accept, if set, makes the bot wait for and accept a seek
+	// from the named user instead of seeking a game itself.
+	Accept string
+	// TakBot, if set, asks TakBot to play at this level after
+	// seeking.
+	TakBot string
+	// Once plays a single game and returns.
+	Once bool
+
+	// Engine configures the MinimaxAI for each game; its Size is
+	// set from the game.
+	Engine ai.MinimaxConfig
+	// MaxPerMove, if nonzero, caps the time spent on any one
+	// move.
+	MaxPerMove time.Duration
+
+	// Debug logs the protocol traffic.
+	Debug bool
+}
+
+// Game describes a game announced by the server's "Game Start"
+// message.
+type Game struct {
+	ID    string
+	Size  int
+	White string
+	Black string
+	// Color is the color the bot plays.
+	Color tak.Color
+	// Time is each player's time, if the server gave it.
+	Time time.Duration
+}
+
+// ParseGameStart parses a message of the form
+//
+//	Game Start ID SIZE WHITE vs BLACK COLOR [TIME ...]
+func ParseGameStart(line string) (*Game, error) {
+	bits := strings.Split(line, " ")
+	if len(bits) < 8 || bits[0] != "Game" || bits[1] != "Start" || bits[5] != "vs" {
+		return nil, fmt.Errorf("bad game start: %q", line)
+	}
+	g := &Game{ID: bits[2], White: bits[4], Black: bits[6]}
+	var err error
+	if g.Size, err = strconv.Atoi(bits[3]); err != nil {
+		return nil, fmt.Errorf("bad size: %q", bits[3])
+	}
+	switch bits[7] {
+	case "white":
+		g.Color = tak.White
+	case "black":
+		g.Color = tak.Black
+	default:
+		return nil, fmt.Errorf("bad color: %q", bits[7])
+	}
+	if len(bits) > 8 {
+		secs, err := strconv.Atoi(bits[8])
+		if err != nil {
+			return nil, fmt.Errorf("bad time: %q", bits[8])
+		}
+		g.Time = time.Duration(secs) * time.Second
+	}
+	return g, nil
+}
+
+// timeWait is how long to wait after the opponent's move for the
+// server's clock update before moving without it.
+const timeWait = 500 * time.Millisecond
+
+// PlayGame plays g on c until it ends, searching with m, and
+// returns the final position. The time on the clock is tracked from
+// the server's updates, starting from g.Time, or from clock if the
+// server didn't give one. The positions played so far are passed to
+// m with SetHistory, so that it avoids repeating them.
+func PlayGame(c *playtak.Client, g *Game, m *ai.MinimaxAI, clock, maxPerMove time.Duration) (*tak.Position, error) {
+	p := tak.New(tak.Config{Size: g.Size})
+	var history []*tak.Position
+	gameStr := fmt.Sprintf("Game#%s", g.ID)
+	timeLeft := clock
+	if g.Time != 0 {
+		timeLeft = g.Time
+	}
+	for {
+		over, _ := p.GameOver()
+		if g.Color == p.ToMove() && !over {
+			m.SetHistory(history)
+			pv, _, _ := m.AnalyzeClock(p, ai.TimeControl{
+				Remaining:  timeLeft,
+				MaxPerMove: maxPerMove,
+			})
+			if len(pv) == 0 {
+				return p, errors.New("ai returned no move")
+			}
+			next, err := p.Move(&pv[0])
+			if err != nil {
+				return p, fmt.Errorf("ai returned bad move: %s: %v",
+					playtak.FormatServer(&pv[0]), err)
+			}
+			history = append(history, p)
+			p = next
+			c.SendCommand(gameStr, playtak.FormatServer(&pv[0]))
+			continue
+		}
+
+		var timeout <-chan time.Time
+	theirMove:
+		for {
+			var line string
+			var ok bool
+			select {
+			case line, ok = <-c.Recv:
+				if !ok {
+					return p, recvError(c)
+				}
+			case <-timeout:
+				break theirMove
+			}
+			if !strings.HasPrefix(line, gameStr+" ") {
+				continue
+			}
+			bits := strings.Split(line, " ")
+			switch bits[1] {
+			case "P", "M":
+				move, err := playtak.ParseServer(strings.Join(bits[1:], " "))
+				if err != nil {
+					return p, err
+				}
+				next, err := p.Move(&move)
+				if err != nil {
+					return p, fmt.Errorf("bad move from server: %s: %v", line, err)
+				}
+				history = append(history, p)
+				p = next
+				timeout = time.After(timeWait)
+			case "Abandoned.", "Over":
+				return p, nil
+			case "Time":
+				if len(bits) < 4 {
+					continue
+				}
+				secs := bits[2]
+				if g.Color == tak.Black {
+					secs = bits[3]
+				}
+				if n, err := strconv.Atoi(secs); err == nil {
+					timeLeft = time.Duration(n) * time.Second
+				}
+				if timeout != nil {
+					break theirMove
+				}
+			}
+		}
+	}
+}
+
+// Run connects to the server, seeks or accepts games and plays
+// them, reconnecting with exponential backoff when the connection
+// is lost. It returns once ctx is cancelled, after one game if
+// cfg.Once is set, or if logging in fails.
+func Run(ctx context.Context, cfg *Config) error {
+	backoff := time.Second
+	for {
+		err := session(ctx, cfg)
+		if err == nil || ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, ok := err.(loginError); ok {
+			return err
+		}
+		log.Printf("disconnected: %v", err)
+		log.Printf("sleeping %s before reconnect...", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+type loginError struct{ error }
+
+// session plays games over a single connection. It returns nil once
+// the bot is done playing.
+func session(ctx context.Context, cfg *Config) error {
+	c := &playtak.Client{Debug: cfg.Debug}
+	if err := c.Connect(cfg.Server); err != nil {
+		return err
+	}
+	defer c.Shutdown()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Shutdown()
+		case <-done:
+		}
+	}()
+
+	c.SendClient(cfg.ClientName)
+	var err error
+	if cfg.User != "" {
+		err = c.Login(cfg.User, cfg.Pass)
+	} else {
+		err = c.LoginGuest()
+	}
+	if err != nil {
+		if c.Error() != nil {
+			return c.Error()
+		}
+		return loginError{fmt.Errorf("login: %v", err)}
+	}
+
+	for {
+		if cfg.Accept != "" {
+			if err := accept(c, cfg.Accept); err != nil {
+				return err
+			}
+		} else {
+			c.SendCommand("Seek", strconv.Itoa(cfg.Size), strconv.Itoa(int(cfg.Time.Seconds())))
+			if cfg.TakBot != "" {
+				c.SendCommand("Shout", "takbot: play", cfg.TakBot)
+			}
+		}
+		g, err := awaitGame(c)
+		if err != nil {
+			return err
+		}
+		log.Printf("new game %s: %s vs %s", g.ID, g.White, g.Black)
+		ecfg := cfg.Engine
+		ecfg.Size = g.Size
+		var p *tak.Position
+		engine, err := ai.NewMinimaxChecked(ecfg)
+		if err == nil {
+			p, err = PlayGame(c, g, engine, cfg.Time, cfg.MaxPerMove)
+		}
+		if err != nil {
+			if err == io.ErrUnexpectedEOF || c.Error() != nil {
+				return err
+			}
+			log.Printf("game %s: %v; resigning", g.ID, err)
+			c.SendCommand(fmt.Sprintf("Game#%s", g.ID), "Resign")
+		} else {
+			_, winner := p.GameOver()
+			log.Printf("game %s over: winner=%s", g.ID, winner)
+		}
+		if cfg.Once {
+			return nil
+		}
+	}
+}
+
+// accept waits for a seek from user and accepts it.
+func accept(c *playtak.Client, user string) error {
+	for line := range c.Recv {
+		bits := strings.Split(line, " ")
+		if len(bits) > 3 && bits[0] == "Seek" && bits[1] == "new" && bits[3] == user {
+			log.Printf("accepting game %s from %s", bits[2], bits[3])
+			c.SendCommand("Accept", bits[2])
+			return nil
+		}
+	}
+	return recvError(c)
+}
+
+// awaitGame waits for the server to start a game.
+func awaitGame(c *playtak.Client) (*Game, error) {
+	for line := range c.Recv {
+		if strings.HasPrefix(line, "Game Start") {
+			return ParseGameStart(line)
+		}
+	}
+	return nil, recvError(c)
+}
+
+func recvError(c *playtak.Client) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
@@ -0,0 +1,201 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/playtak"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestParseGameStart(t *testing.T) {
+	g, err := ParseGameStart("Game Start 12 5 alice vs bob black 600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Game{ID: "12", Size: 5, White: "alice", Black: "bob", Color: tak.Black, Time: 10 * time.Minute}
+	if *g != want {
+		t.Errorf("got %+v want %+v", *g, want)
+	}
+	g, err = ParseGameStart("Game Start 3 6 alice vs bob white")
+	if err != nil || g.Color != tak.White || g.Time != 0 {
+		t.Errorf("no time: %+v, %v", g, err)
+	}
+	for _, bad := range []string{
+		"Game Start 3",
+		"Game Start 3 x alice vs bob white",
+		"Game Start 3 5 alice vs bob green",
+		"Game Start 3 5 alice vs bob white soon",
+	} {
+		if _, err := ParseGameStart(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+// fakeServer speaks enough of the playtak protocol to play one game
+// per connection, answering each of the bot's moves with the first
+// legal move. If drop is set, it hangs up on the first connection
+// right after login.
+type fakeServer struct {
+	t     *testing.T
+	l     net.Listener
+	color string
+	drop  bool
+
+	result chan *tak.Position
+}
+
+func newFakeServer(t *testing.T, color string, drop bool) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{t: t, l: l, color: color, drop: drop, result: make(chan *tak.Position, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		drop := s.drop
+		s.drop = false
+		s.session(conn, drop)
+	}
+}
+
+func (s *fakeServer) session(conn net.Conn, drop bool) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send := func(line string) { fmt.Fprintf(conn, "%s\n", line) }
+	recv := func() (string, bool) {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return "", false
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line != "PING" && !strings.HasPrefix(line, "Client ") {
+				return line, true
+			}
+		}
+	}
+
+	send("Login or Register")
+	if line, ok := recv(); !ok || line != "Login Guest" {
+		s.t.Errorf("login: %q", line)
+		return
+	}
+	send("Welcome Guest!")
+	if drop {
+		return
+	}
+	if line, ok := recv(); !ok || line != "Seek 3 60" {
+		s.t.Errorf("seek: %q", line)
+		return
+	}
+	send("Game Start 7 3 Guest vs opp " + s.color + " 60")
+
+	p := tak.New(tak.Config{Size: 3})
+	botColor := tak.White
+	if s.color == "black" {
+		botColor = tak.Black
+	}
+	for ply := 0; ply < 200; ply++ {
+		if over, _ := p.GameOver(); over {
+			send("Game#7 Over R-0")
+			s.result <- p
+			return
+		}
+		if p.ToMove() == botColor {
+			line, ok := recv()
+			if !ok || !strings.HasPrefix(line, "Game#7 ") {
+				s.t.Errorf("ply %d: want a move, got %q", ply, line)
+				return
+			}
+			m, err := playtak.ParseServer(strings.TrimPrefix(line, "Game#7 "))
+			if err != nil {
+				s.t.Errorf("ply %d: %q: %v", ply, line, err)
+				return
+			}
+			if p, err = p.Move(&m); err != nil {
+				s.t.Errorf("ply %d: illegal move %q: %v", ply, line, err)
+				return
+			}
+		} else {
+			m := p.AllMoves(nil)[0]
+			var err error
+			if p, err = p.Move(&m); err != nil {
+				s.t.Errorf("ply %d: %v", ply, err)
+				return
+			}
+			send("Game#7 " + playtak.FormatServer(&m))
+		}
+		send("Game#7 Time 60 60")
+	}
+	s.t.Errorf("game did not end")
+}
+
+func testConfig(addr string) *Config {
+	return &Config{
+		Server:     addr,
+		ClientName: "test",
+		Size:       3,
+		Time:       time.Minute,
+		Once:       true,
+		Engine:     ai.MinimaxConfig{Depth: 2, Seed: 1},
+		MaxPerMove: 100 * time.Millisecond,
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, color := range []string{"white", "black"} {
+		s := newFakeServer(t, color, false)
+		err := Run(context.Background(), testConfig(s.l.Addr().String()))
+		s.l.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", color, err)
+		}
+		select {
+		case p := <-s.result:
+			if _, winner := p.GameOver(); winner.String() != color {
+				t.Errorf("%s: winner=%s", color, winner)
+			}
+		default:
+			t.Errorf("%s: game did not finish", color)
+		}
+	}
+}
+
+func TestRunReconnect(t *testing.T) {
+	s := newFakeServer(t, "white", true)
+	defer s.l.Close()
+	if err := Run(context.Background(), testConfig(s.l.Addr().String())); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.result:
+	default:
+		t.Error("game did not finish after reconnecting")
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	s := newFakeServer(t, "white", true)
+	defer s.l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := Run(ctx, testConfig(s.l.Addr().String())); err != context.DeadlineExceeded {
+		t.Errorf("err=%v, want DeadlineExceeded", err)
+	}
+}
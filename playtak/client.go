@@ -21,6 +21,7 @@ type Client struct {
 	Recv     chan string
 	send     chan string
 	shutdown chan struct{}
+	once     sync.Once
 	wg       sync.WaitGroup
 }
 
@@ -46,11 +47,15 @@ func (c *Client) Connect(host string) error {
 func (c *Client) recvThread() {
 	r := bufio.NewReader(c.conn)
 	defer c.wg.Done()
+	defer close(c.Recv)
 	for {
 		line, err := r.ReadString('\n')
 		if err != nil {
-			close(c.Recv)
-			c.err = err
+			select {
+			case <-c.shutdown:
+			default:
+				c.err = err
+			}
 			c.conn.Close()
 			return
 		}
@@ -73,7 +78,7 @@ func (c *Client) ping() {
 	for {
 		select {
 		case <-ticker.C:
-			c.send <- "PING"
+			c.SendCommand("PING")
 		case <-c.shutdown:
 			return
 		}
@@ -96,8 +101,13 @@ func (c *Client) sendThread() {
 	}
 }
 
+// SendCommand sends a command to the server. After Shutdown, it
+// does nothing.
 func (c *Client) SendCommand(words ...string) {
-	c.send <- strings.Join(words, " ")
+	select {
+	case c.send <- strings.Join(words, " "):
+	case <-c.shutdown:
+	}
 }
 
 func (c *Client) SendClient(name string) {
@@ -130,8 +140,13 @@ func (c *Client) LoginGuest() error {
 	return c.Login("Guest", "")
 }
 
+// Shutdown closes the connection and closes Recv. It is safe to
+// call more than once, and concurrently with the Client's other
+// methods.
 func (c *Client) Shutdown() {
-	close(c.shutdown)
-	c.wg.Wait()
-	c.conn.Close()
+	c.once.Do(func() {
+		close(c.shutdown)
+		c.conn.Close()
+		c.wg.Wait()
+	})
 }
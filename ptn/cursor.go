@@ -0,0 +1,88 @@
+package ptn
+
+import (
+	"github.com/nelhage/taktician/tak"
+)
+
+// GameCursor steps through the positions of a game, forward and
+// backward, for a game viewer. Positions are computed once, when the
+// cursor is created, so moving the cursor is cheap.
+type GameCursor struct {
+	moves     []*Move
+	positions []*tak.Position
+	ply       int
+}
+
+// NewGameCursor returns a cursor at the initial position of g,
+// following the line selected by path as PositionInVariation does; a
+// nil path follows the main line. It returns an error if any move in
+// the line is illegal.
+func NewGameCursor(g *PTN, path []int) (*GameCursor, error) {
+	ops, e := g.Line(path)
+	if e != nil {
+		return nil, e
+	}
+	p, e := g.InitialPosition()
+	if e != nil {
+		return nil, e
+	}
+	c := &GameCursor{positions: []*tak.Position{p}}
+	_, e = replay(p, ops, func(_ int, m *Move, p *tak.Position) bool {
+		if m != nil {
+			c.moves = append(c.moves, m)
+			c.positions = append(c.positions, p)
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	return c, nil
+}
+
+// Position returns the position at the cursor.
+func (c *GameCursor) Position() *tak.Position {
+	return c.positions[c.ply]
+}
+
+// Ply returns the number of moves played to reach the cursor.
+func (c *GameCursor) Ply() int {
+	return c.ply
+}
+
+// Len returns the number of moves in the line.
+func (c *GameCursor) Len() int {
+	return len(c.moves)
+}
+
+// LastMove returns the move that led to the position at the cursor,
+// or nil at the start of the line.
+func (c *GameCursor) LastMove() *Move {
+	if c.ply == 0 {
+		return nil
+	}
+	return c.moves[c.ply-1]
+}
+
+// Next advances the cursor by one move, returning false if it is
+// already at the end of the line.
+func (c *GameCursor) Next() bool {
+	return c.Seek(c.ply + 1)
+}
+
+// Prev moves the cursor back by one move, returning false if it is
+// already at the start of the line.
+func (c *GameCursor) Prev() bool {
+	return c.Seek(c.ply - 1)
+}
+
+// Seek moves the cursor to the position after `ply` moves, returning
+// false, and leaving the cursor where it was, if the line has no such
+// position.
+func (c *GameCursor) Seek(ply int) bool {
+	if ply < 0 || ply > len(c.moves) {
+		return false
+	}
+	c.ply = ply
+	return true
+}
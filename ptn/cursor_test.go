@@ -0,0 +1,68 @@
+package ptn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGameCursor(t *testing.T) {
+	g, err := ParsePTN(strings.NewReader(
+		"[Size \"5\"]\n\n1. a1 e5 2. c3 (2. b2 c3) d4 3. e5-\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewGameCursor(g, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 5 || c.Ply() != 0 || c.LastMove() != nil {
+		t.Fatalf("new cursor: len=%d ply=%d", c.Len(), c.Ply())
+	}
+	if c.Prev() {
+		t.Errorf("Prev() at the start")
+	}
+	for ply := 1; ply <= c.Len(); ply++ {
+		if !c.Next() {
+			t.Fatalf("Next() failed at ply %d", ply-1)
+		}
+		want, err := g.PositionAtMove(ply/2+1, c.Position().ToMove())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Position().Hash() != want.Hash() {
+			t.Errorf("ply %d: got %s want %s", ply, FormatTPS(c.Position()), FormatTPS(want))
+		}
+	}
+	if c.Next() || c.Ply() != 5 {
+		t.Errorf("Next() past the end: ply=%d", c.Ply())
+	}
+	if m := c.LastMove(); m == nil || m.Source() != "e5-" {
+		t.Errorf("LastMove()=%v", m)
+	}
+	final := c.Position()
+	if !c.Seek(2) || FormatTPS(c.Position()) != "x4,1/x5/x5/x5/2,x4 1 2" {
+		t.Errorf("Seek(2): %s", FormatTPS(c.Position()))
+	}
+	if c.Seek(6) || c.Seek(-1) || c.Ply() != 2 {
+		t.Errorf("Seek out of range moved the cursor to %d", c.Ply())
+	}
+	if !c.Seek(5) || c.Position() != final {
+		t.Errorf("Seek(5) did not return to the final position")
+	}
+
+	c, err = NewGameCursor(g, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 4 || !c.Seek(4) || FormatTPS(c.Position()) != "x4,1/x5/x2,2,x2/x,1,x3/2,x4 1 3" {
+		t.Errorf("variation: len=%d %s", c.Len(), FormatTPS(c.Position()))
+	}
+
+	bad, err := ParsePTN(strings.NewReader("[Size \"5\"]\n\n1. a1 a1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGameCursor(bad, nil); err == nil {
+		t.Errorf("illegal move: no error")
+	}
+}
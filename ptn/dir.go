@@ -0,0 +1,69 @@
+package ptn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileError records a file that ReadDir could not read or parse.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// DirError is returned by ReadDir when it skipped some files.
+type DirError []*FileError
+
+func (e DirError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more)", e[0].Error(), len(e)-1)
+}
+
+// ReadDir parses every file ending in .ptn under dir, descending into
+// subdirectories and ignoring files with other names. Games are
+// returned in lexical order of their paths.
+//
+// A file that can't be read or parsed does not stop the walk: ReadDir
+// returns every game it could parse, and a DirError listing the files
+// it skipped. Any other error, such as dir not existing, is returned
+// as is.
+func ReadDir(dir string) ([]*PTN, error) {
+	var out []*PTN
+	var skipped DirError
+	e := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".ptn") {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			skipped = append(skipped, &FileError{path, err})
+			return nil
+		}
+		defer f.Close()
+		g, err := ParsePTN(f)
+		if err != nil {
+			skipped = append(skipped, &FileError{path, err})
+			return nil
+		}
+		out = append(out, g)
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	if len(skipped) > 0 {
+		return out, skipped
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package ptn
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ptn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.ptn":       "[Size \"5\"]\n[Name \"a\"]\n\n1. a1 e5\n",
+		"notes.txt":   "not a game",
+		"sub/b.ptn":   "[Size \"5\"]\n[Name \"b\"]\n\n1. a1 e5 2. c3\n",
+		"sub/bad.ptn": "[Size \"5\"]\n\n1. a1 zz9\n",
+	}
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ptns, err := ReadDir(dir)
+	de, ok := err.(DirError)
+	if !ok || len(de) != 1 || de[0].Path != filepath.Join(dir, "sub/bad.ptn") {
+		t.Errorf("err=%#v, want a DirError for sub/bad.ptn", err)
+	}
+	if len(ptns) != 2 || ptns[0].FindTag("Name") != "a" || ptns[1].FindTag("Name") != "b" {
+		t.Fatalf("read %d games", len(ptns))
+	}
+
+	if _, err := ReadDir(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("missing dir: no error")
+	} else if _, ok := err.(DirError); ok {
+		t.Errorf("missing dir: err=%v", err)
+	}
+}
@@ -2,21 +2,61 @@ package ptn
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/nelhage/taktician/tak"
 )
 
-var moveRE = regexp.MustCompile(
-	// [place] [carry] position [direction] [drops] [top]
-	`([CFS]?)([1-8]?)([a-h][1-9])([<>+-]?)([1-8]*)([CFS]?)`,
+const movePattern = // [place] [carry] position [direction] [drops] [top]
+`([CFS]?)([0-9]?)([a-h][1-8])([<>+-]?)([0-9]*)([CFS]?)`
+
+var (
+	moveRE         = regexp.MustCompile(movePattern)
+	anchoredMoveRE = regexp.MustCompile(`^` + movePattern)
 )
 
+// ParseMove parses the first move found anywhere in `move`, ignoring
+// whatever surrounds it.
 func ParseMove(move string) (tak.Move, error) {
 	groups := moveRE.FindStringSubmatch(move)
 	if groups == nil {
 		return tak.Move{}, errors.New("illegal move")
 	}
+	return parseMoveGroups(groups)
+}
+
+// ParseMoveN parses a move at the start of `s`, and returns the
+// number of bytes it consumed. Unlike ParseMove, it rejects input that
+// does not begin with a move, or whose move runs on into further
+// letters, digits or direction marks, such as `a1xyz'; annotations
+// and whitespace may follow.
+func ParseMoveN(s string) (tak.Move, int, error) {
+	groups := anchoredMoveRE.FindStringSubmatch(s)
+	if groups == nil {
+		return tak.Move{}, 0, errors.New("illegal move")
+	}
+	n := len(groups[0])
+	if n < len(s) && isMoveByte(s[n]) {
+		return tak.Move{}, 0, fmt.Errorf("illegal move: trailing %q", s[n:])
+	}
+	m, e := parseMoveGroups(groups)
+	if e != nil {
+		return tak.Move{}, 0, e
+	}
+	return m, n, nil
+}
+
+func isMoveByte(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("<>+-", c) >= 0
+}
+
+func parseMoveGroups(groups []string) (tak.Move, error) {
 	var (
 		place     = groups[1]
 		carry     = groups[2]
@@ -51,9 +91,21 @@ func ParseMove(move string) (tak.Move, error) {
 	if carry != "" {
 		stack = int(carry[0] - '0')
 	}
+	if stack == 0 {
+		return tak.Move{}, errors.New("can't carry zero stones")
+	}
+	if stack > tak.MaxSize {
+		return tak.Move{}, fmt.Errorf("can't carry more than %d stones", tak.MaxSize)
+	}
 	for _, d := range drops {
+		if d == '0' {
+			return tak.Move{}, errors.New("can't drop zero stones")
+		}
 		m.Slides = append(m.Slides, byte(d-'0'))
 		stack -= int(d - '0')
+		if stack < 0 {
+			return tak.Move{}, errors.New("drops exceed the stones carried")
+		}
 	}
 	if stack > 0 {
 		m.Slides = append(m.Slides, byte(stack))
@@ -74,7 +126,24 @@ func ParseMove(move string) (tak.Move, error) {
 	return m, nil
 }
 
+// FormatMoveOpts controls optional aspects of move formatting.
+type FormatMoveOpts struct {
+	// ExplicitFlat emits the `F' marker on flat placements
+	// (`Fa1' rather than `a1').
+	ExplicitFlat bool
+	// OmitLastDrop leaves off the final drop count of a slide
+	// over several squares (`3a1>11' rather than `3a1>111').
+	// The count is implied by the carry count, and ParseMove
+	// drops the stones left over on the last square, but this
+	// is not standard PTN, which lists every drop.
+	OmitLastDrop bool
+}
+
 func FormatMove(m *tak.Move) string {
+	return FormatMoveWith(m, FormatMoveOpts{})
+}
+
+func FormatMoveWith(m *tak.Move, opts FormatMoveOpts) string {
 	var out []byte
 	stack := 0
 	if len(m.Slides) > 0 {
@@ -87,6 +156,9 @@ func FormatMove(m *tak.Move) string {
 	}
 	switch m.Type {
 	case tak.PlaceFlat:
+		if opts.ExplicitFlat {
+			out = append(out, 'F')
+		}
 	case tak.PlaceCapstone:
 		out = append(out, 'C')
 	case tak.PlaceStanding:
@@ -105,9 +177,27 @@ func FormatMove(m *tak.Move) string {
 		out = append(out, '-')
 	}
 	if len(m.Slides) > 0 && int(m.Slides[0]) != stack {
-		for _, s := range m.Slides {
+		drops := m.Slides
+		if opts.OmitLastDrop {
+			drops = drops[:len(drops)-1]
+		}
+		for _, s := range drops {
 			out = append(out, byte('0'+s))
 		}
 	}
 	return string(out)
 }
+
+// FormatMoveMinimal returns the shortest notation for `m` that
+// ParseMove reads back as the same move, or an error if `m` is not
+// legal in `p`. It formats as FormatMove does, and also omits the
+// final drop count of a slide over several squares (see
+// FormatMoveOpts.OmitLastDrop). A slide that clears its whole stack
+// still gives its carry count, which ParseMove would otherwise read
+// as one stone.
+func FormatMoveMinimal(m *tak.Move, p *tak.Position) (string, error) {
+	if _, e := p.Move(m); e != nil {
+		return "", e
+	}
+	return FormatMoveWith(m, FormatMoveOpts{OmitLastDrop: true}), nil
+}
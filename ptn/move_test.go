@@ -69,3 +69,174 @@ func TestParseMove(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatMoveExplicitFlat(t *testing.T) {
+	opts := FormatMoveOpts{ExplicitFlat: true}
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"a1", "Fa1"},
+		{"Fh7", "Fh7"},
+		{"Sa4", "Sa4"},
+		{"Cb2", "Cb2"},
+		{"3a1+111", "3a1+111"},
+	}
+	for _, tc := range cases {
+		m, err := ParseMove(tc.in)
+		if err != nil {
+			t.Fatalf("ParseMove(%s): %v", tc.in, err)
+		}
+		got := FormatMoveWith(&m, opts)
+		if got != tc.out {
+			t.Errorf("FormatMoveWith(%s)=%s not %s", tc.in, got, tc.out)
+		}
+		back, err := ParseMove(got)
+		if err != nil || !back.Equal(&m) {
+			t.Errorf("round-trip(%s) = %#v, %v", got, back, err)
+		}
+	}
+}
+
+func TestFormatMoveNormalizesSlides(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"a1>", "a1>"},
+		{"1a1>", "a1>"},
+		{"1a1>1", "a1>"},
+		{"3a1>", "3a1>"},
+		{"3a1>3", "3a1>"},
+		{"3a1>12", "3a1>12"},
+		{"3a1>111", "3a1>111"},
+		{"4b2+31", "4b2+31"},
+	}
+	for _, tc := range cases {
+		m, err := ParseMove(tc.in)
+		if err != nil {
+			t.Fatalf("ParseMove(%s): %v", tc.in, err)
+		}
+		got := FormatMove(&m)
+		if got != tc.out {
+			t.Errorf("FormatMove(%s)=%s not %s", tc.in, got, tc.out)
+		}
+		back, err := ParseMove(got)
+		if err != nil || !back.Equal(&m) {
+			t.Errorf("round-trip(%s) = %#v, %v", got, back, err)
+		}
+	}
+}
+
+func TestFormatMoveMinimal(t *testing.T) {
+	cases := []struct {
+		tps string
+		in  string
+		out string
+	}{
+		{"x5/x5/x5/x,2,x3/1212121,x4 1 10", "Fc3", "c3"},
+		// partial stack clears
+		{"x5/x5/x5/x,2,x3/1212121,x4 1 10", "1a1>1", "a1>"},
+		{"x5/x5/x5/x,2,x3/1212121,x4 1 10", "5a1>5", "5a1>"},
+		{"x5/x5/x5/x,2,x3/1212121,x4 1 10", "5a1+41", "5a1+4"},
+		{"x5/x5/x5/x,2,x3/1212121,x4 1 10", "5a1>2111", "5a1>211"},
+		{"x5/x5/x5/x,2,x3/1212121,x4 1 10", "2a1+11", "2a1+1"},
+		// full stack clears
+		{"x5/x5/x5/x,2,x3/12121,x4 1 10", "5a1+5", "5a1+"},
+		{"x5/x5/x5/x,2,x3/12121,x4 1 10", "5a1>221", "5a1>22"},
+		{"x5/x5/x5/x,2,x3/1,x4 1 10", "1a1+1", "a1+"},
+	}
+	for _, tc := range cases {
+		p, err := ParseTPS(tc.tps)
+		if err != nil {
+			t.Fatal("parse tps:", err)
+		}
+		m, err := ParseMove(tc.in)
+		if err != nil {
+			t.Fatalf("ParseMove(%s): %v", tc.in, err)
+		}
+		got, err := FormatMoveMinimal(&m, p)
+		if err != nil {
+			t.Errorf("FormatMoveMinimal(%s): %v", tc.in, err)
+			continue
+		}
+		if got != tc.out {
+			t.Errorf("FormatMoveMinimal(%s)=%s not %s", tc.in, got, tc.out)
+		}
+		back, err := ParseMove(got)
+		if err != nil || !back.Equal(&m) {
+			t.Errorf("round-trip(%s) = %#v, %v", got, back, err)
+		}
+	}
+
+	p, err := ParseTPS("x5/x5/x5/x,2,x3/1212121,x4 1 10")
+	if err != nil {
+		t.Fatal("parse tps:", err)
+	}
+	m, _ := ParseMove("b2<")
+	if _, err := FormatMoveMinimal(&m, p); err == nil {
+		t.Errorf("FormatMoveMinimal accepted a move of the wrong color")
+	}
+}
+
+func TestParseMoveOffBoard(t *testing.T) {
+	for _, in := range []string{"a9", "i1", "3i1>"} {
+		if m, err := ParseMove(in); err == nil {
+			t.Errorf("ParseMove(%s)=%#v, want error", in, m)
+		}
+	}
+}
+
+func TestParseMoveN(t *testing.T) {
+	cases := []struct {
+		in   string
+		out  tak.Move
+		n    int
+		fail bool
+	}{
+		{in: "a1", out: tak.Move{X: 0, Y: 0, Type: tak.PlaceFlat}, n: 2},
+		{in: "Sb2 c3", out: tak.Move{X: 1, Y: 1, Type: tak.PlaceStanding}, n: 3},
+		{in: "3a1+12'", out: tak.Move{X: 0, Y: 0, Type: tak.SlideUp, Slides: []byte{1, 2}}, n: 6},
+		{in: "b3>*", out: tak.Move{X: 1, Y: 2, Type: tak.SlideRight, Slides: []byte{1}}, n: 3},
+		{in: "a1xyz", fail: true},
+		{in: "a12", fail: true},
+		{in: "xa1", fail: true},
+		{in: " a1", fail: true},
+		{in: "", fail: true},
+	}
+	for _, tc := range cases {
+		m, n, err := ParseMoveN(tc.in)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("ParseMoveN(%q)=%#v,%d, want error", tc.in, m, n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMoveN(%q): %v", tc.in, err)
+			continue
+		}
+		if n != tc.n || !reflect.DeepEqual(m, tc.out) {
+			t.Errorf("ParseMoveN(%q)=%#v,%d, want %#v,%d", tc.in, m, n, tc.out, tc.n)
+		}
+	}
+}
+
+func TestParseMoveBadDrops(t *testing.T) {
+	for _, in := range []string{
+		"3a1>1111", // drops exceed carry
+		"a1>2",     // drops exceed the implicit carry of 1
+		"2a1>3",
+		"3a1>102", // zero drop
+		"0a1>",    // zero carry
+		"9a1>",    // carry exceeds limit
+		"9a1>54",
+	} {
+		if m, err := ParseMove(in); err == nil {
+			t.Errorf("ParseMove(%s)=%#v, want error", in, m)
+		}
+		if m, _, err := ParseMoveN(in); err == nil {
+			t.Errorf("ParseMoveN(%s)=%#v, want error", in, m)
+		}
+	}
+}
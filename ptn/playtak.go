@@ -0,0 +1,114 @@
+package ptn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nelhage/taktician/playtak"
+	"github.com/nelhage/taktician/tak"
+)
+
+// playtakTags maps the column names used by the playtak.com games
+// database to standard PTN tags.
+var playtakTags = map[string]string{
+	"id":           "Id",
+	"size":         "Size",
+	"player_white": "Player1",
+	"player_black": "Player2",
+	"result":       "Result",
+}
+
+// ParsePlaytak parses a game in the format exported by the
+// playtak.com games database: one `[column "value"]` tag per
+// database column, followed by the game's `notation` column, a
+// comma-separated list of moves in server notation (e.g. "P A1",
+// "M A1 A3 2 1").
+//
+// The game is normalized to standard PTN: tags are renamed (player_white
+// becomes Player1, and so on), the millisecond `date` timestamp becomes
+// Date and Time tags, and playtak's result codes are mapped to PTN
+// results. As on the server, the first two placements place the
+// opponent's stone. The moves are replayed to check that they are
+// legal.
+func ParsePlaytak(r io.Reader) (*PTN, error) {
+	buf := bufio.NewReader(r)
+	var raw PTN
+	if err := readEvents(buf, &raw); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var out PTN
+	var result string
+	for _, t := range raw.Tags {
+		name := strings.ToLower(t.Name)
+		switch name {
+		case "date":
+			ms, e := strconv.ParseInt(t.Value, 10, 64)
+			if e != nil {
+				return nil, fmt.Errorf("bad date: %q", t.Value)
+			}
+			when := time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).UTC()
+			out.Tags = append(out.Tags,
+				Tag{Name: "Date", Value: when.Format("2006-01-02")},
+				Tag{Name: "Time", Value: when.Format(time.RFC3339)})
+			continue
+		case "result":
+			result = playtakResult(t.Value)
+			if result == "" {
+				continue
+			}
+			t.Value = result
+		}
+		if std, ok := playtakTags[name]; ok {
+			t.Name = std
+		}
+		out.Tags = append(out.Tags, t)
+	}
+	if out.FindTag("Size") == "" {
+		return nil, fmt.Errorf("missing size")
+	}
+
+	notation, e := ioutil.ReadAll(buf)
+	if e != nil {
+		return nil, e
+	}
+	var ply int
+	for _, word := range strings.Split(string(notation), ",") {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		m, e := playtak.ParseServer(word)
+		if e != nil {
+			return nil, fmt.Errorf("move %d: %v", ply+1, e)
+		}
+		if ply%2 == 0 {
+			out.Ops = append(out.Ops, &MoveNumber{Number: ply/2 + 1})
+		}
+		out.Ops = append(out.Ops, &Move{Move: m})
+		ply++
+	}
+	if result != "" {
+		out.Ops = append(out.Ops, &Result{Result: result})
+	}
+	if _, e := out.PositionAtMove(0, tak.NoColor); e != nil {
+		return nil, e
+	}
+	return &out, nil
+}
+
+// playtakResult converts a playtak.com result code to PTN, returning
+// "" for games that ended without a result.
+func playtakResult(r string) string {
+	switch r {
+	case "0-0", "":
+		return ""
+	case "1/2":
+		return "1/2-1/2"
+	}
+	return r
+}
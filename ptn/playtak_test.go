@@ -0,0 +1,106 @@
+package ptn
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+const playtakGame = `[id "26520"]
+[date "1463269990000"]
+[size "5"]
+[player_white "TakticianBot"]
+[player_black "applemonkeyman"]
+[result "0-R"]
+[timertime "900"]
+[timerinc "0"]
+
+P A1,P B2,P C3,P A2,P C2,P A3,P C4,P A4,P A5,P B5,M B2 A2 1,P B2,P C1,P C5 C,
+P D4,M B2 C2 1,M C1 C2 1,P B4,P D5,M C5 C4 1,P D3 C,M C4 C2 1 1,M A5 B5 1,P A5,
+M C3 A3 1 1,M C2 A2 2 2,M A3 A5 1 1,M A4 A5 1,P A3 W,M A5 B5 3,M B3 B2 1,
+M B5 B2 1 2 2,M A3 B3 1,P A3,M B3 A3 2,M B4 B3 1,M A3 B3 3,M A2 A3 3,M B3 B1 1 4,
+P A5
+`
+
+const playtakAbandoned = `[id "7249"]
+[date "1462212271000"]
+[size "5"]
+[player_white "TakticianBot"]
+[player_black "kakaburra"]
+[result "0-0"]
+
+P A1,P E5,P A2,P B2,P A3
+`
+
+func TestParsePlaytak(t *testing.T) {
+	g, e := ParsePlaytak(bytes.NewBufferString(playtakGame))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	want := []Tag{
+		{"Id", "26520"},
+		{"Date", "2016-05-14"},
+		{"Time", "2016-05-14T23:53:10Z"},
+		{"Size", "5"},
+		{"Player1", "TakticianBot"},
+		{"Player2", "applemonkeyman"},
+		{"Result", "0-R"},
+		{"timertime", "900"},
+		{"timerinc", "0"},
+	}
+	if !reflect.DeepEqual(g.Tags, want) {
+		t.Errorf("tags=%v", g.Tags)
+	}
+	var moves int
+	for _, o := range g.Ops {
+		if _, ok := o.(*Move); ok {
+			moves++
+		}
+	}
+	if moves != 40 {
+		t.Errorf("moves=%d", moves)
+	}
+	if r, ok := g.Ops[len(g.Ops)-1].(*Result); !ok || r.Winner() != tak.Black {
+		t.Errorf("last op=%#v", g.Ops[len(g.Ops)-1])
+	}
+	p, e := g.PositionAtMove(0, tak.NoColor)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if over, winner := p.GameOver(); !over || winner != tak.Black {
+		t.Errorf("GameOver()=%v, %s", over, winner)
+	}
+	// the opening swap: white's first placement is a black stone
+	p, e = g.PositionAtMove(1, tak.Black)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sq := p.At(0, 0); len(sq) != 1 || sq[0].Color() != tak.Black {
+		t.Errorf("a1=%v", sq)
+	}
+
+	g, e = ParsePlaytak(bytes.NewBufferString(playtakAbandoned))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	if r := g.FindTag("Result"); r != "" {
+		t.Errorf("abandoned game: Result=%q", r)
+	}
+	if _, ok := g.Ops[len(g.Ops)-1].(*Result); ok {
+		t.Errorf("abandoned game has a result")
+	}
+
+	for _, bad := range []string{
+		"[size \"5\"]\n\nP A1,P A1",
+		"[size \"5\"]\n\nP A1 W",
+		"[size \"5\"]\n\nP Z9",
+		"[date \"yesterday\"]\n[size \"5\"]\n\nP A1",
+		"P A1,P E5",
+	} {
+		if _, e := ParsePlaytak(bytes.NewBufferString(bad)); e == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
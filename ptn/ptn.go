@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/nelhage/taktician/tak"
@@ -50,6 +51,10 @@ type Move struct {
 	opCommon
 	Move      tak.Move
 	Modifiers string
+	// Clock, if set, is the time left on the mover's clock after
+	// the move, as given by a clock annotation in the comment
+	// following it; see ParseClock.
+	Clock *time.Duration
 }
 
 type Comment struct {
@@ -57,6 +62,14 @@ type Comment struct {
 	Comment string
 }
 
+// Variation is an alternative line, given in parentheses, to the
+// Move immediately preceding it. Its Ops may contain further
+// variations.
+type Variation struct {
+	opCommon
+	Ops []Op
+}
+
 type Result struct {
 	opCommon
 	Result string
@@ -106,12 +119,29 @@ func (p *PTN) InitialPosition() (*tak.Position, error) {
 	if e != nil {
 		return nil, fmt.Errorf("bad size: %s", sizeTag)
 	}
+	if size < tak.MinSize || size > tak.MaxSize {
+		return nil, fmt.Errorf("bad size: %d", size)
+	}
+	cfg := tak.Config{Size: size}
+	if flats := p.FindTag("Flats"); flats != "" {
+		if cfg.Pieces, e = strconv.Atoi(flats); e != nil || cfg.Pieces <= 0 || cfg.Pieces > tak.MaxPieces {
+			return nil, fmt.Errorf("bad flats: %s", flats)
+		}
+	}
+	if caps := p.FindTag("Caps"); caps != "" {
+		if cfg.Capstones, e = strconv.Atoi(caps); e != nil || cfg.Capstones < 0 || cfg.Capstones > tak.MaxCapstones {
+			return nil, fmt.Errorf("bad caps: %s", caps)
+		}
+		if cfg.Capstones == 0 {
+			cfg.Capstones = -1
+		}
+	}
 	tps := p.FindTag("TPS")
 	var out *tak.Position
 	if tps == "" {
-		out = tak.New(tak.Config{Size: size})
+		out = tak.New(cfg)
 	} else {
-		out, e = ParseTPS(tps)
+		out, e = ParseTPSWith(tps, cfg)
 		if e != nil {
 			return nil, fmt.Errorf("bad TPS: %v", e)
 		}
@@ -124,66 +154,286 @@ func (p *PTN) InitialPosition() (*tak.Position, error) {
 }
 
 // PositionAtMove returns the position of the game after PTN move
-// marker `move`, with `color` to play.
+// marker `move`, with `color` to play, following the main line.
 //
 // `move=0` will cause the code to return the final position of the
 // game.
 func (p *PTN) PositionAtMove(move int, color tak.Color) (*tak.Position, error) {
+	return p.PositionInVariation(nil, move, color)
+}
+
+// PositionInVariation is like PositionAtMove, but follows the
+// variation selected by `path`. path[0] selects the path[0]'th
+// variation (counting from 0) on the main line; the line continues
+// into it, and path[1] selects a variation within it, and so on.
+func (p *PTN) PositionInVariation(path []int, move int, color tak.Color) (*tak.Position, error) {
 	if color == tak.NoColor && move != 0 {
 		return nil, fmt.Errorf("can't specify NoColor and move!=0")
 	}
+	ops, e := p.Line(path)
+	if e != nil {
+		return nil, e
+	}
+	g, e := p.InitialPosition()
+	if e != nil {
+		return nil, e
+	}
+	found := false
+	g, e = replay(g, ops, func(ptnMove int, _ *Move, g *tak.Position) bool {
+		if move > 0 && move == ptnMove && g.ToMove() == color {
+			found = true
+			return false
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	if found {
+		return g, nil
+	}
+	if move > 0 {
+		return nil, fmt.Errorf("move not found: %d", move)
+	}
+	return g, nil
+}
+
+// PositionAtPath returns the position at the end of the variation
+// selected by `path` (see PositionInVariation); an empty path selects
+// the end of the main line.
+func (p *PTN) PositionAtPath(path []int) (*tak.Position, error) {
+	return p.PositionInVariation(path, 0, tak.NoColor)
+}
+
+// Positions returns the positions along the main line of the game,
+// starting with the initial position, so that the position after
+// `ply` moves is at index ply. If a move is illegal, it returns the
+// positions up to that move, along with the error.
+func (p *PTN) Positions() ([]*tak.Position, error) {
 	g, e := p.InitialPosition()
 	if e != nil {
 		return nil, e
 	}
+	out := []*tak.Position{g}
+	_, e = replay(g, p.Ops, func(_ int, m *Move, g *tak.Position) bool {
+		if m != nil {
+			out = append(out, g)
+		}
+		return true
+	})
+	return out, e
+}
+
+// FindPositions returns the plies of the main line at which pred
+// holds of the position, as indexes into Positions: 0 is the initial
+// position. Like Positions, it stops at an illegal move, returning
+// the plies found before it along with the error.
+func (p *PTN) FindPositions(pred func(*tak.Position) bool) ([]int, error) {
+	ps, e := p.Positions()
+	var out []int
+	for i, pos := range ps {
+		if pred(pos) {
+			out = append(out, i)
+		}
+	}
+	return out, e
+}
+
+// replay plays the moves in ops from g, calling visit after each op
+// with the current PTN move number, the op if it is a move, and the
+// position after it. It stops when visit returns false or at an
+// illegal move, and returns the last position reached.
+func replay(g *tak.Position, ops []Op, visit func(ptnMove int, m *Move, g *tak.Position) bool) (*tak.Position, error) {
 	var ptnMove int
-	for _, op := range p.Ops {
+	for _, op := range ops {
+		var m *Move
 		switch o := op.(type) {
 		case *MoveNumber:
 			ptnMove = o.Number
 		case *Move:
 			next, e := g.Move(&o.Move)
 			if e != nil {
-				return nil, fmt.Errorf("Illegal Move: %d. %s: %v",
+				return g, fmt.Errorf("Illegal Move: %d. %s: %v",
 					ptnMove, o.Source(), e)
 			}
-			g = next
+			m, g = o, next
 		}
-		if move > 0 && move == ptnMove && g.ToMove() == color {
-			return g, nil
+		if !visit(ptnMove, m, g) {
+			break
 		}
 	}
-	if move > 0 {
-		return nil, fmt.Errorf("move not found: %d", move)
-	}
 	return g, nil
 }
 
+// Line returns the ops along the variation selected by `path` (see
+// PositionInVariation), with the variations themselves removed. A
+// nil path returns the main line.
+func (p *PTN) Line(path []int) ([]Op, error) {
+	return line(p.Ops, path, 0, nil)
+}
+
+// line follows path[depth:] through ops, appending the line's ops to
+// out.
+func line(ops []Op, path []int, depth int, out []Op) ([]Op, error) {
+	n := 0
+	for _, op := range ops {
+		v, ok := op.(*Variation)
+		if !ok {
+			out = append(out, op)
+			continue
+		}
+		if depth == len(path) || n != path[depth] {
+			n++
+			continue
+		}
+		// The variation replaces the last move played.
+		last := len(out) - 1
+		for last >= 0 {
+			if _, ok := out[last].(*Move); ok {
+				break
+			}
+			last--
+		}
+		if last < 0 {
+			return nil, errors.New("variation does not follow a move")
+		}
+		return line(v.Ops, path, depth+1, out[:last])
+	}
+	if depth != len(path) {
+		return nil, fmt.Errorf("variation not found: path %v: step %d selects variation %d, but there are %d",
+			path, depth, path[depth], n)
+	}
+	return out, nil
+}
+
+// tagReader wraps the input while reading tags, tracking the line
+// number for error messages.
+type tagReader struct {
+	r    *bufio.Reader
+	line int
+	// start is the line on which the current tag began
+	start int
+}
+
+func (t *tagReader) readByte() (byte, error) {
+	c, e := t.r.ReadByte()
+	if e == nil && c == '\n' {
+		t.line++
+	}
+	return c, e
+}
+
+func (t *tagReader) unreadByte() error {
+	if e := t.r.UnreadByte(); e != nil {
+		return e
+	}
+	if c, _ := t.r.Peek(1); len(c) == 1 && c[0] == '\n' {
+		t.line--
+	}
+	return nil
+}
+
+func (t *tagReader) skipWS() error {
+	for {
+		c, e := t.readByte()
+		if e != nil {
+			return e
+		}
+		if !unicode.IsSpace(rune(c)) {
+			return t.unreadByte()
+		}
+	}
+}
+
+func (t *tagReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", t.start, fmt.Sprintf(format, args...))
+}
+
+// readTag reads the remainder of a tag after the opening '['. The
+// value may be quoted, in which case `\"` and `\\` escape a quote
+// and a backslash.
+func (t *tagReader) readTag() (Tag, error) {
+	var tag Tag
+	var name bytes.Buffer
+	for {
+		c, e := t.readByte()
+		if e != nil {
+			return tag, t.errorf("unterminated tag")
+		}
+		if unicode.IsSpace(rune(c)) {
+			break
+		}
+		if c == ']' || c == '"' {
+			return tag, t.errorf("bad tag: missing value")
+		}
+		name.WriteByte(c)
+	}
+	tag.Name = name.String()
+	if e := t.skipWS(); e != nil {
+		return tag, t.errorf("unterminated tag %s", tag.Name)
+	}
+
+	var val bytes.Buffer
+	c, _ := t.readByte()
+	if c == '"' {
+		for {
+			c, e := t.readByte()
+			if e != nil || c == '\n' {
+				return tag, t.errorf("unterminated value for tag %s", tag.Name)
+			}
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				c, e = t.readByte()
+				if e != nil {
+					return tag, t.errorf("unterminated value for tag %s", tag.Name)
+				}
+			}
+			val.WriteByte(c)
+		}
+		if e := t.skipWS(); e != nil {
+			return tag, t.errorf("unterminated tag %s", tag.Name)
+		}
+		c, _ = t.readByte()
+		if c != ']' {
+			return tag, t.errorf("missing ] after tag %s", tag.Name)
+		}
+	} else {
+		t.unreadByte()
+		for {
+			c, e := t.readByte()
+			if e != nil || c == '\n' {
+				return tag, t.errorf("unterminated tag %s", tag.Name)
+			}
+			if c == ']' {
+				break
+			}
+			val.WriteByte(c)
+		}
+	}
+	tag.Value = val.String()
+	return tag, nil
+}
+
 func readEvents(r *bufio.Reader, ptn *PTN) error {
+	t := &tagReader{r: r, line: 1}
 	for {
-		if e := skipWS(r); e != nil {
+		if e := t.skipWS(); e != nil {
 			return e
 		}
-		c, e := r.ReadByte()
+		c, e := t.readByte()
 		if e != nil {
 			return e
 		}
 		if c != '[' {
-			return r.UnreadByte()
+			return t.unreadByte()
 		}
-		line, e := r.ReadString(']')
+		t.start = t.line
+		tag, e := t.readTag()
 		if e != nil {
 			return e
 		}
-		line = line[:len(line)-1]
-		bits := strings.SplitN(line, " ", 2)
-		if len(bits) != 2 {
-			return errors.New("bad tag")
-		}
-		tag := Tag{
-			Name:  bits[0],
-			Value: strings.Trim(bits[1], "\""),
-		}
 		ptn.Tags = append(ptn.Tags, tag)
 	}
 }
@@ -193,30 +443,81 @@ var resultRE = regexp.MustCompile(`^(F|R|1/2|1|0)-(F|R|1/2|1|0)$`)
 func readMoves(r *bufio.Reader, ptn *PTN) error {
 	s := bufio.NewScanner(r)
 	s.Split(splitMoves)
+	ops := &ptn.Ops
+	var stack []*[]Op
 	for s.Scan() {
 		tok := s.Text()
 		common := opCommon{tok}
 		switch {
+		case tok == "(":
+			v := &Variation{opCommon: common}
+			*ops = append(*ops, v)
+			stack = append(stack, ops)
+			ops = &v.Ops
+		case tok == ")":
+			if len(stack) == 0 {
+				return errors.New("unmatched )")
+			}
+			ops = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
 		case tok[0] == '{':
-			ptn.Ops = append(ptn.Ops, &Comment{common, tok[1 : len(tok)-1]})
+			c := &Comment{common, tok[1 : len(tok)-1]}
+			if n := len(*ops); n > 0 {
+				if m, ok := (*ops)[n-1].(*Move); ok && m.Clock == nil {
+					if d, ok := ParseClock(c.Comment); ok {
+						m.Clock = &d
+					}
+				}
+			}
+			*ops = append(*ops, c)
 		case tok[len(tok)-1] == '.':
-			n, e := strconv.Atoi(tok[:len(tok)-1])
+			n, e := strconv.Atoi(strings.TrimRight(tok, "."))
 			if e != nil {
 				return e
 			}
-			ptn.Ops = append(ptn.Ops, &MoveNumber{common, n})
+			*ops = append(*ops, &MoveNumber{common, n})
 		case resultRE.MatchString(tok):
-			ptn.Ops = append(ptn.Ops, &Result{common, tok})
+			*ops = append(*ops, &Result{common, tok})
 		default:
-			trimmed := strings.TrimRight(tok, "?!'")
-			move, e := ParseMove(trimmed)
-			if e != nil {
-				return fmt.Errorf("bad move: %s", trimmed)
+			move, n, e := ParseMoveN(tok)
+			// a crush mark may follow the move, and then
+			// annotations
+			mods := strings.TrimPrefix(tok[n:], "*")
+			if e != nil || strings.Trim(mods, "?!'") != "" {
+				return fmt.Errorf("bad move: %s", strings.TrimRight(tok, "?!'"))
 			}
-			ptn.Ops = append(ptn.Ops, &Move{common, move, tok[len(trimmed):]})
+			*ops = append(*ops, &Move{common, move, mods, nil})
 		}
 	}
-	return s.Err()
+	if e := s.Err(); e != nil {
+		return e
+	}
+	if len(stack) != 0 {
+		return errors.New("unterminated variation")
+	}
+	return nil
+}
+
+var clockRE = regexp.MustCompile(`\[%clk\s+(?:(\d+):)?(\d+):(\d+(?:\.\d+)?)\s*\]`)
+
+// ParseClock extracts a clock reading from a comment, in the
+// `[%clk H:MM:SS]` form used by PGN, where the hours and a fraction
+// of a second are optional. The annotation may appear anywhere in the
+// comment. Comments without one are left to be read as text, so
+// ParseClock reports false for them rather than an error.
+func ParseClock(comment string) (time.Duration, bool) {
+	m := clockRE.FindStringSubmatch(comment)
+	if m == nil {
+		return 0, false
+	}
+	var h, min int
+	if m[1] != "" {
+		h, _ = strconv.Atoi(m[1])
+	}
+	min, _ = strconv.Atoi(m[2])
+	sec, _ := strconv.ParseFloat(m[3], 64)
+	return time.Duration(h)*time.Hour + time.Duration(min)*time.Minute +
+		time.Duration(sec*float64(time.Second)), true
 }
 
 func splitMoves(buf []byte, atEOF bool) (int, []byte, error) {
@@ -233,11 +534,16 @@ func splitMoves(buf []byte, atEOF bool) (int, []byte, error) {
 				return i + 1, buf[start : i+1], nil
 			}
 		}
+	} else if buf[start] == '(' || buf[start] == ')' {
+		return start + 1, buf[start : start+1], nil
 	} else {
 		for i := start; i < len(buf); i++ {
 			if unicode.IsSpace(rune(buf[i])) {
 				return i + 1, buf[start:i], nil
 			}
+			if buf[i] == '(' || buf[i] == ')' {
+				return i, buf[start:i], nil
+			}
 		}
 	}
 	if atEOF {
@@ -246,40 +552,52 @@ func splitMoves(buf []byte, atEOF bool) (int, []byte, error) {
 	return start, nil, nil
 }
 
-func skipWS(r *bufio.Reader) error {
-	for {
-		c, e := r.ReadByte()
-		if e != nil {
-			return e
-		}
-		if !unicode.IsSpace(rune(c)) {
-			return r.UnreadByte()
-		}
-	}
-}
+var tagEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
 
 func (p *PTN) Render() string {
 	var out bytes.Buffer
 	for _, tag := range p.Tags {
 		fmt.Fprintf(&out, "[%s \"%s\"]\n",
-			tag.Name, strings.Replace(tag.Value, "\"", "", -1),
+			tag.Name, tagEscaper.Replace(tag.Value),
 		)
 	}
 	out.WriteString("\n")
 
-	for _, op := range p.Ops {
+	renderOps(&out, p.Ops, false)
+	out.WriteString("\n")
+	return out.String()
+}
+
+func renderOps(out *bytes.Buffer, ops []Op, inVariation bool) {
+	for i, op := range ops {
 		switch o := op.(type) {
 		case *MoveNumber:
-			fmt.Fprintf(&out, "\n%d.", o.Number)
+			dots := "."
+			if strings.HasSuffix(o.src, "...") {
+				dots = "..."
+			}
+			switch {
+			case inVariation && i == 0:
+				fmt.Fprintf(out, "%d%s", o.Number, dots)
+			case inVariation:
+				fmt.Fprintf(out, " %d%s", o.Number, dots)
+			default:
+				fmt.Fprintf(out, "\n%d%s", o.Number, dots)
+			}
 		case *Move:
-			fmt.Fprintf(&out, " %s%s", FormatMove(&o.Move), o.Modifiers)
+			if !(inVariation && i == 0) {
+				out.WriteString(" ")
+			}
+			fmt.Fprintf(out, "%s%s", FormatMove(&o.Move), o.Modifiers)
 		case *Comment:
-			fmt.Fprintf(&out, " {%s}", o.Comment)
+			fmt.Fprintf(out, " {%s}", o.Comment)
+		case *Variation:
+			out.WriteString(" (")
+			renderOps(out, o.Ops, true)
+			out.WriteString(")")
 		case *Result:
-			fmt.Fprintf(&out, "\n%s\n", o.Result)
+			fmt.Fprintf(out, "\n%s\n", o.Result)
 		default:
 		}
 	}
-	out.WriteString("\n")
-	return out.String()
 }
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nelhage/taktician/tak"
 )
@@ -204,3 +205,272 @@ func TestPositionAtMove(t *testing.T) {
 	}
 
 }
+
+func TestParseTags(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []Tag
+		err  string
+	}{
+		{`[White "nelhage"]`, []Tag{{"White", "nelhage"}}, ""},
+		{`[Event "The \"Big\" One"]`, []Tag{{"Event", `The "Big" One`}}, ""},
+		{`[Site "a\\b"]`, []Tag{{"Site", `a\b`}}, ""},
+		{`[Name "x]y"]`, []Tag{{"Name", "x]y"}}, ""},
+		{`[Size 5]`, []Tag{{"Size", "5"}}, ""},
+		{"[Size \"5\"]\n[Date  \"2016\" ]", []Tag{{"Size", "5"}, {"Date", "2016"}}, ""},
+		{"[Size \"5\"]\n[White \"nelhage]\n", nil, "line 2"},
+		{"\n\n[White \"nelhage\"\n", nil, "line 3"},
+		{"[Size \"5\"]\n[White \"nelhage\" x]", nil, "line 2"},
+		{"[White", nil, "line 1"},
+	}
+	for _, tc := range cases {
+		ptn, err := ParsePTN(bytes.NewBufferString(tc.in))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("ParsePTN(%q): err=%v, want %q", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePTN(%q): %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(ptn.Tags, tc.want) {
+			t.Errorf("ParsePTN(%q): tags=%q, want %q", tc.in, ptn.Tags, tc.want)
+		}
+		back, err := ParsePTN(bytes.NewBufferString(ptn.Render()))
+		if err != nil || !reflect.DeepEqual(back.Tags, tc.want) {
+			t.Errorf("round-trip %q: tags=%q err=%v", tc.in, back.Tags, err)
+		}
+	}
+}
+
+func TestVariations(t *testing.T) {
+	src := `[Size "5"]
+
+1. a1 e1 (1... b1 2. c1 (2. d1) c2)
+2. a2 {main} e2
+3. a3 (3. b3 a5 (3... b5)) e3
+`
+	p, e := ParsePTN(bytes.NewBufferString(src))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	cases := []struct {
+		path  []int
+		moves string
+		tps   string
+	}{
+		{nil, "a1 e1 a2 e2 a3 e3", "x5/x5/1,x3,2/1,x3,2/2,x3,1 1 4"},
+		{[]int{0}, "a1 b1 c1 c2", "x5/x5/x5/x2,2,x2/2,1,1,x2 1 3"},
+		{[]int{0, 0}, "a1 b1 d1", "x5/x5/x5/x5/2,1,x,1,x 2 2"},
+		{[]int{1}, "a1 e1 a2 e2 b3 a5", "2,x4/x5/x,1,x3/1,x3,2/2,x3,1 1 4"},
+		{[]int{1, 0}, "a1 e1 a2 e2 b3 b5", "x,2,x3/x5/x,1,x3/1,x3,2/2,x3,1 1 4"},
+	}
+	for _, tc := range cases {
+		ops, e := p.Line(tc.path)
+		if e != nil {
+			t.Errorf("Line(%v): %v", tc.path, e)
+			continue
+		}
+		var moves []string
+		for _, o := range ops {
+			if m, ok := o.(*Move); ok {
+				moves = append(moves, FormatMove(&m.Move))
+			}
+		}
+		if got := strings.Join(moves, " "); got != tc.moves {
+			t.Errorf("Line(%v)=%s, want %s", tc.path, got, tc.moves)
+		}
+		pos, e := p.PositionInVariation(tc.path, 0, tak.NoColor)
+		if e != nil {
+			t.Errorf("PositionInVariation(%v): %v", tc.path, e)
+			continue
+		}
+		if got := FormatTPS(pos); got != tc.tps {
+			t.Errorf("PositionInVariation(%v)=%s, want %s", tc.path, got, tc.tps)
+		}
+	}
+	if _, e := p.Line([]int{2}); e == nil {
+		t.Errorf("Line([2]) did not fail")
+	}
+	for _, path := range [][]int{{2}, {-1}, {0, 1}, {1, 0, 0}} {
+		if _, e := p.PositionAtPath(path); e == nil || !strings.Contains(e.Error(), "variation not found") {
+			t.Errorf("PositionAtPath(%v): err=%v", path, e)
+		}
+	}
+	for _, tc := range cases {
+		pos, e := p.PositionAtPath(tc.path)
+		if e != nil || FormatTPS(pos) != tc.tps {
+			t.Errorf("PositionAtPath(%v)=%v,%v, want %s", tc.path, pos, e, tc.tps)
+		}
+	}
+	if pos, e := p.PositionAtPath([]int{}); e != nil || FormatTPS(pos) != cases[0].tps {
+		t.Errorf("PositionAtPath([]): %v", e)
+	}
+
+	back, e := ParsePTN(bytes.NewBufferString(p.Render()))
+	if e != nil {
+		t.Fatalf("parse rendered: %v\n%s", e, p.Render())
+	}
+	if back.Render() != p.Render() {
+		t.Errorf("variations did not round-trip:\n%s\n%s", p.Render(), back.Render())
+	}
+
+	for _, bad := range []string{"1. a1 (1. b1", "1. a1 e1)", "(1. a1) 1. a1"} {
+		p, e := ParsePTN(bytes.NewBufferString("[Size \"5\"]\n\n" + bad))
+		if e == nil {
+			_, e = p.Line([]int{0})
+		}
+		if e == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestInitialPositionReserves(t *testing.T) {
+	cases := []struct {
+		tags        string
+		stones, cap int
+		err         bool
+	}{
+		{`[Size "5"]`, 21, 1, false},
+		{`[Size "5"] [Flats "30"] [Caps "2"]`, 30, 2, false},
+		{`[Size "5"] [Caps "0"]`, 21, 0, false},
+		{`[Size "4"] [Caps "1"]`, 15, 1, false},
+		{`[Size "5"] [TPS "x5/x5/x5/x5/x5 1 1"] [Flats "10"] [Caps "0"]`, 10, 0, false},
+		{`[Size "x"]`, 0, 0, true},
+		{`[Size "0"]`, 0, 0, true},
+		{`[Size "2"]`, 0, 0, true},
+		{`[Size "9"]`, 0, 0, true},
+		{`[Size "5"] [Flats "0"]`, 0, 0, true},
+		{`[Size "5"] [Caps "-1"]`, 0, 0, true},
+		{`[Size "5"] [Flats "300"]`, 0, 0, true},
+		{`[Size "5"] [Caps "200"]`, 0, 0, true},
+		{`[Size "5"] [TPS "1C,x4/x5/x5/x5/x5 2 1"] [Caps "0"]`, 0, 0, true},
+	}
+	for _, tc := range cases {
+		g, err := ParsePTN(strings.NewReader(tc.tags + "\n\n"))
+		if err != nil {
+			t.Fatalf("parse %s: %v", tc.tags, err)
+		}
+		p, err := g.InitialPosition()
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: no error", tc.tags)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.tags, err)
+			continue
+		}
+		if s, c := p.Reserves(tak.Black); s != tc.stones || c != tc.cap {
+			t.Errorf("%s: reserves (%d, %d), want (%d, %d)", tc.tags, s, c, tc.stones, tc.cap)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	cases := []struct {
+		comment string
+		clock   time.Duration
+		ok      bool
+	}{
+		{"[%clk 0:09:57]", 9*time.Minute + 57*time.Second, true},
+		{"[%clk 1:00:00]", time.Hour, true},
+		{"[%clk 4:30.5]", 4*time.Minute + 30500*time.Millisecond, true},
+		{"good move [%clk 0:00:07] ", 7 * time.Second, true},
+		{"What a nub", 0, false},
+		{"[%clk soon]", 0, false},
+	}
+	for _, tc := range cases {
+		d, ok := ParseClock(tc.comment)
+		if ok != tc.ok || d != tc.clock {
+			t.Errorf("ParseClock(%q) = %s, %v, want %s, %v",
+				tc.comment, d, ok, tc.clock, tc.ok)
+		}
+	}
+
+	g, err := ParsePTN(bytes.NewBufferString(`[Size "5"]
+
+1. a1 {[%clk 0:05:00]} e1 {[%clk 0:04:58]}
+2. c3 {a fine move} b3 {[%clk 0:04:51]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clocks []string
+	var comments int
+	for _, o := range g.Ops {
+		switch o := o.(type) {
+		case *Move:
+			if o.Clock == nil {
+				clocks = append(clocks, "-")
+			} else {
+				clocks = append(clocks, o.Clock.String())
+			}
+		case *Comment:
+			comments++
+		}
+	}
+	if got := strings.Join(clocks, " "); got != "5m0s 4m58s - 4m51s" {
+		t.Errorf("clocks: %s", got)
+	}
+	if comments != 4 {
+		t.Errorf("kept %d comments, want 4", comments)
+	}
+}
+
+func TestParsePTNBadMove(t *testing.T) {
+	for _, bad := range []string{"1. a1xyz e1", "1. a1 e1>x", "1. Xa1 e1"} {
+		if _, e := ParsePTN(bytes.NewBufferString("[Size \"5\"]\n\n" + bad)); e == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+	p, e := ParsePTN(bytes.NewBufferString("[Size \"5\"]\n\n1. a1 e1 2. e1<*! b1"))
+	if e != nil {
+		t.Fatalf("crush mark: %v", e)
+	}
+	if m := p.Ops[len(p.Ops)-2].(*Move); m.Modifiers != "!" {
+		t.Errorf("modifiers=%q, want \"!\"", m.Modifiers)
+	}
+}
+
+func TestFindPositions(t *testing.T) {
+	g, e := ParsePTN(bytes.NewBufferString(testGame))
+	if e != nil {
+		t.Fatal(e)
+	}
+	ps, e := g.Positions()
+	if e != nil {
+		t.Fatal(e)
+	}
+	final, e := g.PositionAtPath(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(ps) != 15 || FormatTPS(ps[len(ps)-1]) != FormatTPS(final) {
+		t.Fatalf("Positions: %d positions, ending %s", len(ps), FormatTPS(ps[len(ps)-1]))
+	}
+	// Black plays Cc5 on the tenth ply
+	plies, e := g.FindPositions(func(p *tak.Position) bool { return p.Caps != 0 })
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := []int{10, 11, 12, 13, 14}; !reflect.DeepEqual(plies, want) {
+		t.Errorf("capstone on the board at plies %v, want %v", plies, want)
+	}
+
+	bad, e := ParsePTN(bytes.NewBufferString("[Size \"5\"]\n\n1. a1 e1\n2. b1 b1\n3. c1 c2\n"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	plies, e = bad.FindPositions(func(*tak.Position) bool { return true })
+	if e == nil || !strings.Contains(e.Error(), "Illegal Move: 2. b1") {
+		t.Errorf("illegal move: err=%v", e)
+	}
+	if want := []int{0, 1, 2, 3}; !reflect.DeepEqual(plies, want) {
+		t.Errorf("illegal move: plies %v, want %v", plies, want)
+	}
+}
@@ -9,26 +9,51 @@ import (
 	"github.com/nelhage/taktician/tak"
 )
 
+// ParseTPS parses a position in Tak Positional System notation: the
+// board, row by row from the top, then optionally the player to move,
+// 1 for White or 2 for Black, and the move number, counted from 1 as
+// in PTN. If both are omitted, it is White's first move.
 func ParseTPS(tpn string) (*tak.Position, error) {
+	return ParseTPSWith(tpn, tak.Config{})
+}
+
+// ParseTPSWith is like ParseTPS, but takes the reserves for the game
+// from cfg. cfg.Size is set from the board.
+func ParseTPSWith(tpn string, cfg tak.Config) (*tak.Position, error) {
 	var pieces [][]tak.Square
-	words := strings.Split(tpn, " ")
-	if len(words) != 3 {
-		return nil, errors.New("bad TPN: wrong number of words")
+	words := strings.Fields(tpn)
+	if len(words) == 0 {
+		return nil, errors.New("bad TPS: empty")
 	}
-	turn, err := strconv.Atoi(words[1])
-	if err != nil {
-		return nil, fmt.Errorf("bad turn: %s", words[1])
+	// The trailer is the last two words, if both are numbers;
+	// the rest is the board, which may contain whitespace.
+	var trailer []string
+	n := len(words)
+	if n > 2 && isNumber(words[n-2]) && isNumber(words[n-1]) {
+		words, trailer = words[:n-2], words[n-2:]
+		n -= 2
 	}
-	if turn != 1 && turn != 2 {
-		return nil, fmt.Errorf("bad turn: %s", words[1])
+	if n > 1 && isNumber(words[n-1]) &&
+		!strings.HasSuffix(words[n-2], ",") && !strings.HasSuffix(words[n-2], "/") {
+		// a lone number cannot continue the board
+		return nil, fmt.Errorf("bad TPS: want the player to move and the move number after the board, got %q",
+			strings.Join(append(words[n-1:n:n], trailer...), " "))
 	}
-	move, err := strconv.Atoi(words[2])
-	if err != nil {
-		return nil, fmt.Errorf("bad move: %s", words[2])
+	board := strings.Join(words, "")
+	move := 0
+	if len(trailer) == 2 {
+		turn, err := strconv.Atoi(trailer[0])
+		if err != nil || (turn != 1 && turn != 2) {
+			return nil, fmt.Errorf("bad turn: %q: want 1 or 2", trailer[0])
+		}
+		n, err := strconv.Atoi(trailer[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad move number: %q", trailer[1])
+		}
+		move = 2*(n-1) + (turn - 1)
 	}
-	move = 2*(move-1) + (turn - 1)
 
-	rows := strings.Split(words[0], "/")
+	rows := strings.Split(board, "/")
 	for _, r := range rows {
 		row, err := parseRow(r)
 		if err != nil {
@@ -44,7 +69,43 @@ func ParseTPS(tpn string) (*tak.Position, error) {
 			return nil, fmt.Errorf("row %d bad length: %d", i, len(r))
 		}
 	}
-	return tak.FromSquares(tak.Config{Size: len(pieces)}, pieces, move)
+	cfg.Size = len(pieces)
+	return tak.FromSquares(cfg, pieces, move)
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// NormalizeTPS returns the canonical spelling of the TPS string tps,
+// as FormatTPS would write it, so that equivalent positions compare
+// equal: runs of empty squares use the xN shorthand, and extra
+// whitespace is dropped.
+func NormalizeTPS(tps string) (string, error) {
+	p, err := ParseTPS(tps)
+	if err != nil {
+		return "", err
+	}
+	return FormatTPS(p), nil
+}
+
+// CanonicalTPS returns the TPS of the least, as a string, of the
+// positions equivalent to p under the symmetries of the board, so
+// that positions differing only by a rotation or reflection share a
+// key. It also returns the symmetries that map p to that position,
+// of which there is more than one if p is itself symmetric.
+func CanonicalTPS(p *tak.Position) (string, []tak.Symmetry) {
+	best, syms := FormatTPS(p), []tak.Symmetry{tak.Identity}
+	for s := tak.Identity + 1; s < tak.NumSymmetries; s++ {
+		switch tps := FormatTPS(p.Transform(s)); {
+		case tps < best:
+			best, syms = tps, append(syms[:0], s)
+		case tps == best:
+			syms = append(syms, s)
+		}
+	}
+	return best, syms
 }
 
 func FormatTPS(p *tak.Position) string {
@@ -102,10 +163,17 @@ func parseRow(row string) ([]tak.Square, error) {
 	var out []tak.Square
 	bits := strings.Split(row, ",")
 	for _, bit := range bits {
+		if bit == "" {
+			return nil, fmt.Errorf("empty square in row: %s", row)
+		}
 		if bit[0] == 'x' {
 			count := 1
 			if len(bit) > 1 {
-				count = int(bit[1] - '0')
+				var err error
+				count, err = strconv.Atoi(bit[1:])
+				if err != nil || count < 1 {
+					return nil, fmt.Errorf("bad empty run: %s", bit)
+				}
 			}
 			for i := 0; i < count; i++ {
 				out = append(out, nil)
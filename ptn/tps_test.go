@@ -2,6 +2,7 @@ package ptn
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/nelhage/taktician/tak"
@@ -126,3 +127,175 @@ func TestRenderTPS(t *testing.T) {
 		t.Fatalf("FormatTPS:\n in= `%s`\n out=`%s`", tps, out)
 	}
 }
+
+func TestParseTPSReserves(t *testing.T) {
+	cases := []struct {
+		tps string
+		ok  bool
+	}{
+		{"1C,2C,x3/x5/x5/x5/x5 1 2", true},
+		{"1C,1C,x3/x5/x5/x5/x5 1 2", false},
+		{"x4/x4/x4/x,2C,x2 1 2", false},
+		// 15 white stones is exactly the 4x4 reserve
+		{"111111111111111,x3/x4/x4/x4 1 10", true},
+		{"1111111111111111,x3/x4/x4/x4 1 10", false},
+		{"11111111111111,x3/x4/x4/1S,x3 1 10", true},
+		{"11111111111111,x3/x4/x4/1S,1,x2 1 10", false},
+		{"x3/x3/2222222222,x2 1 10", true},
+		{"x3/x3/22222222222,x2 1 10", false},
+	}
+	for _, tc := range cases {
+		_, e := ParseTPS(tc.tps)
+		if tc.ok && e != nil {
+			t.Errorf("ParseTPS(%q): %v", tc.tps, e)
+		}
+		if !tc.ok && e == nil {
+			t.Errorf("ParseTPS(%q) accepted too many stones", tc.tps)
+		}
+	}
+}
+
+func TestParseTPSTrailer(t *testing.T) {
+	cases := []struct {
+		tps    string
+		move   int
+		toMove tak.Color
+		err    string
+	}{
+		{tps: "x5/x5/x5/x5/x5", move: 0, toMove: tak.White},
+		{tps: "x5/x5/x5/x5/1,x4", move: 0, toMove: tak.White},
+		{tps: "x5/x5/x5/x5/x5 1 1", move: 0, toMove: tak.White},
+		{tps: "x5/x5/x5/x5/2,x4 2 1", move: 1, toMove: tak.Black},
+		{tps: "x5/x5/x5/x5/2,1,x3 1 2", move: 2, toMove: tak.White},
+		{tps: "x5/x5/x5/x5/2,1,x3 2 9", move: 17, toMove: tak.Black},
+		{tps: "x5/x5/ x5/x5/x5  1 1", move: 0, toMove: tak.White},
+		{tps: "x5/x5/x5/x5/ x5 1 1", move: 0, toMove: tak.White},
+		{tps: "x5/x5/x5/x5/1,x3, 2 2 1", move: 1, toMove: tak.Black},
+		{tps: "", err: "empty"},
+		{tps: "x5/x5/x5/x5/x5 1", err: "want the player to move"},
+		{tps: "x5/x5/x5/x5/x5 1 1 1", err: "want the player to move"},
+		{tps: "x5/x5/x5/x5/x5 3 1", err: "bad turn"},
+		{tps: "x5/x5/x5/x5/x5 W 1", err: "want the player to move"},
+		{tps: "x5/x5/x5/x5/x5 1 0", err: "bad move number"},
+		{tps: "x5/x5/x5/x5/x5 1 -1", err: "bad move number"},
+		{tps: "x5/x5/x5/x5/x5 1 x", err: "bad empty run"},
+	}
+	for _, tc := range cases {
+		p, e := ParseTPS(tc.tps)
+		if tc.err != "" {
+			if e == nil || !strings.Contains(e.Error(), tc.err) {
+				t.Errorf("ParseTPS(%q): err=%v, want %q", tc.tps, e, tc.err)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("ParseTPS(%q): %v", tc.tps, e)
+			continue
+		}
+		if p.MoveNumber() != tc.move || p.ToMove() != tc.toMove {
+			t.Errorf("ParseTPS(%q): move=%d to move=%v, want %d %v",
+				tc.tps, p.MoveNumber(), p.ToMove(), tc.move, tc.toMove)
+		}
+	}
+}
+
+func TestNormalizeTPS(t *testing.T) {
+	cases := []struct {
+		in   []string
+		want string
+	}{
+		{
+			[]string{
+				"x5/x5/x5/x5/x5 1 1",
+				"x,x,x,x,x/x2,x3/x5/x4,x/x,x,x3 1 1",
+				"  x5/x5/x5/x5/x5\t1   1 ",
+			},
+			"x5/x5/x5/x5/x5 1 1",
+		},
+		{
+			[]string{
+				"2,x,x,121C,1/x,x,2,12,1/x2,2,12S,2/x3,1,1/x4,1 1 2",
+				"2, x2, 121C, 1 / x2,2,12,1/x2,2,12S,2/x,x,x,1,1/x,x,x,x,1 1 2",
+			},
+			"2,x2,121C,1/x2,2,12,1/x2,2,12S,2/x3,1,1/x4,1 1 2",
+		},
+	}
+	for _, tc := range cases {
+		for _, in := range tc.in {
+			out, err := NormalizeTPS(in)
+			if err != nil {
+				t.Errorf("NormalizeTPS(%q): %v", in, err)
+				continue
+			}
+			if out != tc.want {
+				t.Errorf("NormalizeTPS(%q)=%q, want %q", in, out, tc.want)
+			}
+		}
+	}
+	for _, bad := range []string{
+		"x5/x5/x5/x5/x5 1",
+		"x5/x5/x5/x5/x,,x3 1 1",
+		"x5/x5/x5/x5/xy 1 1",
+	} {
+		if _, err := NormalizeTPS(bad); err == nil {
+			t.Errorf("NormalizeTPS(%q): no error", bad)
+		}
+	}
+}
+
+func TestCanonicalTPS(t *testing.T) {
+	// the same position in all four corners
+	in := []string{
+		"x4/x4/2,x3/1,1,x2 2 2",
+		"x4/x4/x3,2/x2,1,1 2 2",
+		"1,1,x2/2,x3/x4/x4 2 2",
+		"x2,1,1/x3,2/x4/x4 2 2",
+	}
+	want, _ := CanonicalTPS(mustParseTPS(t, in[0]))
+	for _, tps := range in {
+		p := mustParseTPS(t, tps)
+		got, syms := CanonicalTPS(p)
+		if got != want {
+			t.Errorf("CanonicalTPS(%q)=%q, want %q", tps, got, want)
+		}
+		if len(syms) != 1 {
+			t.Errorf("CanonicalTPS(%q): %d symmetries, want 1", tps, len(syms))
+		}
+		if tf := FormatTPS(p.Transform(syms[0])); tf != got {
+			t.Errorf("%q: symmetry %d gives %q, want %q", tps, syms[0], tf, got)
+		}
+	}
+	if _, syms := CanonicalTPS(tak.New(tak.Config{Size: 5})); len(syms) != int(tak.NumSymmetries) {
+		t.Errorf("empty board: %d symmetries", len(syms))
+	}
+}
+
+func mustParseTPS(t *testing.T, tps string) *tak.Position {
+	p, err := ParseTPS(tps)
+	if err != nil {
+		t.Fatalf("ParseTPS(%q): %v", tps, err)
+	}
+	return p
+}
+
+// TestBinarySmallerThanTPS checks that tak's binary encoding of a
+// position is more compact than its TPS.
+func TestBinarySmallerThanTPS(t *testing.T) {
+	for _, tps := range []string{
+		`x5/x5/x5/x5/x5 1 1`,
+		`x3,12,2S/x,22S,22C,11,21/121,212,12,1121C,1212S/21S,1,21,211S,12S/x,21S,2,x2 1 26`,
+		`x8/x8/x8/x8/x8/x8/x8/x8 2 1`,
+	} {
+		p, e := ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("parse %q: %v", tps, e)
+		}
+		bs, e := p.MarshalBinary()
+		if e != nil {
+			t.Fatalf("marshal %q: %v", tps, e)
+		}
+		if len(bs) >= len(FormatTPS(p)) {
+			t.Errorf("%q: %d bytes of binary, %d of TPS", tps, len(bs), len(FormatTPS(p)))
+		}
+	}
+}
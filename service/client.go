@@ -0,0 +1,82 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nelhage/taktician/ai"
+)
+
+// Client requests analyses from a Server.
+type Client struct {
+	// URL is the base URL of the server, e.g.
+	// "http://localhost:8080".
+	URL string
+	// HTTP is the client to make requests with; nil means
+	// http.DefaultClient.
+	HTTP *http.Client
+}
+
+// Analyze asks the server to analyze req.TPS, calling onIteration,
+// if non-nil, with the report of each iteration as it arrives, and
+// returns the last one. Cancelling ctx cancels the search on the
+// server; Analyze then returns ctx's error.
+func (c *Client) Analyze(ctx context.Context, req *Request, onIteration func(*ai.Report)) (*ai.Report, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hr, err := http.NewRequest("POST", strings.TrimSuffix(c.URL, "/")+"/analyze", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hr = hr.WithContext(ctx)
+	hr.Header.Set("Content-Type", "application/json")
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return nil, errors.New(e.Error)
+		}
+		return nil, fmt.Errorf("analyze: %s", resp.Status)
+	}
+
+	var last *ai.Report
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var rep ai.Report
+		if err := dec.Decode(&rep); err == io.EOF {
+			break
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return last, ctx.Err()
+			}
+			return last, err
+		}
+		last = &rep
+		if onIteration != nil {
+			onIteration(last)
+		}
+	}
+	if ctx.Err() != nil {
+		return last, ctx.Err()
+	}
+	if last == nil {
+		return nil, errors.New("analyze: no result")
+	}
+	return last, nil
+}
@@ -0,0 +1,271 @@
+// Package service serves Taktician's analysis over HTTP.
+//
+// A client POSTs a Request, encoded as JSON, to /analyze, and the
+// server streams back one ai.Report per completed iteration of
+// iterative deepening, as newline-delimited JSON, until the search
+// finishes, its time limit expires, or the client goes away.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/ptn"
+)
+
+// Request asks for an analysis of a position.
+type Request struct {
+	TPS string `json:"tps"`
+
+	// Depth is the depth to search to; 0 means the server's
+	// Config.Depth, or DefaultDepth if that is unset. It may not
+	// exceed the server's MaxDepth.
+	Depth int `json:"depth,omitempty"`
+	// LimitSeconds limits the time spent on the search; 0 means
+	// no limit beyond the server's MaxLimit.
+	LimitSeconds float64 `json:"limit_seconds,omitempty"`
+
+	// Deterministic asks for an engine without randomness and
+	// with no state left from earlier requests, so that identical
+	// requests get identical results.
+	Deterministic bool `json:"deterministic,omitempty"`
+}
+
+// errorResponse is the body of a request that could not be served.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server is an http.Handler serving analyses. The zero Server is
+// ready to use.
+type Server struct {
+	// Config is the base configuration of the engines, to which
+	// each Request's board size and depth are applied.
+	Config ai.MinimaxConfig
+	// MaxLimit, if positive, caps the time spent on any one
+	// analysis.
+	MaxLimit time.Duration
+	// MaxDepth is the deepest search a Request may ask for; 0
+	// means DefaultMaxDepth.
+	MaxDepth int
+	// MaxSearches is the number of analyses to run at once;
+	// requests beyond it are refused. 0 means
+	// DefaultMaxSearches.
+	MaxSearches int
+	// MaxIdle is the number of idle engines kept for reuse, over
+	// all configurations; 0 means DefaultMaxIdle.
+	MaxIdle int
+
+	mu     sync.Mutex
+	active int
+	nidle  int
+	idle   map[engineKey][]*ai.MinimaxAI
+}
+
+const (
+	// DefaultDepth is the depth searched if neither the Request
+	// nor the Server's Config gives one.
+	DefaultDepth = 8
+	// DefaultMaxDepth is the default value of Server.MaxDepth.
+	DefaultMaxDepth = 12
+	// DefaultMaxSearches is the default value of
+	// Server.MaxSearches.
+	DefaultMaxSearches = 4
+	// DefaultMaxIdle is the default value of Server.MaxIdle.
+	DefaultMaxIdle = 4
+)
+
+// engineKey identifies the engines that can serve a request, since
+// the configuration of a MinimaxAI is fixed when it is built.
+type engineKey struct {
+	size, depth int
+}
+
+// newEngine returns a new engine for k.
+func (s *Server) newEngine(k engineKey, deterministic bool) (*ai.MinimaxAI, error) {
+	cfg := s.Config
+	cfg.Size = k.size
+	cfg.Depth = k.depth
+	cfg.Deterministic = deterministic
+	cfg.OnIteration = nil
+	return ai.NewMinimaxChecked(cfg)
+}
+
+// get returns an idle engine for k, or a new one.
+func (s *Server) get(k engineKey) (*ai.MinimaxAI, error) {
+	s.mu.Lock()
+	if es := s.idle[k]; len(es) > 0 {
+		m := es[len(es)-1]
+		s.idle[k] = es[:len(es)-1]
+		s.nidle--
+		s.mu.Unlock()
+		return m, nil
+	}
+	s.mu.Unlock()
+	return s.newEngine(k, false)
+}
+
+// put returns m, which must no longer be searching, to the pool. If
+// the pool is full, it drops an engine of another configuration to
+// make room, or else m itself.
+func (s *Server) put(k engineKey, m *ai.MinimaxAI) {
+	max := s.MaxIdle
+	if max <= 0 {
+		max = DefaultMaxIdle
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idle == nil {
+		s.idle = make(map[engineKey][]*ai.MinimaxAI)
+	}
+	if s.nidle >= max {
+		for ok, es := range s.idle {
+			if ok != k && len(es) > 0 {
+				s.idle[ok] = es[1:]
+				s.nidle--
+				break
+			}
+		}
+	}
+	if s.nidle < max {
+		s.idle[k] = append(s.idle[k], m)
+		s.nidle++
+	}
+}
+
+// Idle returns the number of idle engines in the pool.
+func (s *Server) Idle() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nidle
+}
+
+// acquire reserves one of the server's MaxSearches, reporting
+// whether one was free.
+func (s *Server) acquire() bool {
+	max := s.MaxSearches
+	if max <= 0 {
+		max = DefaultMaxSearches
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active >= max {
+		return false
+	}
+	s.active++
+	return true
+}
+
+func (s *Server) release() {
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/analyze" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "analyze: want POST")
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "bad request: "+err.Error())
+		return
+	}
+	maxDepth := s.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if req.Depth < 0 || req.LimitSeconds < 0 {
+		writeError(w, http.StatusBadRequest, "bad request: negative depth or limit")
+		return
+	}
+	if req.Depth > maxDepth {
+		writeError(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request: depth %d exceeds the maximum of %d", req.Depth, maxDepth))
+		return
+	}
+	p, err := ptn.ParseTPS(req.TPS)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := time.Duration(req.LimitSeconds * float64(time.Second))
+	if s.MaxLimit > 0 && (limit == 0 || limit > s.MaxLimit) {
+		limit = s.MaxLimit
+	}
+	ctx := r.Context()
+	if limit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(rep *ai.Report) {
+		if err := enc.Encode(rep); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if over, _ := p.GameOver(); over {
+		send(ai.NewReport(p, nil, 0, ai.Stats{}, 0))
+		return
+	}
+
+	depth := req.Depth
+	if depth == 0 {
+		depth = s.Config.Depth
+	}
+	if depth == 0 {
+		depth = DefaultDepth
+	}
+	k := engineKey{size: p.Size(), depth: depth}
+	if !s.acquire() {
+		writeError(w, http.StatusServiceUnavailable, "server busy: too many analyses running")
+		return
+	}
+	defer s.release()
+	// A pooled engine's table, heat map and move ordering depend
+	// on the requests it served before, so deterministic requests
+	// get an engine of their own.
+	var m *ai.MinimaxAI
+	if req.Deterministic {
+		m, err = s.newEngine(k, true)
+	} else {
+		m, err = s.get(k)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	start := time.Now()
+	for it := range m.AnalyzeStream(ctx, p) {
+		send(ai.NewReport(p, it.PV, it.Value, it.Stats, time.Since(start)))
+	}
+	if !req.Deterministic {
+		s.put(k, m)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&errorResponse{Error: msg})
+}
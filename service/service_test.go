@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nelhage/taktician/ai"
+)
+
+const testTPS = "2,x4/x2,2,x2/x,2,2,x2/x2,12,2,1/1,1,21,2,1 1 9"
+
+func newTestServer(s *Server) (*httptest.Server, *Client) {
+	srv := httptest.NewServer(s)
+	return srv, &Client{URL: srv.URL}
+}
+
+func TestAnalyze(t *testing.T) {
+	s := &Server{}
+	srv, c := newTestServer(s)
+	defer srv.Close()
+
+	req := &Request{TPS: testTPS, Depth: 3, Deterministic: true}
+	var depths []int
+	rep, err := c.Analyze(context.Background(), req, func(r *ai.Report) {
+		depths = append(depths, r.Stats.Depth)
+	})
+	if err != nil {
+		t.Fatal("analyze:", err)
+	}
+	if len(depths) != 3 || depths[0] != 1 || depths[2] != 3 {
+		t.Errorf("iterations at depths %v, want [1 2 3]", depths)
+	}
+	if rep.Stats.Depth != 3 || rep.Move == "" || rep.Size != 5 {
+		t.Errorf("report=%+v", rep)
+	}
+	want, err := ai.AnalyzeTPS(testTPS, ai.MinimaxConfig{Depth: 3, Deterministic: true}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Move != want.Move || rep.Value != want.Value {
+		t.Errorf("served %s=%d, direct %s=%d", rep.Move, rep.Value, want.Move, want.Value)
+	}
+
+	again, err := c.Analyze(context.Background(), req, nil)
+	if err != nil {
+		t.Fatal("analyze:", err)
+	}
+	if again.Move != rep.Move || again.Value != rep.Value || again.Stats.Visited != rep.Stats.Visited {
+		t.Errorf("second analysis %s=%d visited=%d, first %s=%d visited=%d",
+			again.Move, again.Value, again.Stats.Visited, rep.Move, rep.Value, rep.Stats.Visited)
+	}
+	if s.Idle() != 0 {
+		t.Errorf("idle=%d after deterministic analyses, want none pooled", s.Idle())
+	}
+
+	req.Deterministic = false
+	if _, err := c.Analyze(context.Background(), req, nil); err != nil {
+		t.Fatal("analyze:", err)
+	}
+	if s.Idle() != 1 {
+		t.Fatalf("idle=%d after one analysis, want 1", s.Idle())
+	}
+	if _, err := c.Analyze(context.Background(), req, nil); err != nil {
+		t.Fatal("analyze:", err)
+	}
+	if s.Idle() != 1 {
+		t.Errorf("idle=%d after a second analysis, want the engine reused", s.Idle())
+	}
+	req.TPS = "x4/x4/x4/x4 1 1"
+	if _, err := c.Analyze(context.Background(), req, nil); err != nil {
+		t.Fatal("analyze:", err)
+	}
+	if s.Idle() != 2 {
+		t.Errorf("idle=%d after analyzing another size, want 2", s.Idle())
+	}
+}
+
+func TestAnalyzeGameOver(t *testing.T) {
+	srv, c := newTestServer(&Server{})
+	defer srv.Close()
+
+	rep, err := c.Analyze(context.Background(), &Request{TPS: "1,1,1/x3/2,2,x 2 3"}, nil)
+	if err != nil {
+		t.Fatal("analyze:", err)
+	}
+	if !rep.Over || rep.Winner != "white" {
+		t.Errorf("report=%+v, want a white win", rep)
+	}
+}
+
+func TestAnalyzeBadRequest(t *testing.T) {
+	srv, c := newTestServer(&Server{})
+	defer srv.Close()
+
+	_, err := c.Analyze(context.Background(), &Request{TPS: "x5/x5 1"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "TPS") {
+		t.Errorf("err=%v, want a TPS error", err)
+	}
+	_, err = c.Analyze(context.Background(), &Request{TPS: testTPS, Depth: -1}, nil)
+	if err == nil {
+		t.Error("negative depth: want an error")
+	}
+	_, err = c.Analyze(context.Background(), &Request{TPS: testTPS, Depth: 100000000}, nil)
+	if err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("huge depth: err=%v, want it refused", err)
+	}
+}
+
+func TestAnalyzeLimits(t *testing.T) {
+	s := &Server{MaxSearches: 1, MaxIdle: 1}
+	srv, c := newTestServer(s)
+	defer srv.Close()
+
+	// a second analysis while one is running is refused
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var once bool
+		c.Analyze(ctx, &Request{TPS: testTPS, Depth: DefaultMaxDepth}, func(*ai.Report) {
+			if !once {
+				once = true
+				close(started)
+			}
+		})
+	}()
+	<-started
+	_, err := c.Analyze(context.Background(), &Request{TPS: testTPS, Depth: 1}, nil)
+	if err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("concurrent analysis: err=%v, want busy", err)
+	}
+	cancel()
+	<-done
+
+	// the pool keeps at most MaxIdle engines in all
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Idle() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, tps := range []string{testTPS, "x4/x4/x4/x4 1 1", "x3/x3/x3 1 1"} {
+		if _, err := c.Analyze(context.Background(), &Request{TPS: tps, Depth: 2}, nil); err != nil {
+			t.Fatalf("analyze %s: %v", tps, err)
+		}
+		if s.Idle() != 1 {
+			t.Errorf("after %s: idle=%d, want 1", tps, s.Idle())
+		}
+	}
+}
+
+func TestAnalyzeCancel(t *testing.T) {
+	s := &Server{Config: ai.MinimaxConfig{TableMemory: 1 << 16}}
+	srv, c := newTestServer(s)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	var got int
+	_, err := c.Analyze(ctx, &Request{TPS: testTPS, Depth: DefaultMaxDepth}, func(r *ai.Report) {
+		got++
+		if r.Stats.Depth == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("err=%v, want context.Canceled", err)
+	}
+	if got < 2 {
+		t.Errorf("got %d iterations before cancelling", got)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("cancelled analysis took %s", d)
+	}
+
+	// The server should notice the client went away, stop
+	// searching and return the engine to the pool.
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Idle() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Idle() != 1 {
+		t.Errorf("idle=%d after cancelling, want 1", s.Idle())
+	}
+}
+
+func TestAnalyzeLimit(t *testing.T) {
+	srv, c := newTestServer(&Server{MaxLimit: 200 * time.Millisecond})
+	defer srv.Close()
+
+	start := time.Now()
+	rep, err := c.Analyze(context.Background(), &Request{TPS: testTPS, Depth: DefaultMaxDepth}, nil)
+	if err != nil {
+		t.Fatal("analyze:", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("limited analysis took %s", d)
+	}
+	if rep.Stats.Depth >= DefaultMaxDepth {
+		t.Errorf("depth=%d, want the limit to stop the search", rep.Stats.Depth)
+	}
+}
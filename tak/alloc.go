@@ -65,6 +65,8 @@ func alloc(tpl *Position) *Position {
 		a.Height = a.alloc.Height[:]
 		a.Stacks = a.alloc.Stacks[:]
 		a.analysis.WhiteGroups = a.alloc.Groups[:0]
+		a.analyzed = false
+		a.inherited = false
 		copy(a.Height, tpl.Height)
 		copy(a.Stacks, tpl.Stacks)
 
@@ -74,6 +76,8 @@ func alloc(tpl *Position) *Position {
 		a.Height = a.alloc.Height[:]
 		a.Stacks = a.alloc.Stacks[:]
 		a.analysis.WhiteGroups = a.alloc.Groups[:0]
+		a.analyzed = false
+		a.inherited = false
 		copy(a.Height, tpl.Height)
 		copy(a.Stacks, tpl.Stacks)
 
@@ -83,6 +87,8 @@ func alloc(tpl *Position) *Position {
 		a.Height = a.alloc.Height[:]
 		a.Stacks = a.alloc.Stacks[:]
 		a.analysis.WhiteGroups = a.alloc.Groups[:0]
+		a.analyzed = false
+		a.inherited = false
 		copy(a.Height, tpl.Height)
 		copy(a.Stacks, tpl.Stacks)
 
@@ -92,6 +98,8 @@ func alloc(tpl *Position) *Position {
 		a.Height = a.alloc.Height[:]
 		a.Stacks = a.alloc.Stacks[:]
 		a.analysis.WhiteGroups = a.alloc.Groups[:0]
+		a.analyzed = false
+		a.inherited = false
 		copy(a.Height, tpl.Height)
 		copy(a.Stacks, tpl.Stacks)
 
@@ -101,6 +109,8 @@ func alloc(tpl *Position) *Position {
 		a.Height = a.alloc.Height[:]
 		a.Stacks = a.alloc.Stacks[:]
 		a.analysis.WhiteGroups = a.alloc.Groups[:0]
+		a.analyzed = false
+		a.inherited = false
 		copy(a.Height, tpl.Height)
 		copy(a.Stacks, tpl.Stacks)
 
@@ -110,6 +120,8 @@ func alloc(tpl *Position) *Position {
 		a.Height = a.alloc.Height[:]
 		a.Stacks = a.alloc.Stacks[:]
 		a.analysis.WhiteGroups = a.alloc.Groups[:0]
+		a.analyzed = false
+		a.inherited = false
 		copy(a.Height, tpl.Height)
 		copy(a.Stacks, tpl.Stacks)
 
@@ -128,12 +140,14 @@ func copyPosition(p *Position, out *Position) {
 	out.Height = h
 	out.Stacks = s
 	out.analysis.WhiteGroups = g[:0]
+	out.analyzed = false
+	out.inherited = false
 
 	copy(out.Height, p.Height)
 	copy(out.Stacks, p.Stacks)
 }
 
 func Alloc(size int) *Position {
-	p := Position{cfg: &Config{Size: 5}}
+	p := Position{cfg: &Config{Size: size}}
 	return alloc(&p)
 }
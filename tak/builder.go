@@ -0,0 +1,125 @@
+package tak
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Builder constructs a Position square-by-square, validating the
+// result when Build is called. Errors are deferred until Build, so
+// calls may be chained:
+//
+//	p, err := tak.NewBuilder(tak.Config{Size: 5}).
+//		Set(0, 0, tak.Square{tak.MakePiece(tak.White, tak.Flat)}).
+//		Turn(2, tak.Black).
+//		Build()
+type Builder struct {
+	cfg   Config
+	board [][]Square
+	move  int
+	err   error
+}
+
+// maxHeight is the tallest stack a Position can represent: every
+// piece below the top takes one bit of a uint64.
+const maxHeight = 65
+
+func NewBuilder(cfg Config) *Builder {
+	b := &Builder{cfg: cfg}
+	if b.err = cfg.check(); b.err != nil {
+		return b
+	}
+	b.board = make([][]Square, cfg.Size)
+	for y := range b.board {
+		b.board[y] = make([]Square, cfg.Size)
+	}
+	return b
+}
+
+// Set places `sq` at (x, y), replacing anything already there. As
+// with Position.At, sq[0] is the top of the stack.
+func (b *Builder) Set(x, y int, sq Square) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if x < 0 || x >= b.cfg.Size || y < 0 || y >= b.cfg.Size {
+		b.err = fmt.Errorf("square (%d,%d) is off the board", x, y)
+		return b
+	}
+	b.board[y][x] = append(Square(nil), sq...)
+	return b
+}
+
+// Turn sets the move number, counted as in PTN from 1, and the
+// player to move.
+func (b *Builder) Turn(move int, toMove Color) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if move < 1 {
+		b.err = fmt.Errorf("bad move number: %d", move)
+		return b
+	}
+	b.move = 2 * (move - 1)
+	switch toMove {
+	case White:
+	case Black:
+		b.move++
+	default:
+		b.err = errors.New("must specify a color to move")
+	}
+	return b
+}
+
+func (b *Builder) Build() (*Position, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	for y, row := range b.board {
+		for x, sq := range row {
+			if len(sq) > maxHeight {
+				return nil, fmt.Errorf("stack at (%d,%d) too tall: %d", x, y, len(sq))
+			}
+			for i, piece := range sq {
+				if piece.Color() != White && piece.Color() != Black {
+					return nil, fmt.Errorf("bad piece at (%d,%d): %#x", x, y, byte(piece))
+				}
+				if i > 0 && piece.Kind() != Flat {
+					return nil, fmt.Errorf("%s below the top of the stack at (%d,%d)", piece, x, y)
+				}
+			}
+		}
+	}
+	return FromSquares(b.cfg, b.board, b.move)
+}
+
+// checkReserves verifies that `board` does not use more stones or
+// capstones of either color than the configuration provides.
+func (g Config) checkReserves(board [][]Square) error {
+	g.setDefaults()
+	var stones, caps [2]int
+	for _, row := range board {
+		for _, sq := range row {
+			for _, piece := range sq {
+				c := 0
+				if piece.Color() == Black {
+					c = 1
+				}
+				if piece.Kind() == Capstone {
+					caps[c]++
+				} else {
+					stones[c]++
+				}
+			}
+		}
+	}
+	for c, color := range []Color{White, Black} {
+		if stones[c] > g.Pieces {
+			return fmt.Errorf("too many %s stones: %d > %d", color, stones[c], g.Pieces)
+		}
+		if caps[c] > g.caps() {
+			return fmt.Errorf("too many %s capstones: %d > %d", color, caps[c], g.caps())
+		}
+	}
+	return nil
+}
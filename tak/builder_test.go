@@ -0,0 +1,58 @@
+package tak
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	wf := MakePiece(White, Flat)
+	bf := MakePiece(Black, Flat)
+	wc := MakePiece(White, Capstone)
+	p, e := NewBuilder(Config{Size: 5}).
+		Set(0, 0, Square{wf}).
+		Set(2, 3, Square{wc, bf, wf}).
+		Set(4, 4, Square{MakePiece(Black, Standing)}).
+		Turn(9, Black).
+		Build()
+	if e != nil {
+		t.Fatal("build:", e)
+	}
+	if p.MoveNumber() != 17 || p.ToMove() != Black {
+		t.Errorf("move=%d toMove=%s", p.MoveNumber(), p.ToMove())
+	}
+	if sq := p.At(2, 3); !reflect.DeepEqual(sq, Square{wc, bf, wf}) {
+		t.Errorf("(2,3)=%v", sq)
+	}
+	if p.WhiteStones() != 19 || p.BlackStones() != 19 {
+		t.Errorf("stones: W:%d B:%d", p.WhiteStones(), p.BlackStones())
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	wf := MakePiece(White, Flat)
+	wc := MakePiece(White, Capstone)
+	tall := make(Square, 11)
+	for i := range tall {
+		tall[i] = wf
+	}
+	cases := []struct {
+		name string
+		b    *Builder
+	}{
+		{"size", NewBuilder(Config{Size: 9})},
+		{"off board", NewBuilder(Config{Size: 5}).Set(5, 0, Square{wf})},
+		{"bad turn", NewBuilder(Config{Size: 5}).Turn(0, White)},
+		{"no color", NewBuilder(Config{Size: 5}).Turn(3, NoColor)},
+		{"buried cap", NewBuilder(Config{Size: 5}).Set(0, 0, Square{wf, wc})},
+		{"bad piece", NewBuilder(Config{Size: 5}).Set(0, 0, Square{Piece(Flat)})},
+		{"two caps", NewBuilder(Config{Size: 5}).
+			Set(0, 0, Square{wc}).Set(1, 0, Square{wc})},
+		{"too many stones", NewBuilder(Config{Size: 3}).Set(0, 0, tall)},
+	}
+	for _, tc := range cases {
+		if _, e := tc.b.Build(); e == nil {
+			t.Errorf("%s: Build() did not fail", tc.name)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package tak
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/nelhage/taktician/bitboard"
+)
+
+// binaryVersion begins the binary encoding of a Position.
+const binaryVersion = 1
+
+// ErrShortBinary is returned by UnmarshalBinary when its input ends
+// before the position it encodes.
+var ErrShortBinary = errors.New("binary position truncated")
+
+// MarshalBinary encodes p compactly. The encoding holds the
+// configuration, the move number, each player's reserves, and the
+// occupied squares as a bitmask followed by each of their stacks: the
+// top piece, the height, and then a bit per stone beneath the top,
+// set for Black's, packed into bytes.
+func (p *Position) MarshalBinary() ([]byte, error) {
+	size := p.Size()
+	out := make([]byte, 0, 16+(size*size+7)/8+3*bitboard.Popcount(p.White|p.Black))
+	var buf [binary.MaxVarintLen64]byte
+	out = append(out, binaryVersion, byte(size), byte(p.cfg.Pieces), byte(int8(p.cfg.Capstones)))
+	out = append(out, buf[:binary.PutUvarint(buf[:], uint64(p.move))]...)
+	out = append(out, p.whiteStones, p.whiteCaps, p.blackStones, p.blackCaps)
+
+	occupied := p.White | p.Black
+	for i := 0; i < size*size; i += 8 {
+		out = append(out, byte(occupied>>uint(i)))
+	}
+	for i := 0; i < size*size; i++ {
+		if occupied&(1<<uint(i)) == 0 {
+			continue
+		}
+		x, y := Coords(i, size)
+		out = append(out, byte(p.Top(x, y)))
+		h := int(p.Height[i])
+		out = append(out, buf[:binary.PutUvarint(buf[:], uint64(h))]...)
+		for j := 0; j < h-1; j += 8 {
+			out = append(out, byte(p.Stacks[i]>>uint(j)))
+		}
+		if r := (h - 1) % 8; r != 0 {
+			out[len(out)-1] &= byte(1<<uint(r) - 1)
+		}
+	}
+	return out, nil
+}
+
+// UnmarshalBinary sets p to the position encoded by MarshalBinary in
+// data. It returns an error if data is not a valid encoding, including
+// if the reserves it records do not match its board.
+func (p *Position) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return ErrShortBinary
+	}
+	if data[0] != binaryVersion {
+		return fmt.Errorf("unknown binary position version %d", data[0])
+	}
+	cfg := Config{
+		Size:      int(data[1]),
+		Pieces:    int(data[2]),
+		Capstones: int(int8(data[3])),
+	}
+	if cfg.Size < MinSize || cfg.Size > MaxSize {
+		return fmt.Errorf("unsupported board size: %d", cfg.Size)
+	}
+	data = data[4:]
+	move, n := binary.Uvarint(data)
+	if n <= 0 {
+		return ErrShortBinary
+	}
+	data = data[n:]
+	if len(data) < 4 {
+		return ErrShortBinary
+	}
+	var reserves [4]byte
+	copy(reserves[:], data)
+	data = data[4:]
+
+	cells := cfg.Size * cfg.Size
+	if len(data) < (cells+7)/8 {
+		return ErrShortBinary
+	}
+	var occupied uint64
+	for i := 0; i < cells; i += 8 {
+		occupied |= uint64(data[0]) << uint(i)
+		data = data[1:]
+	}
+	if occupied>>uint(cells) != 0 {
+		return errors.New("binary position occupies squares off the board")
+	}
+
+	board := make([][]Square, cfg.Size)
+	for y := range board {
+		board[y] = make([]Square, cfg.Size)
+	}
+	for i := 0; i < cells; i++ {
+		if occupied&(1<<uint(i)) == 0 {
+			continue
+		}
+		x, y := Coords(i, cfg.Size)
+		if len(data) < 1 {
+			return ErrShortBinary
+		}
+		top := Piece(data[0])
+		if (top.Color() != White && top.Color() != Black) ||
+			top.Kind() == 0 || byte(top)&^(colorMask|typeMask) != 0 {
+			return fmt.Errorf("bad piece at (%d,%d): %#x", x, y, byte(top))
+		}
+		h, n := binary.Uvarint(data[1:])
+		if n <= 0 {
+			return ErrShortBinary
+		}
+		if h < 1 || h > maxHeight {
+			return fmt.Errorf("bad height at (%d,%d): %d", x, y, h)
+		}
+		data = data[1+n:]
+		nb := (int(h) - 1 + 7) / 8
+		if len(data) < nb {
+			return ErrShortBinary
+		}
+		sq := make(Square, h)
+		sq[0] = top
+		for j := 1; j < int(h); j++ {
+			if data[(j-1)/8]&(1<<uint((j-1)%8)) != 0 {
+				sq[j] = MakePiece(Black, Flat)
+			} else {
+				sq[j] = MakePiece(White, Flat)
+			}
+		}
+		data = data[nb:]
+		board[y][x] = sq
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%d trailing bytes after binary position", len(data))
+	}
+
+	out, e := FromSquares(cfg, board, int(move))
+	if e != nil {
+		return e
+	}
+	if got := [4]byte{out.whiteStones, out.whiteCaps, out.blackStones, out.blackCaps}; got != reserves {
+		return fmt.Errorf("binary position reserves %v do not match its board, which leaves %v",
+			reserves, got)
+	}
+	*p = *out
+	return nil
+}
@@ -0,0 +1,50 @@
+package tak
+
+import "testing"
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	p := New(Config{Size: 5})
+	for _, m := range []Move{
+		{X: 0, Y: 0, Type: PlaceFlat},
+		{X: 1, Y: 0, Type: PlaceFlat},
+		{X: 1, Y: 0, Type: SlideLeft, Slides: []byte{1}},
+		{X: 2, Y: 2, Type: PlaceCapstone},
+	} {
+		var e error
+		if p, e = p.Move(&m); e != nil {
+			t.Fatalf("move %#v: %v", m, e)
+		}
+	}
+	good, e := p.MarshalBinary()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	edit := func(f func(bs []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"version", edit(func(bs []byte) []byte { bs[0] = 9; return bs })},
+		{"size", edit(func(bs []byte) []byte { bs[1] = 9; return bs })},
+		{"reserves", edit(func(bs []byte) []byte { bs[5]--; return bs })},
+		{"off board", edit(func(bs []byte) []byte { bs[12] |= 0x80; return bs })},
+		{"piece", edit(func(bs []byte) []byte { bs[13] = 0xc1; return bs })},
+		{"trailing", edit(func(bs []byte) []byte { return append(bs, 0) })},
+	}
+	for i := 1; i < len(good); i++ {
+		cases = append(cases, struct {
+			name string
+			data []byte
+		}{"truncated", good[:i]})
+	}
+	for _, tc := range cases {
+		var q Position
+		if e := q.UnmarshalBinary(tc.data); e == nil {
+			t.Errorf("%s: UnmarshalBinary(%x) succeeded", tc.name, tc.data)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package tak
+
+import "github.com/nelhage/taktician/bitboard"
+
+// MaxEndgameEmpty is the largest number of empty squares for which
+// SolveFlatEndgame will search.
+const MaxEndgameEmpty = 10
+
+// SolveFlatEndgame determines the result of the game with perfect
+// play, in positions where no road can be completed by placing
+// stones on the remaining empty squares. It assumes that, from here
+// on, both players only place stones, and searches every way of
+// filling the board.
+//
+// SolveFlatEndgame returns known=false if a road is still possible,
+// or if there are more than MaxEndgameEmpty empty squares. Otherwise
+// winner is the player who wins on flats, or NoColor for a draw.
+func SolveFlatEndgame(p *Position) (known bool, winner Color) {
+	if over, w := p.GameOver(); over {
+		return true, w
+	}
+	if p.move < 2 {
+		return false, NoColor
+	}
+	c := &p.cfg.c
+	empty := c.Mask &^ (p.White | p.Black)
+	if bitboard.Popcount(empty) > MaxEndgameEmpty {
+		return false, NoColor
+	}
+	for _, road := range []uint64{p.RoadMask(White), p.RoadMask(Black)} {
+		if bitboard.HasRoad(c, road|empty) {
+			return false, NoColor
+		}
+	}
+
+	// Since no road is possible, which square a stone is placed
+	// on does not matter; only how many squares are left, what
+	// is in each reserve, and the difference in flats.
+	wf, bf := p.FlatCount()
+	s := flatEndgame{
+		empty:  bitboard.Popcount(empty),
+		stones: [2]int{int(p.whiteStones), int(p.blackStones)},
+		caps:   [2]int{int(p.whiteCaps), int(p.blackCaps)},
+	}
+	toMove := 0
+	if p.ToMove() == Black {
+		toMove = 1
+	}
+	v := s.solve(toMove, wf-bf)
+	switch {
+	case v > 0:
+		return true, White
+	case v < 0:
+		return true, Black
+	default:
+		return true, NoColor
+	}
+}
+
+type flatEndgame struct {
+	empty  int
+	stones [2]int
+	caps   [2]int
+}
+
+// solve returns the sign of the final flat difference, white minus
+// black, with perfect play and player `c` (0 for white) to move.
+func (s *flatEndgame) solve(c int, diff int) int {
+	if s.empty == 0 ||
+		s.stones[0]+s.caps[0] == 0 ||
+		s.stones[1]+s.caps[1] == 0 {
+		switch {
+		case diff > 0:
+			return 1
+		case diff < 0:
+			return -1
+		}
+		return 0
+	}
+	sign := 1
+	if c == 1 {
+		sign = -1
+	}
+	best := -2
+	try := func(d int) {
+		s.empty--
+		v := sign * s.solve(1-c, diff+d)
+		s.empty++
+		if v > best {
+			best = v
+		}
+	}
+	if s.stones[c] > 0 {
+		s.stones[c]--
+		try(sign)
+		// A standing stone fills a square without adding a
+		// flat.
+		try(0)
+		s.stones[c]++
+	}
+	if s.caps[c] > 0 {
+		s.caps[c]--
+		try(0)
+		s.caps[c]++
+	}
+	return sign * best
+}
+
+// NearZugzwang reports whether the player to move in p might prefer
+// not to move at all. Tak has no passing, and while stones remain to
+// be placed on open squares a placement almost always helps the
+// player who makes it; but near the end of the game, when the board
+// or either reserve is nearly exhausted, each move brings the flat
+// count closer to deciding the game, and shuffling stacks around
+// instead may be forced or best. Search techniques that assume
+// moving is better than passing, such as null-move pruning, should
+// not be applied when NearZugzwang is true.
+//
+// NearZugzwang is deliberately conservative: it is true whenever the
+// board has no more empty squares than its size, either player has no
+// more pieces in reserve than the board's size, or the opening swap
+// is still active.
+func (p *Position) NearZugzwang() bool {
+	if p.move < 2 {
+		return true
+	}
+	size := p.cfg.Size
+	empty := bitboard.Popcount(p.cfg.c.Mask &^ (p.White | p.Black))
+	return empty <= size ||
+		int(p.whiteStones)+int(p.whiteCaps) <= size ||
+		int(p.blackStones)+int(p.blackCaps) <= size
+}
+
+// PassToAllocated stores in next, as MoveToAllocated does, the
+// position reached if the player to move in p could pass: the same
+// board, with the other player to move. It is not a legal move, but
+// is useful to search algorithms such as null-move pruning. If next
+// is nil, a new position is allocated.
+func (p *Position) PassToAllocated(next *Position) *Position {
+	if next == nil {
+		next = alloc(p)
+	} else {
+		copyPosition(p, next)
+	}
+	if p.analyzed {
+		next.inheritAnalysis(p)
+	}
+	next.move++
+	return next
+}
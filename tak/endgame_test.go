@@ -0,0 +1,123 @@
+package tak
+
+import "testing"
+
+// endgamePosition returns a 5x5 position covered with walls, except
+// for the given empty squares and flats, in which no road is
+// possible.
+func endgamePosition(empty [][2]int, white, black [][2]int) *Position {
+	p := New(Config{Size: 5})
+	p.move = 40
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			c := White
+			if (x+y)%2 == 1 {
+				c = Black
+			}
+			set(p, x, y, Square{MakePiece(c, Standing)})
+		}
+	}
+	for _, sq := range empty {
+		set(p, sq[0], sq[1], nil)
+	}
+	for _, sq := range white {
+		set(p, sq[0], sq[1], Square{MakePiece(White, Flat)})
+	}
+	for _, sq := range black {
+		set(p, sq[0], sq[1], Square{MakePiece(Black, Flat)})
+	}
+	return p
+}
+
+func TestSolveFlatEndgame(t *testing.T) {
+	diag := [][2]int{{0, 0}, {2, 2}, {4, 4}}
+	cases := []struct {
+		name         string
+		empty        [][2]int
+		white, black [][2]int
+		toMove       Color
+		whiteStones  int
+		known        bool
+		winner       Color
+	}{
+		{"last square", diag[:1], nil, nil, White, 10, true, White},
+		{"two squares", diag[:2], nil, nil, White, 10, true, NoColor},
+		{"two squares, behind", diag[:2], nil, [][2]int{{1, 0}}, White, 10, true, Black},
+		{"three squares, behind", diag, nil, [][2]int{{1, 0}}, White, 10, true, NoColor},
+		{"last stone", diag, nil, nil, White, 1, true, White},
+		{"black ahead, to move", diag[:2], nil, [][2]int{{1, 0}}, Black, 10, true, Black},
+		{"road possible", [][2]int{{0, 0}, {0, 1}, {0, 2}, {0, 3}, {0, 4}}, nil, nil, White, 10, false, NoColor},
+	}
+	for _, tc := range cases {
+		p := endgamePosition(tc.empty, tc.white, tc.black)
+		if tc.toMove == Black {
+			p.move++
+		}
+		p.whiteStones = byte(tc.whiteStones)
+		p.whiteCaps = 0
+		known, winner := SolveFlatEndgame(p)
+		if known != tc.known || winner != tc.winner {
+			t.Errorf("%s: SolveFlatEndgame=(%v, %s), want (%v, %s)",
+				tc.name, known, winner, tc.known, tc.winner)
+		}
+	}
+
+	var many [][2]int
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			if (x+y)%2 == 1 {
+				many = append(many, [2]int{x, y})
+			}
+		}
+	}
+	if known, _ := SolveFlatEndgame(endgamePosition(many, nil, nil)); known {
+		t.Errorf("%d empty squares: known", len(many))
+	}
+}
+
+func TestNearZugzwang(t *testing.T) {
+	p := New(Config{Size: 5})
+	if !p.NearZugzwang() {
+		t.Error("opening: not NearZugzwang")
+	}
+	p.move = 10
+	if p.NearZugzwang() {
+		t.Error("empty board: NearZugzwang")
+	}
+	p.blackStones = 4
+	if !p.NearZugzwang() {
+		t.Error("low reserve: not NearZugzwang")
+	}
+	p.blackStones = 21
+	full := endgamePosition([][2]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}}, nil, nil)
+	full.whiteStones, full.blackStones = 21, 21
+	if !full.NearZugzwang() {
+		t.Error("full board: not NearZugzwang")
+	}
+	set(full, 0, 1, nil)
+	if full.NearZugzwang() {
+		t.Error("six empty squares: NearZugzwang")
+	}
+}
+
+func TestPassToAllocated(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 10
+	set(p, 1, 2, Square{MakePiece(White, Flat), MakePiece(Black, Flat)})
+	p.Analysis()
+	before := snap(p)
+	next := p.PassToAllocated(nil)
+	if after := snap(p); !before.equal(&after) {
+		t.Error("passing mutated the position")
+	}
+	if next.ToMove() != Black || next.MoveNumber() != 11 || next.Hash() != p.Hash() {
+		t.Errorf("pass: to move=%v move=%d", next.ToMove(), next.MoveNumber())
+	}
+	next.move--
+	if !same(next, p) {
+		t.Error("pass changed the board")
+	}
+	if len(next.Analysis().WhiteGroups) != len(p.Analysis().WhiteGroups) {
+		t.Error("pass changed the groups")
+	}
+}
@@ -0,0 +1,202 @@
+package tak
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+var fuzzSeed = flag.Int64("fuzz-seed", 1, "seed for the random game tests")
+var fuzzGames = flag.Int("fuzz-games", 300, "random games per board size for TestRandomGames; the other random game tests play a third as many")
+
+// snapshot is the complete observable state of a Position.
+type snapshot struct {
+	White, Black, Standing, Caps uint64
+	Height                       []uint8
+	Stacks                       []uint64
+	hash                         uint64
+	move                         int
+	reserves                     [4]byte
+}
+
+func (s *snapshot) equal(o *snapshot) bool {
+	if s.White != o.White || s.Black != o.Black ||
+		s.Standing != o.Standing || s.Caps != o.Caps ||
+		s.hash != o.hash || s.move != o.move || s.reserves != o.reserves {
+		return false
+	}
+	for i := range s.Height {
+		if s.Height[i] != o.Height[i] || s.Stacks[i] != o.Stacks[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func snap(p *Position) snapshot {
+	s := snapshot{
+		White:    p.White,
+		Black:    p.Black,
+		Standing: p.Standing,
+		Caps:     p.Caps,
+		Height:   append([]uint8(nil), p.Height...),
+		Stacks:   make([]uint64, len(p.Stacks)),
+		hash:     p.hash,
+		move:     p.move,
+		reserves: [4]byte{p.whiteStones, p.whiteCaps, p.blackStones, p.blackCaps},
+	}
+	for i, h := range p.Height {
+		if h > 1 {
+			s.Stacks[i] = p.Stacks[i] & (1<<(h-1) - 1)
+		}
+	}
+	return s
+}
+
+func same(a, b *Position) bool {
+	sa, sb := snap(a), snap(b)
+	return sa.equal(&sb)
+}
+
+// rebuild constructs p from scratch from its squares.
+func rebuild(p *Position) (*Position, error) {
+	board := make([][]Square, p.Size())
+	for y := range board {
+		board[y] = make([]Square, p.Size())
+		for x := range board[y] {
+			board[y][x] = p.At(x, y)
+		}
+	}
+	return FromSquares(*p.cfg, board, p.move)
+}
+
+// gamePly identifies a position reached by playRandomGames, for
+// failure messages.
+type gamePly struct {
+	size, game, ply int
+}
+
+func (at gamePly) String() string {
+	return fmt.Sprintf("size=%d game=%d ply=%d", at.size, at.game, at.ply)
+}
+
+// playRandomGames plays `games` random games, of at most 200 plies,
+// at each board size, seeded by -fuzz-seed. It calls visit with each
+// position reached, including the last, and then, unless the game
+// is over, tries at least four random moves from it until one is
+// legal, calling child with each legal move and its result. The game
+// continues from the last of them.
+func playRandomGames(t *testing.T, games int,
+	visit func(at gamePly, p *Position),
+	child func(at gamePly, p *Position, m Move, child *Position)) {
+	r := rand.New(rand.NewSource(*fuzzSeed))
+	for size := MinSize; size <= MaxSize; size++ {
+		var moves []Move
+		for g := 0; g < games; g++ {
+			p := New(Config{Size: size})
+			for ply := 0; ply < 200; ply++ {
+				at := gamePly{size, g, ply}
+				if visit != nil {
+					visit(at, p)
+				}
+				if over, _ := p.GameOver(); over {
+					break
+				}
+				moves = p.AllMoves(moves[:0])
+				if len(moves) == 0 {
+					t.Fatalf("%s: no moves", at)
+				}
+				var next *Position
+				for k := 0; k < 4 || next == nil; k++ {
+					m := moves[r.Intn(len(moves))]
+					c, e := p.Move(&m)
+					if e == ErrNoStones {
+						// AllMoves does not check
+						// reserves
+						continue
+					}
+					if e != nil {
+						t.Fatalf("%s: generated illegal move %#v: %v", at, m, e)
+					}
+					if child != nil {
+						child(at, p, m, c)
+					}
+					next = c
+				}
+				p = next
+			}
+		}
+	}
+}
+
+// TestRandomGames plays random games at each board size. At every
+// ply it checks that making moves never changes the parent position,
+// so that keeping it is a faithful undo, and that each child matches
+// a position rebuilt from scratch from its squares, including the
+// incrementally maintained hash.
+func TestRandomGames(t *testing.T) {
+	var before snapshot
+	var scratch *Position
+	playRandomGames(t, *fuzzGames,
+		func(at gamePly, p *Position) {
+			before = snap(p)
+		},
+		func(at gamePly, p *Position, m Move, child *Position) {
+			if scratch == nil || scratch.Size() != p.Size() {
+				scratch = New(Config{Size: p.Size()})
+			}
+			reused, e := p.MoveToAllocated(&m, scratch)
+			if e != nil || !same(reused, child) {
+				t.Fatalf("%s: MoveToAllocated(%#v) differs from Move", at, m)
+			}
+			fresh, e := rebuild(child)
+			if e != nil {
+				t.Fatalf("%s: rebuild after %#v: %v", at, m, e)
+			}
+			if !same(fresh, child) {
+				t.Fatalf("%s: %#v:\n got=%+v\nwant=%+v", at, m, snap(child), snap(fresh))
+			}
+			if after := snap(p); !before.equal(&after) {
+				t.Fatalf("%s: moving mutated the position:\n before=%+v\n  after=%+v",
+					at, before, after)
+			}
+		})
+}
+
+// TestIncrementalAnalysis plays random games, analyzing each position
+// before moving, so that every child's groups are updated from its
+// parent's, with CheckAnalysis comparing them against a full
+// recomputation.
+func TestIncrementalAnalysis(t *testing.T) {
+	defer func(old bool) { CheckAnalysis = old }(CheckAnalysis)
+	CheckAnalysis = true
+	playRandomGames(t, *fuzzGames/3,
+		func(at gamePly, p *Position) {
+			p.Analysis()
+		},
+		func(at gamePly, p *Position, m Move, child *Position) {
+			if !child.inherited {
+				t.Fatalf("%s: %#v did not inherit analysis", at, m)
+			}
+			child.Analysis()
+		})
+}
+
+// TestBinaryRoundTrip checks that every position of random games
+// survives MarshalBinary and UnmarshalBinary unchanged, hash included.
+func TestBinaryRoundTrip(t *testing.T) {
+	playRandomGames(t, *fuzzGames/3, func(at gamePly, p *Position) {
+		bs, e := p.MarshalBinary()
+		if e != nil {
+			t.Fatalf("%s: marshal: %v", at, e)
+		}
+		var got Position
+		if e := got.UnmarshalBinary(bs); e != nil {
+			t.Fatalf("%s: unmarshal: %v", at, e)
+		}
+		if got.Size() != p.Size() || *got.cfg != *p.cfg || !same(&got, p) {
+			t.Fatalf("%s:\n got=%+v\nwant=%+v", at, snap(&got), snap(p))
+		}
+	}, nil)
+}
@@ -2,10 +2,15 @@ package tak
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/nelhage/taktician/bitboard"
 )
 
+// Config describes the board and reserves for a game. Pieces and
+// Capstones are the number of stones and of capstones each player
+// starts with; if zero, they default to the standard counts for the
+// size. A negative Capstones gives a game with no capstones.
 type Config struct {
 	Size      int
 	Pieces    int
@@ -14,23 +19,40 @@ type Config struct {
 	c bitboard.Constants
 }
 
+// MinSize and MaxSize bound the supported board sizes. Positions
+// are represented as 64-bit bitboards, so boards larger than 8x8
+// cannot be represented.
+const (
+	MinSize = 3
+	MaxSize = 8
+)
+
+// MaxPieces and MaxCapstones bound the reserves a Config may give
+// each player. Reserves are kept in bytes, and the binary encoding
+// stores Capstones in a signed byte.
+const (
+	MaxPieces    = 255
+	MaxCapstones = 127
+)
+
 var defaultPieces = []int{0, 0, 0, 10, 15, 21, 30, 40, 50}
 var defaultCaps = []int{0, 0, 0, 0, 0, 1, 1, 1, 2}
 
+// New returns the initial position for a game with the specified
+// configuration. It panics if g.Size is not between MinSize and
+// MaxSize, or if g's reserves exceed MaxPieces or MaxCapstones.
 func New(g Config) *Position {
-	if g.Pieces == 0 {
-		g.Pieces = defaultPieces[g.Size]
-	}
-	if g.Capstones == 0 {
-		g.Capstones = defaultCaps[g.Size]
+	if e := g.check(); e != nil {
+		panic("tak.New: " + e.Error())
 	}
+	g.setDefaults()
 	g.c = bitboard.Precompute(uint(g.Size))
 	p := alloc(&Position{
 		cfg:         &g,
 		whiteStones: byte(g.Pieces),
-		whiteCaps:   byte(g.Capstones),
+		whiteCaps:   byte(g.caps()),
 		blackStones: byte(g.Pieces),
-		blackCaps:   byte(g.Capstones),
+		blackCaps:   byte(g.caps()),
 		move:        0,
 
 		hash: fnvBasis,
@@ -38,6 +60,39 @@ func New(g Config) *Position {
 	return p
 }
 
+// check returns an error if g's size or reserves are out of range.
+func (g *Config) check() error {
+	switch {
+	case g.Size < MinSize || g.Size > MaxSize:
+		return fmt.Errorf("unsupported board size: %d", g.Size)
+	case g.Pieces < 0 || g.Pieces > MaxPieces:
+		return fmt.Errorf("unsupported number of stones: %d", g.Pieces)
+	case g.Capstones > MaxCapstones:
+		return fmt.Errorf("unsupported number of capstones: %d", g.Capstones)
+	}
+	return nil
+}
+
+func (g *Config) setDefaults() {
+	if g.Pieces == 0 {
+		g.Pieces = defaultPieces[g.Size]
+	}
+	switch {
+	case g.Capstones == 0:
+		g.Capstones = defaultCaps[g.Size]
+	case g.Capstones < 0:
+		g.Capstones = -1
+	}
+}
+
+// caps returns the number of capstones each player starts with.
+func (g *Config) caps() int {
+	if g.Capstones < 0 {
+		return 0
+	}
+	return g.Capstones
+}
+
 func (p *Position) Clone() *Position {
 	return alloc(p)
 }
@@ -60,7 +115,15 @@ type Position struct {
 	Height   []uint8
 	Stacks   []uint64
 
-	analysis Analysis
+	// analysis is computed lazily, and is only valid if
+	// analyzed is set. If inherited is set instead, it holds the
+	// groups of the position this one was reached from, whose
+	// road squares are in parentRoads, to be updated rather than
+	// recomputed.
+	analysis    Analysis
+	analyzed    bool
+	inherited   bool
+	parentRoads [2]uint64
 
 	hash uint64
 }
@@ -72,8 +135,16 @@ type Analysis struct {
 
 // FromSquares initializes a Position with the specified squares and
 // move number. `board` is a slice of rows, numbered from low to high,
-// each of which is a slice of positions.
+// each of which is a slice of positions. It returns an error if the
+// board uses more stones or capstones of either color than `cfg`
+// provides.
 func FromSquares(cfg Config, board [][]Square, move int) (*Position, error) {
+	if e := cfg.check(); e != nil {
+		return nil, e
+	}
+	if e := cfg.checkReserves(board); e != nil {
+		return nil, e
+	}
 	p := New(cfg)
 	p.move = move
 	for y := 0; y < p.Size(); y++ {
@@ -82,7 +153,7 @@ func FromSquares(cfg Config, board [][]Square, move int) (*Position, error) {
 			if len(sq) == 0 {
 				continue
 			}
-			i := uint(x + y*p.Size())
+			i := uint(SquareIndex(x, y, p.Size()))
 			switch sq[0].Color() {
 			case White:
 				p.White |= (1 << i)
@@ -119,16 +190,29 @@ func FromSquares(cfg Config, board [][]Square, move int) (*Position, error) {
 			p.hash ^= p.hashAt(i)
 		}
 	}
-	p.analyze()
 	return p, nil
 }
 
+// SquareIndex returns the index of the square (x, y) on a board of
+// the given size, counting along each row from a1: x + y*size. The
+// index is also the square's bit in a Position's bitboards, such as
+// White and Standing, and its index into Height and Stacks.
+func SquareIndex(x, y, size int) int {
+	return x + y*size
+}
+
+// Coords returns the square with index i, as returned by
+// SquareIndex, on a board of the given size.
+func Coords(i, size int) (x, y int) {
+	return i % size, i / size
+}
+
 func (p *Position) Size() int {
 	return p.cfg.Size
 }
 
 func (p *Position) At(x, y int) Square {
-	i := uint(x + y*p.Size())
+	i := uint(SquareIndex(x, y, p.Size()))
 	if (p.White|p.Black)&(1<<i) == 0 {
 		return nil
 	}
@@ -145,7 +229,7 @@ func (p *Position) At(x, y int) Square {
 }
 
 func (p *Position) Top(x, y int) Piece {
-	i := uint(x + y*p.Size())
+	i := uint(SquareIndex(x, y, p.Size()))
 	var c Color
 	var k Kind
 	switch {
@@ -168,11 +252,13 @@ func (p *Position) Top(x, y int) Piece {
 }
 
 func set(p *Position, x, y int, s Square) {
-	i := uint(y*p.cfg.Size + x)
+	i := uint(SquareIndex(x, y, p.cfg.Size))
 	p.White &= ^(1 << i)
 	p.Black &= ^(1 << i)
 	p.Standing &= ^(1 << i)
 	p.Caps &= ^(1 << i)
+	p.analyzed = false
+	p.inherited = false
 	if len(s) == 0 {
 		p.Height[i] = 0
 		return
@@ -207,18 +293,56 @@ func (p *Position) ToMove() Color {
 	return Black
 }
 
+// SwapActive reports whether the opening swap applies to the next
+// move. On each player's first turn, they place a flat of their
+// opponent's color, and may not place a standing stone or capstone.
+func (p *Position) SwapActive() bool {
+	return p.move < 2
+}
+
 func (p *Position) MoveNumber() int {
 	return p.move
 }
 
+// WhiteStones returns the number of flat stones White has left in
+// reserve, not counting capstones. Stones leave the reserve only
+// when placed; stones captured in a stack never return to it.
 func (p *Position) WhiteStones() int {
 	return int(p.whiteStones)
 }
 
+// BlackStones is WhiteStones for Black.
 func (p *Position) BlackStones() int {
 	return int(p.blackStones)
 }
 
+// WhiteCaps returns the number of capstones White has left in
+// reserve.
+func (p *Position) WhiteCaps() int {
+	return int(p.whiteCaps)
+}
+
+// BlackCaps is WhiteCaps for Black.
+func (p *Position) BlackCaps() int {
+	return int(p.blackCaps)
+}
+
+// Reserves returns the number of flat stones and capstones c has
+// left to place.
+func (p *Position) Reserves(c Color) (stones, caps int) {
+	switch c {
+	case White:
+		return int(p.whiteStones), int(p.whiteCaps)
+	case Black:
+		return int(p.blackStones), int(p.blackCaps)
+	}
+	return 0, 0
+}
+
+// GameOver reports whether the game has ended, and if so who won. A
+// road wins outright; otherwise, once the board is full or either
+// player has no pieces left, the player with more flats on top wins,
+// with NoColor indicating a draw on equal flats.
 func (p *Position) GameOver() (over bool, winner Color) {
 	if p, ok := p.hasRoad(); ok {
 		return true, p
@@ -233,31 +357,8 @@ func (p *Position) GameOver() (over bool, winner Color) {
 	return true, p.flatsWinner()
 }
 
-func (p *Position) roadAt(x, y int) (Color, bool) {
-	sq := p.At(x, y)
-	if len(sq) == 0 {
-		return White, false
-	}
-	return sq[0].Color(), sq[0].IsRoad()
-}
-
 func (p *Position) hasRoad() (Color, bool) {
-	white, black := false, false
-
-	for _, g := range p.analysis.WhiteGroups {
-		if ((g&p.cfg.c.T) != 0 && (g&p.cfg.c.B) != 0) ||
-			((g&p.cfg.c.L) != 0 && (g&p.cfg.c.R) != 0) {
-			white = true
-			break
-		}
-	}
-	for _, g := range p.analysis.BlackGroups {
-		if ((g&p.cfg.c.T) != 0 && (g&p.cfg.c.B) != 0) ||
-			((g&p.cfg.c.L) != 0 && (g&p.cfg.c.R) != 0) {
-			black = true
-			break
-		}
-	}
+	white, black := p.hasRoadFor(White), p.hasRoadFor(Black)
 
 	switch {
 	case white && black:
@@ -275,28 +376,189 @@ func (p *Position) hasRoad() (Color, bool) {
 
 }
 
+// RoadMask returns the squares that count toward a road for c: those
+// topped by c's flats and capstones. c's standing stones are not
+// road squares, and since they are not the opponent's either, they
+// block both players' roads.
+func (p *Position) RoadMask(c Color) uint64 {
+	switch c {
+	case White:
+		return p.White &^ p.Standing
+	case Black:
+		return p.Black &^ p.Standing
+	}
+	return 0
+}
+
+// hasRoadFor reports whether c has a road.
+func (p *Position) hasRoadFor(c Color) bool {
+	return bitboard.HasRoad(&p.cfg.c, p.RoadMask(c))
+}
+
+// Analysis returns the road groups of the position, computing them
+// on first use. Since it may update the position's cache, a Position
+// must not be used concurrently from multiple goroutines.
 func (p *Position) Analysis() *Analysis {
+	if !p.analyzed {
+		p.analyze()
+	}
 	return &p.analysis
 }
 
+// CheckAnalysis, if set, makes Analysis check each group analysis it
+// derives from that of an earlier position against a full
+// recomputation, and panic if they differ. It is meant for debugging
+// and for tests, and slows analysis down considerably.
+var CheckAnalysis = false
+
 func (p *Position) analyze() {
-	wr := p.White &^ p.Standing
-	br := p.Black &^ p.Standing
+	wr, br := p.RoadMask(White), p.RoadMask(Black)
+	if p.inherited {
+		p.reanalyze(wr, br)
+		return
+	}
 	alloc := p.analysis.WhiteGroups
 	p.analysis.WhiteGroups = bitboard.FloodGroups(&p.cfg.c, wr, alloc)
 	alloc = p.analysis.WhiteGroups
 	alloc = alloc[len(alloc):len(alloc):cap(alloc)]
 	p.analysis.BlackGroups = bitboard.FloodGroups(&p.cfg.c, br, alloc)
+	p.analyzed = true
+}
+
+// inheritAnalysis copies the groups of parent, which must be
+// analyzed, for p, reached from it by a move, to update.
+func (p *Position) inheritAnalysis(parent *Position) {
+	gs := append(p.analysis.WhiteGroups[:0], parent.analysis.WhiteGroups...)
+	p.analysis.WhiteGroups = gs
+	p.analysis.BlackGroups = append(gs[len(gs):len(gs):cap(gs)], parent.analysis.BlackGroups...)
+	p.parentRoads = [2]uint64{parent.RoadMask(White), parent.RoadMask(Black)}
+	p.inherited = true
+}
+
+// reanalyze updates the groups inherited from the parent position
+// for the road squares wr and br. A move changes only a few squares,
+// and a group that neither includes nor borders a changed square
+// is still a group; only the rest of the road squares need to be
+// flooded.
+func (p *Position) reanalyze(wr, br uint64) {
+	c := &p.cfg.c
+	var wbuf, bbuf [32]uint64
+	white := updateGroups(c, p.analysis.WhiteGroups, p.parentRoads[0], wr, wbuf[:0])
+	black := updateGroups(c, p.analysis.BlackGroups, p.parentRoads[1], br, bbuf[:0])
+	gs := append(p.analysis.WhiteGroups[:0], white...)
+	p.analysis.WhiteGroups = gs
+	p.analysis.BlackGroups = append(gs[len(gs):len(gs):cap(gs)], black...)
+	p.inherited = false
+	p.analyzed = true
+
+	if CheckAnalysis {
+		var full Position
+		full = *p
+		full.analysis = Analysis{}
+		full.analyzed = false
+		full.analyze()
+		if !sameGroups(full.analysis.WhiteGroups, p.analysis.WhiteGroups) ||
+			!sameGroups(full.analysis.BlackGroups, p.analysis.BlackGroups) {
+			panic(fmt.Sprintf("reanalyze: groups %x/%x, want %x/%x",
+				p.analysis.WhiteGroups, p.analysis.BlackGroups,
+				full.analysis.WhiteGroups, full.analysis.BlackGroups))
+		}
+	}
 }
 
-func (p *Position) countFlats() (w int, b int) {
+// updateGroups appends to out the groups of `after` given those of
+// `before`, in the order FloodGroups would find them, by their lowest
+// square.
+func updateGroups(c *bitboard.Constants, groups []uint64, before, after uint64, out []uint64) []uint64 {
+	changed := before ^ after
+	var kept uint64
+	for _, g := range groups {
+		if g&changed == 0 && bitboard.Grow(c, after, g)&changed == 0 {
+			kept |= g
+		}
+	}
+	var buf [32]uint64
+	fresh := bitboard.FloodGroups(c, after&^kept, buf[:0])
+	for _, g := range groups {
+		if g&kept == 0 {
+			continue
+		}
+		for len(fresh) > 0 && fresh[0]&-fresh[0] < g&-g {
+			out = append(out, fresh[0])
+			fresh = fresh[1:]
+		}
+		out = append(out, g)
+	}
+	return append(out, fresh...)
+}
+
+func sameGroups(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FlatCount returns the number of flats on top of a stack, as
+// counted for a flat win, for White and for Black.
+func (p *Position) FlatCount() (w int, b int) {
 	w = bitboard.Popcount(p.White &^ (p.Standing | p.Caps))
 	b = bitboard.Popcount(p.Black &^ (p.Standing | p.Caps))
 	return w, b
 }
 
+// MaterialCount is a count of one player's pieces on the board,
+// unweighted by any evaluation.
+type MaterialCount struct {
+	// Flats, Walls and Capstones count the stacks the player
+	// controls with each kind of piece.
+	Flats, Walls, Capstones int
+	// Stones counts the player's stones buried beneath the tops
+	// of stacks.
+	Stones int
+	// Captured counts the stones beneath the tops of the stacks
+	// the player controls, of either color, up to the carry limit
+	// of each stack, so counting only those that could be carried
+	// off at once.
+	Captured int
+}
+
+// Material counts the pieces on the board for White and for Black.
+func (p *Position) Material() (white, black MaterialCount) {
+	white.Flats, black.Flats = p.FlatCount()
+	white.Walls = bitboard.Popcount(p.White & p.Standing)
+	black.Walls = bitboard.Popcount(p.Black & p.Standing)
+	white.Capstones = bitboard.Popcount(p.White & p.Caps)
+	black.Capstones = bitboard.Popcount(p.Black & p.Caps)
+
+	for i, h := range p.Height {
+		if h <= 1 {
+			continue
+		}
+		bs := bitboard.Popcount(p.Stacks[i] & ((1 << (h - 1)) - 1))
+		white.Stones += int(h) - bs - 1
+		black.Stones += bs
+
+		captured := int(h - 1)
+		if captured > p.Size()-1 {
+			captured = p.Size() - 1
+		}
+		if p.White&(1<<uint(i)) != 0 {
+			white.Captured += captured
+		} else {
+			black.Captured += captured
+		}
+	}
+	return white, black
+}
+
 func (p *Position) flatsWinner() Color {
-	cw, cb := p.countFlats()
+	cw, cb := p.FlatCount()
 	if cw > cb {
 		return White
 	}
@@ -327,7 +589,7 @@ func (p *Position) WinDetails() WinDetails {
 	var d WinDetails
 	d.Over = over
 	d.Winner = c
-	d.WhiteFlats, d.BlackFlats = p.countFlats()
+	d.WhiteFlats, d.BlackFlats = p.FlatCount()
 	if _, ok := p.hasRoad(); ok {
 		d.Reason = RoadWin
 	} else {
@@ -1,6 +1,11 @@
 package tak
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nelhage/taktician/bitboard"
+)
 
 func TestHasRoad(t *testing.T) {
 	p := New(Config{Size: 5})
@@ -72,6 +77,62 @@ func TestHasRoadRegression(t *testing.T) {
 	}
 }
 
+// TestWallBreaksRoad checks that a standing stone is never part of a
+// road, for its owner or the opponent, and that a capstone is.
+func TestWallBreaksRoad(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 11
+	var flats uint64
+	for x := 0; x < 5; x++ {
+		if x == 3 {
+			set(p, x, 0, Square{MakePiece(White, Standing)})
+			continue
+		}
+		set(p, x, 0, Square{MakePiece(White, Flat)})
+		flats |= 1 << uint(x)
+	}
+	if m := p.RoadMask(White); m != flats || m&p.Standing != 0 {
+		t.Errorf("RoadMask(White)=%x, want the flats %x", m, flats)
+	}
+	if m := p.RoadMask(Black); m != 0 {
+		t.Errorf("RoadMask(Black)=%x, want none", m)
+	}
+	if over, winner := p.GameOver(); over {
+		t.Errorf("wall in the row: over, winner=%s", winner)
+	}
+	if gs := p.Analysis().WhiteGroups; len(gs) != 1 || gs[0] != 7 {
+		t.Errorf("wall in the row: groups=%x, want a1-c1", gs)
+	}
+	if p.HasRoadThreat(White) || len(p.RoadCompletions(White)) != 0 {
+		t.Errorf("wall in the row: threat=%v completions=%v",
+			p.HasRoadThreat(White), p.RoadCompletions(White))
+	}
+
+	set(p, 3, 0, Square{MakePiece(White, Capstone)})
+	if over, winner := p.GameOver(); !over || winner != White {
+		t.Errorf("capstone in the row: over=%v winner=%s", over, winner)
+	}
+	set(p, 3, 0, Square{MakePiece(White, Standing)})
+
+	// either player's wall blocks Black's road
+	for x := 0; x < 5; x++ {
+		set(p, x, 2, Square{MakePiece(Black, Flat)})
+	}
+	for _, c := range []Color{White, Black} {
+		set(p, 2, 2, Square{MakePiece(c, Standing)})
+		if p.RoadMask(Black)&p.Standing != 0 {
+			t.Errorf("%s wall: RoadMask(Black) includes a wall", c)
+		}
+		if over, winner := p.GameOver(); over {
+			t.Errorf("%s wall in Black's row: over, winner=%s", c, winner)
+		}
+	}
+	set(p, 2, 2, Square{MakePiece(Black, Flat)})
+	if over, winner := p.GameOver(); !over || winner != Black {
+		t.Errorf("flats: over=%v winner=%s", over, winner)
+	}
+}
+
 func TestFlatsWinner(t *testing.T) {
 	p := New(Config{Size: 5})
 	set(p, 0, 0, Square{MakePiece(White, Flat)})
@@ -104,6 +165,26 @@ func TestFlatsWinnerCapLeft(t *testing.T) {
 	}
 }
 
+func TestMaterial(t *testing.T) {
+	p := New(Config{Size: 3})
+	wf, bf := MakePiece(White, Flat), MakePiece(Black, Flat)
+	set(p, 0, 0, Square{wf})
+	set(p, 1, 0, Square{MakePiece(White, Standing), bf, wf})
+	set(p, 2, 0, Square{MakePiece(Black, Capstone), wf, wf, bf, wf})
+	set(p, 0, 1, Square{bf, bf})
+	set(p, 1, 1, Square{MakePiece(Black, Standing)})
+
+	w, b := p.Material()
+	if want := (MaterialCount{Flats: 1, Walls: 1, Stones: 4, Captured: 2}); w != want {
+		t.Errorf("white=%+v, want %+v", w, want)
+	}
+	// a carry of three on a 3x3 board takes only two of the
+	// four stones beneath the capstone
+	if want := (MaterialCount{Flats: 1, Walls: 1, Capstones: 1, Stones: 3, Captured: 3}); b != want {
+		t.Errorf("black=%+v, want %+v", b, want)
+	}
+}
+
 func BenchmarkEmptyHasRoad(b *testing.B) {
 	p := New(Config{Size: 5})
 	for i := 0; i < b.N; i++ {
@@ -147,6 +228,37 @@ func BenchmarkHasRoadWindy(b *testing.B) {
 	}
 }
 
+func BenchmarkAnalyzeAfterMove(b *testing.B) {
+	p := New(Config{Size: 6})
+	for i := 0; i < p.Size(); i++ {
+		for j := 0; j < p.Size()-1; j++ {
+			c := White
+			if (i*j)&1 != 0 {
+				c = Black
+			}
+			if (i+2*j)%3 != 0 {
+				set(p, i, j, Square{MakePiece(c, Flat)})
+			}
+		}
+	}
+	m := Move{X: 2, Y: 5, Type: PlaceFlat}
+	next := New(Config{Size: 6})
+	b.Run("full", func(b *testing.B) {
+		p.analyzed = false
+		for i := 0; i < b.N; i++ {
+			p.MoveToAllocated(&m, next)
+			next.Analysis()
+		}
+	})
+	b.Run("incremental", func(b *testing.B) {
+		p.Analysis()
+		for i := 0; i < b.N; i++ {
+			p.MoveToAllocated(&m, next)
+			next.Analysis()
+		}
+	})
+}
+
 func moves(ms []Move) *Position {
 	p := New(Config{Size: 5})
 	for _, m := range ms {
@@ -238,3 +350,173 @@ func TestHash(t *testing.T) {
 		t.Fatalf("hash fail when swapping flat/standing")
 	}
 }
+
+func TestBoardSizeLimits(t *testing.T) {
+	for _, size := range []int{MinSize, 5, MaxSize} {
+		if p := New(Config{Size: size}); p.Size() != size {
+			t.Errorf("New(%d).Size()=%d", size, p.Size())
+		}
+		if p := Alloc(size); p.Size() != size || len(p.Height) != size*size {
+			t.Errorf("Alloc(%d): size=%d len=%d", size, p.Size(), len(p.Height))
+		}
+	}
+	for _, size := range []int{2, MaxSize + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New(%d) did not panic", size)
+				}
+			}()
+			New(Config{Size: size})
+		}()
+		board := make([][]Square, size)
+		for i := range board {
+			board[i] = make([]Square, size)
+		}
+		if _, e := FromSquares(Config{Size: size}, board, 0); e == nil {
+			t.Errorf("FromSquares(%d) did not fail", size)
+		}
+	}
+}
+
+func TestReserveLimits(t *testing.T) {
+	p := New(Config{Size: 5, Pieces: MaxPieces, Capstones: MaxCapstones})
+	if s, c := p.Reserves(White); s != MaxPieces || c != MaxCapstones {
+		t.Errorf("reserves (%d, %d), want (%d, %d)", s, c, MaxPieces, MaxCapstones)
+	}
+	// any negative Capstones means none, and survives encoding
+	data, e := New(Config{Size: 5, Capstones: -200}).MarshalBinary()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if c := int8(data[3]); c >= 0 {
+		t.Errorf("no capstones: encoded as %d", c)
+	}
+	for _, cfg := range []Config{
+		{Size: 5, Pieces: MaxPieces + 1},
+		{Size: 5, Pieces: -1},
+		{Size: 5, Capstones: MaxCapstones + 1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New(%+v) did not panic", cfg)
+				}
+			}()
+			New(cfg)
+		}()
+		if _, e := NewBuilder(cfg).Build(); e == nil {
+			t.Errorf("Builder(%+v) did not fail", cfg)
+		}
+	}
+}
+
+func TestAnalysisCache(t *testing.T) {
+	p := moves([]Move{
+		Move{X: 0, Y: 0, Type: PlaceFlat},
+		Move{X: 4, Y: 4, Type: PlaceFlat},
+		Move{X: 1, Y: 0, Type: PlaceFlat},
+		Move{X: 0, Y: 1, Type: PlaceFlat},
+		Move{X: 3, Y: 4, Type: PlaceFlat},
+		Move{X: 0, Y: 2, Type: PlaceFlat},
+	})
+	want := fmt.Sprint(p.Analysis())
+	if got := fmt.Sprint(p.Clone().Analysis()); got != want {
+		t.Errorf("Clone().Analysis()=%s, want %s", got, want)
+	}
+	next, e := p.Move(&Move{X: 2, Y: 4, Type: PlaceFlat})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if got := fmt.Sprint(next.Analysis()); got == want {
+		t.Errorf("Analysis() not invalidated by Move: %s", got)
+	}
+	if got := fmt.Sprint(p.Analysis()); got != want {
+		t.Errorf("Analysis() changed after Move: %s, want %s", got, want)
+	}
+}
+
+func TestCustomReserves(t *testing.T) {
+	cfg := Config{Size: 5, Pieces: 3, Capstones: -1}
+	p := New(cfg)
+	if s, c := p.Reserves(White); s != 3 || c != 0 {
+		t.Fatalf("reserves: %d stones, %d caps", s, c)
+	}
+	moves := []Move{
+		{0, 0, PlaceFlat, nil},
+		{4, 4, PlaceFlat, nil},
+		{2, 2, PlaceFlat, nil},
+		{2, 3, PlaceStanding, nil},
+	}
+	for _, m := range moves {
+		for _, am := range p.AllMoves(nil) {
+			if am.Type == PlaceCapstone {
+				t.Fatalf("ply %d: capstone placement offered", p.MoveNumber())
+			}
+		}
+		if _, e := p.Move(&Move{1, 1, PlaceCapstone, nil}); e != ErrNoCapstone && e != ErrIllegalOpening {
+			t.Errorf("ply %d: capstone placement: err=%v", p.MoveNumber(), e)
+		}
+		var e error
+		if p, e = p.Move(&m); e != nil {
+			t.Fatalf("ply %d: %v", p.MoveNumber(), e)
+		}
+	}
+	if over, _ := p.GameOver(); over {
+		t.Fatal("game over with stones left")
+	}
+	// the transformed position keeps the configuration
+	if s, c := p.Transform(symFlipX).Reserves(Black); s != 1 || c != 0 {
+		t.Errorf("transformed reserves: %d stones, %d caps", s, c)
+	}
+	p, e := p.Move(&Move{3, 3, PlaceFlat, nil})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if over, winner := p.GameOver(); !over || winner != White {
+		t.Errorf("out of stones: GameOver()=(%v, %s)", over, winner)
+	}
+
+	if _, e := FromSquares(cfg, [][]Square{
+		{{MakePiece(White, Capstone)}, nil, nil, nil, nil},
+		{nil, nil, nil, nil, nil},
+		{nil, nil, nil, nil, nil},
+		{nil, nil, nil, nil, nil},
+		{nil, nil, nil, nil, nil},
+	}, 2); e == nil {
+		t.Errorf("capstone accepted without capstones")
+	}
+
+	// 4x4 has no capstones by default, but may be given one
+	if _, c := New(Config{Size: 4, Capstones: 1}).Reserves(White); c != 1 {
+		t.Errorf("4x4 with a capstone: %d caps", c)
+	}
+}
+
+func TestSquareIndex(t *testing.T) {
+	for size := MinSize; size <= MaxSize; size++ {
+		c := bitboard.Precompute(uint(size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				i := SquareIndex(x, y, size)
+				if cx, cy := Coords(i, size); cx != x || cy != y {
+					t.Fatalf("size=%d: Coords(SquareIndex(%d, %d))=(%d, %d)", size, x, y, cx, cy)
+				}
+				bit := uint64(1) << uint(i)
+				// the bottom row is rank 1, and the
+				// first column file a
+				if (y == 0) != (c.B&bit != 0) || (y == size-1) != (c.T&bit != 0) ||
+					(x == 0) != (c.R&bit != 0) || (x == size-1) != (c.L&bit != 0) {
+					t.Errorf("size=%d: (%d, %d) is on the wrong edges", size, x, y)
+				}
+
+				p := New(Config{Size: size})
+				set(p, x, y, Square{MakePiece(White, Flat), MakePiece(Black, Flat)})
+				if p.White != bit || p.Height[i] != 2 || p.Stacks[i] != 1 {
+					t.Errorf("size=%d: (%d, %d): White=%x Height=%d Stacks=%x",
+						size, x, y, p.White, p.Height[i], p.Stacks[i])
+				}
+			}
+		}
+	}
+}
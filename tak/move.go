@@ -1,6 +1,10 @@
 package tak
 
-import "errors"
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
 
 type MoveType byte
 
@@ -43,23 +47,139 @@ func (m *Move) Equal(rhs *Move) bool {
 	return true
 }
 
+// The errors returned by Validate and Move, one for each reason a
+// move can be illegal.
 var (
+	ErrBadMoveType    = errors.New("unknown move type")
+	ErrOffBoard       = errors.New("square is off the board")
 	ErrOccupied       = errors.New("position is occupied")
-	ErrIllegalSlide   = errors.New("illegal slide")
+	ErrNoStones       = errors.New("no stones left to place")
 	ErrNoCapstone     = errors.New("capstone has already been played")
 	ErrIllegalOpening = errors.New("illegal opening move")
+	ErrNotOwner       = errors.New("stack is not controlled by the player to move")
+	ErrDropZero       = errors.New("slide must drop at least one stone on each square")
+	ErrCarryLimit     = errors.New("carry exceeds the board size")
+	ErrStackHeight    = errors.New("carry exceeds the height of the stack")
+	ErrSlideOffBoard  = errors.New("slide goes off the edge of the board")
+	// ErrIllegalSlide is returned for a slide onto a capstone, or
+	// onto a standing stone other than by a lone capstone.
+	ErrIllegalSlide = errors.New("illegal slide")
 )
 
 func (p *Position) Move(m *Move) (*Position, error) {
 	return p.MoveToAllocated(m, nil)
 }
 
+// Validate reports whether m is legal in p, returning nil if it is,
+// and otherwise the Err* value describing why it is not. Move fails
+// with the same errors.
+func (p *Position) Validate(m *Move) error {
+	if m.X < 0 || m.X >= p.cfg.Size || m.Y < 0 || m.Y >= p.cfg.Size {
+		return ErrOffBoard
+	}
+	i := uint(SquareIndex(m.X, m.Y, p.cfg.Size))
+	switch m.Type {
+	case PlaceFlat, PlaceStanding, PlaceCapstone:
+		return p.validatePlace(m, i)
+	case SlideLeft, SlideRight, SlideUp, SlideDown:
+		return p.validateSlide(m, i)
+	}
+	return ErrBadMoveType
+}
+
+func (p *Position) validatePlace(m *Move, i uint) error {
+	if p.SwapActive() && m.Type != PlaceFlat {
+		return ErrIllegalOpening
+	}
+	if (p.White|p.Black)&(1<<i) != 0 {
+		return ErrOccupied
+	}
+	if m.Type == PlaceCapstone {
+		if _, caps := p.Reserves(p.ToMove()); caps == 0 {
+			return ErrNoCapstone
+		}
+		return nil
+	}
+	c := p.ToMove()
+	if p.SwapActive() {
+		c = c.Flip()
+	}
+	if stones, _ := p.Reserves(c); stones == 0 {
+		return ErrNoStones
+	}
+	return nil
+}
+
+func (p *Position) validateSlide(m *Move, i uint) error {
+	if p.SwapActive() {
+		return ErrIllegalOpening
+	}
+	mine := p.White
+	if p.ToMove() == Black {
+		mine = p.Black
+	}
+	if mine&(1<<i) == 0 {
+		return ErrNotOwner
+	}
+	ct := uint(0)
+	for _, c := range m.Slides {
+		if c == 0 {
+			return ErrDropZero
+		}
+		ct += uint(c)
+	}
+	switch {
+	case ct == 0:
+		return ErrDropZero
+	case ct > uint(p.cfg.Size):
+		return ErrCarryLimit
+	case ct > uint(p.Height[i]):
+		return ErrStackHeight
+	}
+	dx, dy := 0, 0
+	switch m.Type {
+	case SlideLeft:
+		dx = -1
+	case SlideRight:
+		dx = 1
+	case SlideUp:
+		dy = 1
+	case SlideDown:
+		dy = -1
+	}
+	x, y := m.X+dx*len(m.Slides), m.Y+dy*len(m.Slides)
+	if x < 0 || x >= p.cfg.Size || y < 0 || y >= p.cfg.Size {
+		return ErrSlideOffBoard
+	}
+	x, y = m.X, m.Y
+	for j := range m.Slides {
+		x += dx
+		y += dy
+		bit := uint64(1) << uint(SquareIndex(x, y, p.cfg.Size))
+		switch {
+		case p.Caps&bit != 0:
+			return ErrIllegalSlide
+		case p.Standing&bit != 0:
+			if j != len(m.Slides)-1 || m.Slides[j] != 1 || p.Caps&(1<<i) == 0 {
+				return ErrIllegalSlide
+			}
+		}
+	}
+	return nil
+}
+
 func (p *Position) MoveToAllocated(m *Move, next *Position) (*Position, error) {
+	if e := p.Validate(m); e != nil {
+		return nil, e
+	}
 	if next == nil {
 		next = alloc(p)
 	} else {
 		copyPosition(p, next)
 	}
+	if p.analyzed {
+		next.inheritAnalysis(p)
+	}
 	next.move++
 	var place Piece
 	dx, dy := 0, 0
@@ -79,18 +199,11 @@ func (p *Position) MoveToAllocated(m *Move, next *Position) (*Position, error) {
 	case SlideDown:
 		dy = -1
 	}
-	if p.move < 2 {
-		if place.Kind() != Flat {
-			return nil, ErrIllegalOpening
-		}
+	if p.SwapActive() {
 		place = MakePiece(place.Color().Flip(), place.Kind())
 	}
-	i := uint(m.X + m.Y*p.Size())
+	i := uint(SquareIndex(m.X, m.Y, p.Size()))
 	if place != 0 {
-		if (p.White|p.Black)&(1<<i) != 0 {
-			return nil, ErrOccupied
-		}
-
 		var stones *byte
 		switch place.Kind() {
 		case Capstone:
@@ -110,9 +223,6 @@ func (p *Position) MoveToAllocated(m *Move, next *Position) (*Position, error) {
 				stones = &next.whiteStones
 			}
 		}
-		if *stones <= 0 {
-			return nil, ErrNoCapstone
-		}
 		*stones--
 		if place.Color() == White {
 			next.White |= (1 << i)
@@ -120,7 +230,6 @@ func (p *Position) MoveToAllocated(m *Move, next *Position) (*Position, error) {
 			next.Black |= (1 << i)
 		}
 		next.Height[i]++
-		next.analyze()
 		return next, nil
 	}
 
@@ -128,15 +237,6 @@ func (p *Position) MoveToAllocated(m *Move, next *Position) (*Position, error) {
 	for _, c := range m.Slides {
 		ct += uint(c)
 	}
-	if ct > uint(p.cfg.Size) || ct < 1 || ct > uint(p.Height[i]) {
-		return nil, ErrIllegalSlide
-	}
-	if p.ToMove() == White && p.White&(1<<i) == 0 {
-		return nil, ErrIllegalSlide
-	}
-	if p.ToMove() == Black && p.Black&(1<<i) == 0 {
-		return nil, ErrIllegalSlide
-	}
 
 	top := p.Top(m.X, m.Y)
 	stack := p.Stacks[i] << 1
@@ -167,23 +267,8 @@ func (p *Position) MoveToAllocated(m *Move, next *Position) (*Position, error) {
 	for _, c := range m.Slides {
 		x += dx
 		y += dy
-		if x < 0 || x >= next.cfg.Size ||
-			y < 0 || y >= next.cfg.Size {
-			return nil, ErrIllegalSlide
-		}
-		if int(c) < 1 || uint(c) > ct {
-			return nil, ErrIllegalSlide
-		}
-		i = uint(x + y*p.Size())
-		switch {
-		case next.Caps&(1<<i) != 0:
-			return nil, ErrIllegalSlide
-		case next.Standing&(1<<i) != 0:
-			if ct != 1 || top.Kind() != Capstone {
-				return nil, ErrIllegalSlide
-			}
-			next.Standing &= ^(1 << i)
-		}
+		i = uint(SquareIndex(x, y, p.Size()))
+		next.Standing &= ^(1 << i)
 		next.hash ^= next.hashAt(i)
 		if next.White&(1<<i) != 0 {
 			next.Stacks[i] <<= 1
@@ -213,7 +298,6 @@ func (p *Position) MoveToAllocated(m *Move, next *Position) (*Position, error) {
 		}
 	}
 
-	next.analyze()
 	return next, nil
 }
 
@@ -240,7 +324,22 @@ func calculateSlides(stack int) [][]byte {
 	return out
 }
 
+// AllMoves appends the moves available to the player to move in p
+// to moves, and returns the result. Slides stop short of walls and
+// capstones, except that a capstone moving alone onto a wall, as the
+// last drop of a slide, flattens it, so every slide generated is
+// legal. Placements are not checked against the player's reserves.
 func (p *Position) AllMoves(moves []Move) []Move {
+	return p.AllMovesCarry(moves, 0)
+}
+
+// AllMovesCarry is like AllMoves, but only generates slides that
+// carry at most maxCarry stones, leaving out the rest of the drop
+// sequences of tall stacks, of which there are many. It is meant for
+// searches that trade strength for speed, and so may miss the best
+// move. A maxCarry of zero, or of at least the board size, generates
+// every move.
+func (p *Position) AllMovesCarry(moves []Move, maxCarry int) []Move {
 	next := p.ToMove()
 	cap := false
 	if next == White {
@@ -250,10 +349,10 @@ func (p *Position) AllMoves(moves []Move) []Move {
 	}
 	for x := 0; x < p.cfg.Size; x++ {
 		for y := 0; y < p.cfg.Size; y++ {
-			stack := p.At(x, y)
-			if len(stack) == 0 {
+			i := SquareIndex(x, y, p.cfg.Size)
+			if (p.White|p.Black)&(1<<uint(i)) == 0 {
 				moves = append(moves, Move{x, y, PlaceFlat, nil})
-				if p.move >= 2 {
+				if !p.SwapActive() {
 					moves = append(moves, Move{x, y, PlaceStanding, nil})
 					if cap {
 						moves = append(moves, Move{x, y, PlaceCapstone, nil})
@@ -261,10 +360,11 @@ func (p *Position) AllMoves(moves []Move) []Move {
 				}
 				continue
 			}
-			if p.move < 2 {
+			if p.SwapActive() {
 				continue
 			}
-			if stack[0].Color() != next {
+			top := p.Top(x, y)
+			if top.Color() != next {
 				continue
 			}
 			type dircnt struct {
@@ -277,15 +377,24 @@ func (p *Position) AllMoves(moves []Move) []Move {
 				{SlideDown, y},
 				{SlideUp, p.cfg.Size - y - 1},
 			}
+			h := int(p.Height[i])
+			if h > p.cfg.Size {
+				h = p.cfg.Size
+			}
+			if maxCarry > 0 && h > maxCarry {
+				h = maxCarry
+			}
+			capTop := top.Kind() == Capstone
 			for _, d := range dirs {
-				h := len(stack)
-				if h > p.cfg.Size {
-					h = p.cfg.Size
-				}
+				reach, flatten := p.slideReach(x, y, d.d, d.c, capTop)
 				for _, s := range slides[h] {
-					if len(s) <= d.c {
-						moves = append(moves, Move{x, y, d.d, s})
+					switch {
+					case len(s) <= reach:
+					case flatten && len(s) == reach+1 && s[reach] == 1:
+					default:
+						continue
 					}
+					moves = append(moves, Move{x, y, d.d, s})
 				}
 			}
 		}
@@ -293,3 +402,91 @@ func (p *Position) AllMoves(moves []Move) []Move {
 
 	return moves
 }
+
+// SortMoves sorts ms into a stable order, which does not depend on
+// how the moves were generated: placements before slides; then by
+// square, from a1 along each row in turn (a1, b1, ..., a2, ...);
+// then by move type, flats before walls before capstones, and
+// slides left, right, up and down; and last, slides by their drops,
+// in lexicographic order, so that 1a1> sorts before 2a1>11 before
+// 2a1>2. AllMoves makes no promise about its order, so callers
+// that need reproducible lists, across runs or versions, should
+// sort them.
+func SortMoves(ms []Move) {
+	sort.Sort(stableOrder(ms))
+}
+
+type stableOrder []Move
+
+func (o stableOrder) Len() int      { return len(o) }
+func (o stableOrder) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o stableOrder) Less(i, j int) bool {
+	a, b := &o[i], &o[j]
+	if sa, sb := a.Type >= SlideLeft, b.Type >= SlideLeft; sa != sb {
+		return sb
+	}
+	if a.Y != b.Y {
+		return a.Y < b.Y
+	}
+	if a.X != b.X {
+		return a.X < b.X
+	}
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return bytes.Compare(a.Slides, b.Slides) < 0
+}
+
+// slideReach returns how many of the n squares in direction dir from
+// (x, y) a stack can slide over before reaching a wall or capstone,
+// and whether the square it stops at holds a wall that a capstone on
+// top of the stack, as given by capTop, can flatten.
+func (p *Position) slideReach(x, y int, dir MoveType, n int, capTop bool) (int, bool) {
+	var dx, dy int
+	switch dir {
+	case SlideLeft:
+		dx = -1
+	case SlideRight:
+		dx = 1
+	case SlideUp:
+		dy = 1
+	case SlideDown:
+		dy = -1
+	}
+	for k := 0; k < n; k++ {
+		x += dx
+		y += dy
+		bit := uint64(1) << uint(SquareIndex(x, y, p.cfg.Size))
+		switch {
+		case p.Caps&bit != 0:
+			return k, false
+		case p.Standing&bit != 0:
+			return k, capTop
+		}
+	}
+	return n, false
+}
+
+// SlideOptions returns every legal drop sequence, as for Move.Slides,
+// for a slide of the stack at (x, y) in direction dir, which must be
+// one of the Slide move types. It returns nil if the stack cannot
+// move that way. The returned slices are shared and must not be
+// modified.
+func (p *Position) SlideOptions(x, y int, dir MoveType) [][]byte {
+	if x < 0 || x >= p.cfg.Size || y < 0 || y >= p.cfg.Size ||
+		dir < SlideLeft || dir > SlideDown {
+		return nil
+	}
+	h := int(p.Height[SquareIndex(x, y, p.cfg.Size)])
+	if h > p.cfg.Size {
+		h = p.cfg.Size
+	}
+	var out [][]byte
+	for _, s := range slides[h] {
+		m := Move{x, y, dir, s}
+		if p.Validate(&m) == nil {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -1,6 +1,7 @@
 package tak
 
 import (
+	"math/rand"
 	"reflect"
 	"sort"
 	"testing"
@@ -251,6 +252,37 @@ func TestAllMovesBasicSlides(t *testing.T) {
 	}
 }
 
+func TestAllMovesCarry(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 20
+	w, b := MakePiece(White, Flat), MakePiece(Black, Flat)
+	set(p, 2, 2, Square{w, b, w, b, w, b})
+	all := p.AllMoves(nil)
+	if got := p.AllMovesCarry(nil, 5); len(got) != len(all) {
+		t.Errorf("carry 5: %d moves, want all %d", len(got), len(all))
+	}
+	limited := p.AllMovesCarry(nil, 2)
+	// 24 empty squares, with three placements each, and in each
+	// of four directions, two squares to the edge: carrying up
+	// to five stones, 5 ways to drop them on one square and 10
+	// on two; carrying up to two, 2 ways and 1
+	if len(all) != 24*3+4*(5+10) || len(limited) != 24*3+4*(2+1) {
+		t.Errorf("all=%d moves, carrying at most 2=%d", len(all), len(limited))
+	}
+	for _, m := range limited {
+		carry := 0
+		for _, d := range m.Slides {
+			carry += int(d)
+		}
+		if carry > 2 {
+			t.Errorf("carry 2 generated %#v", m)
+		}
+		if _, e := p.Move(&m); e != nil {
+			t.Errorf("carry 2 generated illegal %#v: %v", m, e)
+		}
+	}
+}
+
 func TestEqual(t *testing.T) {
 	a := &Move{
 		X: 3, Y: 4, Type: SlideDown, Slides: []byte{3},
@@ -265,3 +297,315 @@ func TestEqual(t *testing.T) {
 		t.Errorf("%#v = %#v!", a, b)
 	}
 }
+
+func TestReserves(t *testing.T) {
+	type reserves struct{ ws, wc, bs, bc int }
+	p := New(Config{Size: 5})
+	steps := []struct {
+		m    Move
+		want reserves
+	}{
+		// the opening placements draw from the opponent's reserve
+		{Move{X: 0, Y: 0, Type: PlaceFlat}, reserves{21, 1, 20, 1}},
+		{Move{X: 4, Y: 4, Type: PlaceFlat}, reserves{20, 1, 20, 1}},
+		{Move{X: 2, Y: 2, Type: PlaceFlat}, reserves{19, 1, 20, 1}},
+		{Move{X: 2, Y: 3, Type: PlaceStanding}, reserves{19, 1, 19, 1}},
+		{Move{X: 1, Y: 2, Type: PlaceCapstone}, reserves{19, 0, 19, 1}},
+		{Move{X: 3, Y: 2, Type: PlaceFlat}, reserves{19, 0, 18, 1}},
+		// capturing a black flat credits no one's reserve
+		{Move{X: 2, Y: 2, Type: SlideRight, Slides: []byte{1}}, reserves{19, 0, 18, 1}},
+		{Move{X: 0, Y: 1, Type: PlaceFlat}, reserves{19, 0, 17, 1}},
+		// the capstone flattens the wall
+		{Move{X: 1, Y: 2, Type: SlideRight, Slides: []byte{1}}, reserves{19, 0, 17, 1}},
+		{Move{X: 1, Y: 2, Type: PlaceFlat}, reserves{19, 0, 16, 1}},
+		{Move{X: 2, Y: 2, Type: SlideUp, Slides: []byte{1}}, reserves{19, 0, 16, 1}},
+	}
+	for i, st := range steps {
+		next, e := p.Move(&st.m)
+		if e != nil {
+			t.Fatalf("%d: move: %v", i, e)
+		}
+		p = next
+		var got reserves
+		got.ws, got.wc = p.Reserves(White)
+		got.bs, got.bc = p.Reserves(Black)
+		if got != st.want {
+			t.Errorf("%d: reserves=%+v, want %+v", i, got, st.want)
+		}
+		if got.ws != p.WhiteStones() || got.wc != p.WhiteCaps() ||
+			got.bs != p.BlackStones() || got.bc != p.BlackCaps() {
+			t.Errorf("%d: accessors disagree with Reserves", i)
+		}
+	}
+
+	// every stone is either in reserve or on the board
+	var onBoard [2]int
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			for _, pc := range p.At(x, y) {
+				if pc.Kind() == Capstone {
+					continue
+				}
+				if pc.Color() == White {
+					onBoard[0]++
+				} else {
+					onBoard[1]++
+				}
+			}
+		}
+	}
+	if onBoard[0]+p.WhiteStones() != 21 || onBoard[1]+p.BlackStones() != 21 {
+		t.Errorf("board=%v reserves=%d,%d", onBoard, p.WhiteStones(), p.BlackStones())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	W, B := MakePiece(White, Flat), MakePiece(Black, Flat)
+	p := New(Config{Size: 5})
+	p.move = 4
+	set(p, 0, 0, Square{W, B})
+	set(p, 1, 0, Square{B})
+	set(p, 2, 1, Square{MakePiece(White, Capstone), B})
+	set(p, 1, 2, Square{W})
+	set(p, 2, 2, Square{MakePiece(Black, Standing)})
+	set(p, 3, 2, Square{W})
+	set(p, 3, 3, Square{MakePiece(Black, Capstone)})
+	set(p, 4, 4, Square{W, W, W, W, W, W})
+
+	noStones := p.Clone()
+	noStones.whiteStones = 0
+	noCaps := p.Clone()
+	noCaps.whiteCaps = 0
+	opening := New(Config{Size: 5})
+	opening.move = 1
+	set(opening, 0, 0, Square{B})
+
+	cases := []struct {
+		p    *Position
+		m    Move
+		want error
+	}{
+		{p, Move{2, 0, PlaceFlat, nil}, nil},
+		{p, Move{2, 1, SlideUp, []byte{1}}, nil},
+		{p, Move{0, 0, SlideUp, []byte{1, 1}}, nil},
+		{p, Move{0, 0, 0, nil}, ErrBadMoveType},
+		{p, Move{5, 0, PlaceFlat, nil}, ErrOffBoard},
+		{p, Move{0, -1, SlideUp, []byte{1}}, ErrOffBoard},
+		{p, Move{1, 0, PlaceFlat, nil}, ErrOccupied},
+		{noStones, Move{2, 0, PlaceStanding, nil}, ErrNoStones},
+		{noCaps, Move{2, 0, PlaceCapstone, nil}, ErrNoCapstone},
+		{opening, Move{2, 0, PlaceStanding, nil}, ErrIllegalOpening},
+		{opening, Move{0, 0, SlideUp, []byte{1}}, ErrIllegalOpening},
+		{p, Move{1, 0, SlideUp, []byte{1}}, ErrNotOwner},
+		{p, Move{3, 0, SlideUp, []byte{1}}, ErrNotOwner},
+		{p, Move{0, 0, SlideUp, []byte{1, 0}}, ErrDropZero},
+		{p, Move{0, 0, SlideUp, nil}, ErrDropZero},
+		{p, Move{4, 4, SlideDown, []byte{6}}, ErrCarryLimit},
+		{p, Move{0, 0, SlideUp, []byte{3}}, ErrStackHeight},
+		{p, Move{0, 0, SlideLeft, []byte{1}}, ErrSlideOffBoard},
+		{p, Move{4, 4, SlideDown, []byte{1, 1, 1, 1, 1}}, ErrSlideOffBoard},
+		{p, Move{1, 2, SlideRight, []byte{1}}, ErrIllegalSlide},
+		{p, Move{2, 1, SlideUp, []byte{2}}, ErrIllegalSlide},
+		{p, Move{2, 1, SlideUp, []byte{1, 1}}, ErrIllegalSlide},
+		{p, Move{3, 2, SlideUp, []byte{1}}, ErrIllegalSlide},
+	}
+	for _, tc := range cases {
+		if e := tc.p.Validate(&tc.m); e != tc.want {
+			t.Errorf("Validate(%v)=%v, want %v", tc.m, e, tc.want)
+		}
+		if _, e := tc.p.Move(&tc.m); e != tc.want {
+			t.Errorf("Move(%v)=%v, want %v", tc.m, e, tc.want)
+		}
+	}
+}
+
+func TestSwap(t *testing.T) {
+	p := New(Config{Size: 5})
+	if !p.SwapActive() {
+		t.Fatal("SwapActive()=false at the start")
+	}
+	for _, typ := range []MoveType{PlaceStanding, PlaceCapstone} {
+		if _, e := p.Move(&Move{0, 0, typ, nil}); e != ErrIllegalOpening {
+			t.Errorf("white %d: err=%v", typ, e)
+		}
+	}
+	p, e := p.Move(&Move{0, 0, PlaceFlat, nil})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sq := p.At(0, 0); len(sq) != 1 || sq[0] != MakePiece(Black, Flat) {
+		t.Errorf("white's first placement: %v", sq)
+	}
+	if p.WhiteStones() != 21 || p.BlackStones() != 20 {
+		t.Errorf("reserves W:%d B:%d", p.WhiteStones(), p.BlackStones())
+	}
+
+	if !p.SwapActive() {
+		t.Fatal("SwapActive()=false on black's first move")
+	}
+	for _, typ := range []MoveType{PlaceStanding, PlaceCapstone} {
+		if _, e := p.Move(&Move{1, 1, typ, nil}); e != ErrIllegalOpening {
+			t.Errorf("black %d: err=%v", typ, e)
+		}
+	}
+	if _, e := p.Move(&Move{0, 0, SlideUp, []byte{1}}); e != ErrIllegalOpening {
+		t.Errorf("black slide: err=%v", e)
+	}
+	p, e = p.Move(&Move{1, 1, PlaceFlat, nil})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sq := p.At(1, 1); len(sq) != 1 || sq[0] != MakePiece(White, Flat) {
+		t.Errorf("black's first placement: %v", sq)
+	}
+	if p.WhiteStones() != 20 || p.BlackStones() != 20 {
+		t.Errorf("reserves W:%d B:%d", p.WhiteStones(), p.BlackStones())
+	}
+
+	if p.SwapActive() {
+		t.Fatal("SwapActive()=true after the opening")
+	}
+	p, e = p.Move(&Move{2, 2, PlaceCapstone, nil})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sq := p.At(2, 2); len(sq) != 1 || sq[0] != MakePiece(White, Capstone) {
+		t.Errorf("white's second placement: %v", sq)
+	}
+}
+
+func TestSlideOptions(t *testing.T) {
+	W, B := MakePiece(White, Flat), MakePiece(Black, Flat)
+	p := New(Config{Size: 5})
+	p.move = 4
+	set(p, 0, 0, Square{W, B})
+	set(p, 1, 0, Square{B})
+	set(p, 2, 1, Square{MakePiece(White, Capstone), B})
+	set(p, 1, 2, Square{W})
+	set(p, 2, 2, Square{MakePiece(Black, Standing)})
+	set(p, 4, 4, Square{W, W, W, W, W, W})
+
+	cases := []struct {
+		x, y int
+		dir  MoveType
+		want [][]byte
+	}{
+		{0, 0, SlideUp, [][]byte{{1}, {1, 1}, {2}}},
+		{0, 0, SlideRight, [][]byte{{1}, {1, 1}, {2}}},
+		{0, 0, SlideLeft, nil},
+		// only the capstone alone may flatten the wall
+		{2, 1, SlideUp, [][]byte{{1}}},
+		{1, 2, SlideRight, nil},
+		{1, 0, SlideUp, nil},
+		{3, 3, SlideUp, nil},
+		{0, 0, PlaceFlat, nil},
+		{5, 0, SlideUp, nil},
+	}
+	for _, tc := range cases {
+		if got := p.SlideOptions(tc.x, tc.y, tc.dir); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SlideOptions(%d, %d, %d)=%v, want %v", tc.x, tc.y, tc.dir, got, tc.want)
+		}
+	}
+
+	// the options agree with the legal moves
+	count := make(map[MoveType]int)
+	for _, m := range p.AllMoves(nil) {
+		if m.Type >= SlideLeft && m.X == 4 && m.Y == 4 && p.Validate(&m) == nil {
+			count[m.Type]++
+		}
+	}
+	for _, dir := range []MoveType{SlideLeft, SlideRight, SlideUp, SlideDown} {
+		if got := len(p.SlideOptions(4, 4, dir)); got != count[dir] {
+			t.Errorf("SlideOptions(4, 4, %d): %d options, want %d", dir, got, count[dir])
+		}
+	}
+}
+
+func TestAllMovesCapstoneFlatten(t *testing.T) {
+	W, B := MakePiece(White, Flat), MakePiece(Black, Flat)
+	p := New(Config{Size: 5})
+	p.move = 10
+	set(p, 0, 2, Square{MakePiece(White, Capstone), W})
+	set(p, 1, 2, Square{MakePiece(Black, Standing)})
+	set(p, 2, 2, Square{W})
+	set(p, 3, 2, Square{W})
+	set(p, 4, 2, Square{W})
+	set(p, 0, 0, Square{W, W})
+	set(p, 1, 0, Square{MakePiece(Black, Standing)})
+	set(p, 4, 4, Square{W, B})
+	set(p, 3, 4, Square{MakePiece(Black, Capstone)})
+
+	var caps, flats, blocked [][]byte
+	for _, m := range p.AllMoves(nil) {
+		if m.Type < SlideLeft {
+			continue
+		}
+		if e := p.Validate(&m); e != nil {
+			t.Errorf("generated illegal move %#v: %v", m, e)
+		}
+		switch {
+		case m.X == 0 && m.Y == 2 && m.Type == SlideRight:
+			caps = append(caps, m.Slides)
+		case m.X == 0 && m.Y == 0 && m.Type == SlideRight:
+			flats = append(flats, m.Slides)
+		case m.X == 4 && m.Y == 4 && m.Type == SlideLeft:
+			blocked = append(blocked, m.Slides)
+		}
+	}
+	// only the capstone alone, as the last drop, may flatten
+	// the wall
+	if !reflect.DeepEqual(caps, [][]byte{{1}}) {
+		t.Errorf("capstone slides onto the wall: %v", caps)
+	}
+	if len(flats) != 0 {
+		t.Errorf("flat slides onto the wall: %v", flats)
+	}
+	if len(blocked) != 0 {
+		t.Errorf("slides onto a capstone: %v", blocked)
+	}
+
+	next, e := p.Move(&Move{0, 2, SlideRight, []byte{1}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if next.Top(1, 2) != MakePiece(White, Capstone) || len(next.At(1, 2)) != 2 {
+		t.Errorf("b3 after flattening: %v", next.At(1, 2))
+	}
+	if over, winner := next.GameOver(); !over || winner != White {
+		t.Errorf("flattening the wall: over=%v winner=%s, want a White road", over, winner)
+	}
+}
+
+func TestSortMoves(t *testing.T) {
+	p := New(Config{Size: 3})
+	p.move = 4
+	set(p, 0, 0, Square{MakePiece(White, Flat), MakePiece(Black, Flat)})
+	set(p, 2, 2, Square{MakePiece(Black, Flat)})
+
+	want := []Move{
+		{1, 0, PlaceFlat, nil}, {1, 0, PlaceStanding, nil},
+		{2, 0, PlaceFlat, nil}, {2, 0, PlaceStanding, nil},
+		{0, 1, PlaceFlat, nil}, {0, 1, PlaceStanding, nil},
+		{1, 1, PlaceFlat, nil}, {1, 1, PlaceStanding, nil},
+		{2, 1, PlaceFlat, nil}, {2, 1, PlaceStanding, nil},
+		{0, 2, PlaceFlat, nil}, {0, 2, PlaceStanding, nil},
+		{1, 2, PlaceFlat, nil}, {1, 2, PlaceStanding, nil},
+		{0, 0, SlideRight, []byte{1}},
+		{0, 0, SlideRight, []byte{1, 1}},
+		{0, 0, SlideRight, []byte{2}},
+		{0, 0, SlideUp, []byte{1}},
+		{0, 0, SlideUp, []byte{1, 1}},
+		{0, 0, SlideUp, []byte{2}},
+	}
+	ms := p.AllMoves(nil)
+	// the order must not depend on the order generated
+	r := rand.New(rand.NewSource(1))
+	for i := len(ms) - 1; i > 0; i-- {
+		j := r.Intn(i + 1)
+		ms[i], ms[j] = ms[j], ms[i]
+	}
+	SortMoves(ms)
+	if !reflect.DeepEqual(ms, want) {
+		t.Errorf("SortMoves:\n got %v\nwant %v", ms, want)
+	}
+}
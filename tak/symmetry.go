@@ -0,0 +1,116 @@
+package tak
+
+// A Symmetry is one of the eight rotations and reflections of the
+// board. Applying one to a position gives a position that is
+// equivalent for play: the same moves, transformed, are legal in
+// it, with the same results.
+type Symmetry uint8
+
+const (
+	symFlipX Symmetry = 1 << iota
+	symFlipY
+	symTranspose
+)
+
+const (
+	// Identity is the symmetry that leaves the board unchanged.
+	Identity Symmetry = 0
+	// NumSymmetries is the number of symmetries. They are
+	// numbered from 0, so `for s := Identity; s < NumSymmetries;
+	// s++` visits each of them.
+	NumSymmetries Symmetry = 8
+)
+
+// Inverse returns the symmetry that undoes s.
+func (s Symmetry) Inverse() Symmetry {
+	if s&symTranspose == 0 {
+		return s
+	}
+	// a flip applied after transposing is undone by
+	// transposing back after the opposite flip
+	inv := s &^ (symFlipX | symFlipY)
+	if s&symFlipX != 0 {
+		inv |= symFlipY
+	}
+	if s&symFlipY != 0 {
+		inv |= symFlipX
+	}
+	return inv
+}
+
+// Square returns the square (x, y) maps to under s, on a board of
+// the given size.
+func (s Symmetry) Square(x, y, size int) (int, int) {
+	if s&symTranspose != 0 {
+		x, y = y, x
+	}
+	if s&symFlipX != 0 {
+		x = size - 1 - x
+	}
+	if s&symFlipY != 0 {
+		y = size - 1 - y
+	}
+	return x, y
+}
+
+// Move returns m transformed by s, for a board of the given size.
+// The returned move shares m's Slides.
+func (s Symmetry) Move(m Move, size int) Move {
+	out := m
+	out.X, out.Y = s.Square(m.X, m.Y, size)
+	if m.Type < SlideLeft {
+		return out
+	}
+	var dx, dy int
+	switch m.Type {
+	case SlideLeft:
+		dx = -1
+	case SlideRight:
+		dx = 1
+	case SlideUp:
+		dy = 1
+	case SlideDown:
+		dy = -1
+	}
+	if s&symTranspose != 0 {
+		dx, dy = dy, dx
+	}
+	if s&symFlipX != 0 {
+		dx = -dx
+	}
+	if s&symFlipY != 0 {
+		dy = -dy
+	}
+	switch {
+	case dx < 0:
+		out.Type = SlideLeft
+	case dx > 0:
+		out.Type = SlideRight
+	case dy > 0:
+		out.Type = SlideUp
+	default:
+		out.Type = SlideDown
+	}
+	return out
+}
+
+// Transform returns a new position with the board of p transformed
+// by s, with the same reserves and the same player to move.
+func (p *Position) Transform(s Symmetry) *Position {
+	size := p.Size()
+	board := make([][]Square, size)
+	for y := range board {
+		board[y] = make([]Square, size)
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			tx, ty := s.Square(x, y, size)
+			board[ty][tx] = p.At(x, y)
+		}
+	}
+	out, e := FromSquares(*p.cfg, board, p.move)
+	if e != nil {
+		panic("Transform: " + e.Error())
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package tak
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSymmetryInverse(t *testing.T) {
+	for s := Identity; s < NumSymmetries; s++ {
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				tx, ty := s.Square(x, y, 5)
+				if ix, iy := s.Inverse().Square(tx, ty, 5); ix != x || iy != y {
+					t.Errorf("sym %d: (%d,%d) -> (%d,%d) -> (%d,%d)", s, x, y, tx, ty, ix, iy)
+				}
+			}
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for game := 0; game < 20; game++ {
+		p := New(Config{Size: 5})
+		for ply := 0; ply < 30; ply++ {
+			var legal []Move
+			for _, m := range p.AllMoves(nil) {
+				if _, e := p.Move(&m); e == nil {
+					legal = append(legal, m)
+				}
+			}
+			for s := Identity; s < NumSymmetries; s++ {
+				q := p.Transform(s)
+				if !same(q.Transform(s.Inverse()), p) {
+					t.Fatalf("game %d ply %d sym %d: inverse does not restore position", game, ply, s)
+				}
+				for _, m := range legal {
+					child, _ := p.Move(&m)
+					tm := s.Move(m, p.Size())
+					qc, e := q.Move(&tm)
+					if e != nil {
+						t.Fatalf("game %d ply %d sym %d: %#v -> %#v: %v", game, ply, s, m, tm, e)
+					}
+					if !same(qc, child.Transform(s)) {
+						t.Fatalf("game %d ply %d sym %d: %#v -> %#v: positions differ", game, ply, s, m, tm)
+					}
+				}
+			}
+			m := legal[r.Intn(len(legal))]
+			p, _ = p.Move(&m)
+			if over, _ := p.GameOver(); over {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package tak
+
+import "github.com/nelhage/taktician/bitboard"
+
+// HasRoadThreat reports whether c could complete a road by placing
+// a single stone on an empty square, if it were c's turn. It is a
+// one-ply check and ignores roads completed by moving a stack; see
+// RoadCompletions for those.
+func (p *Position) HasRoadThreat(c Color) bool {
+	return p.roadPlacements(c) != 0
+}
+
+// roadPlacements returns the empty squares on which c could complete
+// a road by placing a flat or capstone.
+func (p *Position) roadPlacements(c Color) uint64 {
+	if p.move < 2 {
+		return 0
+	}
+	if c == NoColor {
+		return 0
+	}
+	if stones, caps := p.Reserves(c); stones+caps == 0 {
+		return 0
+	}
+	cs := &p.cfg.c
+	road := p.RoadMask(c)
+	cands := p.emptyNeighbors(road)
+	var out uint64
+	for cands != 0 {
+		next := cands & (cands - 1)
+		bit := cands &^ next
+		if bitboard.HasRoad(cs, bitboard.Flood(cs, road|bit, bit)) {
+			out |= bit
+		}
+		cands = next
+	}
+	return out
+}
+
+// emptyNeighbors returns the empty squares next to any of bits.
+func (p *Position) emptyNeighbors(bits uint64) uint64 {
+	empty := p.cfg.c.Mask &^ (p.White | p.Black)
+	return bitboard.Grow(&p.cfg.c, empty, bits) &^ bits
+}
+
+// BridgeMask returns the empty squares on which a flat of c's would
+// join two or more of c's separate road groups, as a bitboard. Lone
+// stones count as groups.
+func (p *Position) BridgeMask(c Color) uint64 {
+	road := p.RoadMask(c)
+	cs := &p.cfg.c
+	cands := p.emptyNeighbors(road)
+	var out uint64
+	for cands != 0 {
+		next := cands & (cands - 1)
+		bit := cands &^ next
+		adj := bitboard.Grow(cs, road, bit) &^ bit
+		first := adj &^ (adj - 1)
+		if adj&^bitboard.Flood(cs, road, first) != 0 {
+			out |= bit
+		}
+		cands = next
+	}
+	return out
+}
+
+// Bridges returns the squares of BridgeMask, as indexes given by
+// SquareIndex.
+func (p *Position) Bridges(c Color) []int {
+	var out []int
+	for bits, i := p.BridgeMask(c), 0; bits != 0; bits, i = bits>>1, i+1 {
+		if bits&1 != 0 {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// RoadCompletions returns every move that would complete a road for
+// c, if it were c's turn: placements of a flat or capstone that
+// connect a road, and slides that extend or join c's groups into
+// one. Moves that complete roads for both players are included,
+// since they win for the player who makes them.
+func (p *Position) RoadCompletions(c Color) []Move {
+	if p.move < 2 || c == NoColor {
+		return nil
+	}
+	var out []Move
+	stones, caps := p.Reserves(c)
+	sq := p.roadPlacements(c)
+	for i := 0; sq != 0; i++ {
+		if sq&(1<<uint(i)) == 0 {
+			continue
+		}
+		sq &^= 1 << uint(i)
+		x, y := Coords(i, p.cfg.Size)
+		if stones > 0 {
+			out = append(out, Move{X: x, Y: y, Type: PlaceFlat})
+		}
+		if caps > 0 {
+			out = append(out, Move{X: x, Y: y, Type: PlaceCapstone})
+		}
+	}
+
+	q := p
+	if p.ToMove() != c {
+		q = p.Clone()
+		q.move++
+	}
+	var child *Position
+	for _, m := range q.AllMoves(nil) {
+		if m.Type < SlideLeft {
+			continue
+		}
+		var e error
+		child, e = q.MoveToAllocated(&m, child)
+		if e != nil {
+			continue
+		}
+		if child.hasRoadFor(c) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// IsTak reports whether the player who just moved threatens to
+// complete a road with their next move, so that the player to move
+// must respond.
+func (p *Position) IsTak() bool {
+	c := p.ToMove().Flip()
+	return p.HasRoadThreat(c) || len(p.RoadCompletions(c)) > 0
+}
+
+// MoveThreats reports how m changes the road threats on the board:
+// created lists the RoadCompletions the player making m has after it
+// that they did not have before, and blocked those the opponent had
+// before m that they no longer have. It returns the error from Move
+// if m is illegal.
+func (p *Position) MoveThreats(m *Move) (created, blocked []Move, err error) {
+	next, err := p.Move(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	me, them := p.ToMove(), p.ToMove().Flip()
+	created = newMoves(next.RoadCompletions(me), p.RoadCompletions(me))
+	blocked = newMoves(p.RoadCompletions(them), next.RoadCompletions(them))
+	return created, blocked, nil
+}
+
+// newMoves returns the moves of ms that are not in old.
+func newMoves(ms, old []Move) []Move {
+	var out []Move
+	for i := range ms {
+		if !containsMove(old, &ms[i]) {
+			out = append(out, ms[i])
+		}
+	}
+	return out
+}
+
+func containsMove(ms []Move, m *Move) bool {
+	for i := range ms {
+		if ms[i].Equal(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoadDistance estimates how far c is from completing a road: the
+// fewest squares c must still claim on any path between opposite
+// edges of the board, where c's flats and capstones cost nothing, and
+// empty squares and the opponent's flats, which c could cover, cost
+// one each. Standing stones and the opponent's capstones block a
+// road. It returns 0 if c has a road, and -1 if every road is
+// blocked.
+func (p *Position) RoadDistance(c Color) int {
+	var theirs uint64
+	switch c {
+	case White:
+		theirs = p.Black
+	case Black:
+		theirs = p.White
+	default:
+		return -1
+	}
+	cs := &p.cfg.c
+	road := p.RoadMask(c)
+	open := cs.Mask &^ (road | p.Standing | (theirs & p.Caps))
+	best := -1
+	for _, d := range []int{
+		roadDistance(cs, road, open, cs.L, cs.R),
+		roadDistance(cs, road, open, cs.B, cs.T),
+	} {
+		if d >= 0 && (best < 0 || d < best) {
+			best = d
+		}
+	}
+	return best
+}
+
+// roadDistance returns the fewest squares of open that connect the
+// edge from to the edge to, along with the squares of road, or -1 if
+// they cannot be connected.
+func roadDistance(cs *bitboard.Constants, road, open, from, to uint64) int {
+	reached := bitboard.Flood(cs, road, from&road)
+	for d := 0; ; d++ {
+		if reached&to != 0 {
+			return d
+		}
+		// the open squares one step further: those next to
+		// what we have reached, or on the starting edge
+		next := (bitboard.Grow(cs, open|reached, reached) | from) & open &^ reached
+		if next == 0 {
+			return -1
+		}
+		reached = bitboard.Flood(cs, road|reached|next, reached|next)
+	}
+}
@@ -0,0 +1,241 @@
+package tak
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHasRoadThreat(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 11
+	for x := 0; x < 4; x++ {
+		set(p, x, 2, Square{MakePiece(White, Flat)})
+	}
+	if !p.HasRoadThreat(White) {
+		t.Errorf("four in a row: no white threat")
+	}
+	if p.HasRoadThreat(Black) {
+		t.Errorf("four in a row: black threat")
+	}
+	if !p.IsTak() {
+		t.Errorf("black to move: IsTak()=false")
+	}
+	p.move = 10
+	if p.IsTak() {
+		t.Errorf("white to move: IsTak()=true")
+	}
+
+	set(p, 4, 2, Square{MakePiece(Black, Standing)})
+	if p.HasRoadThreat(White) {
+		t.Errorf("blocked by wall: white threat")
+	}
+
+	// a gap in the middle of the row is also a threat
+	set(p, 4, 2, Square{MakePiece(White, Flat)})
+	set(p, 2, 2, nil)
+	if !p.HasRoadThreat(White) {
+		t.Errorf("gap: no white threat")
+	}
+	// but not if the gap is covered by a wall
+	set(p, 2, 2, Square{MakePiece(White, Standing)})
+	if p.HasRoadThreat(White) {
+		t.Errorf("own wall: white threat")
+	}
+
+	p.whiteStones, p.whiteCaps = 0, 0
+	set(p, 2, 2, nil)
+	if p.HasRoadThreat(White) {
+		t.Errorf("empty reserve: white threat")
+	}
+}
+
+func hasMove(ms []Move, m Move) bool {
+	for i := range ms {
+		if ms[i].Equal(&m) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRoadCompletions(t *testing.T) {
+	W, B := MakePiece(White, Flat), MakePiece(Black, Flat)
+
+	// placements, checked from the opponent's turn
+	p := New(Config{Size: 5})
+	p.move = 11
+	for x := 0; x < 4; x++ {
+		set(p, x, 2, Square{W})
+	}
+	got := p.RoadCompletions(White)
+	want := []Move{
+		{X: 4, Y: 2, Type: PlaceFlat},
+		{X: 4, Y: 2, Type: PlaceCapstone},
+	}
+	if len(got) != len(want) {
+		t.Errorf("placements: got %v, want %v", got, want)
+	}
+	for _, m := range want {
+		if !hasMove(got, m) {
+			t.Errorf("placements: missing %v", m)
+		}
+	}
+	if p.ToMove() != Black {
+		t.Errorf("RoadCompletions changed the player to move")
+	}
+	if len(p.RoadCompletions(Black)) != 0 {
+		t.Errorf("black completions: %v", p.RoadCompletions(Black))
+	}
+
+	// a slide that extends the group onto an occupied square
+	set(p, 4, 2, Square{B})
+	set(p, 4, 1, Square{W, B})
+	set(p, 3, 1, Square{MakePiece(Black, Standing)})
+	got = p.RoadCompletions(White)
+	want = []Move{
+		{X: 4, Y: 1, Type: SlideUp, Slides: []byte{1}},
+		{X: 4, Y: 1, Type: SlideUp, Slides: []byte{2}},
+	}
+	if len(got) != len(want) {
+		t.Errorf("extend: got %v, want %v", got, want)
+	}
+	for _, m := range want {
+		if !hasMove(got, m) {
+			t.Errorf("extend: missing %v", m)
+		}
+	}
+	if !p.IsTak() {
+		t.Errorf("extend: IsTak()=false")
+	}
+	if p.HasRoadThreat(White) {
+		t.Errorf("extend: HasRoadThreat counts slides")
+	}
+
+	// slides that join two groups
+	p = New(Config{Size: 5})
+	p.move = 10
+	set(p, 0, 2, Square{W})
+	set(p, 1, 2, Square{W})
+	set(p, 2, 2, Square{B})
+	set(p, 3, 2, Square{W})
+	set(p, 4, 2, Square{W})
+	set(p, 2, 1, Square{W})
+	set(p, 2, 3, Square{W})
+	got = p.RoadCompletions(White)
+	want = []Move{
+		{X: 2, Y: 1, Type: SlideUp, Slides: []byte{1}},
+		{X: 2, Y: 3, Type: SlideDown, Slides: []byte{1}},
+	}
+	if len(got) != len(want) {
+		t.Errorf("join: got %v, want %v", got, want)
+	}
+	for _, m := range want {
+		if !hasMove(got, m) {
+			t.Errorf("join: missing %v", m)
+		}
+	}
+}
+
+func TestRoadDistance(t *testing.T) {
+	p := New(Config{Size: 5})
+	if w, b := p.RoadDistance(White), p.RoadDistance(Black); w != 5 || b != 5 {
+		t.Errorf("empty board: distance W:%d B:%d", w, b)
+	}
+	for x := 0; x < 4; x++ {
+		set(p, x, 2, Square{MakePiece(White, Flat)})
+	}
+	if d := p.RoadDistance(White); d != 1 {
+		t.Errorf("four in a row: distance %d", d)
+	}
+	// Black can still cover a white flat
+	if d := p.RoadDistance(Black); d != 5 {
+		t.Errorf("black across four: distance %d", d)
+	}
+	set(p, 4, 2, Square{MakePiece(Black, Standing)})
+	if d := p.RoadDistance(White); d != 2 {
+		t.Errorf("around a wall: distance %d", d)
+	}
+	set(p, 4, 2, Square{MakePiece(White, Capstone)})
+	if d := p.RoadDistance(White); d != 0 {
+		t.Errorf("road: distance %d", d)
+	}
+	if w, b := p.FlatCount(); w != 4 || b != 0 {
+		t.Errorf("FlatCount()=(%d, %d)", w, b)
+	}
+
+	p = New(Config{Size: 5})
+	for i := 0; i < 5; i++ {
+		set(p, i, 2, Square{MakePiece(Black, Standing)})
+		set(p, 2, i, Square{MakePiece(Black, Standing)})
+	}
+	if w, b := p.RoadDistance(White), p.RoadDistance(Black); w != -1 || b != -1 {
+		t.Errorf("walled off: distance W:%d B:%d", w, b)
+	}
+}
+
+func TestBridges(t *testing.T) {
+	W := MakePiece(White, Flat)
+	p := New(Config{Size: 5})
+	p.move = 10
+	set(p, 0, 0, Square{W})
+	set(p, 0, 1, Square{W})
+	set(p, 2, 0, Square{W})
+	set(p, 2, 1, Square{W})
+	// lone stones count as groups
+	set(p, 4, 4, Square{W})
+	set(p, 4, 2, Square{W})
+	// but walls do not
+	set(p, 2, 3, Square{MakePiece(White, Standing)})
+	set(p, 1, 4, Square{W})
+	set(p, 3, 4, Square{W})
+	// an occupied square is no bridge
+	set(p, 2, 4, Square{MakePiece(Black, Flat)})
+
+	got := p.Bridges(White)
+	want := []int{1, 6, 19}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Bridges(White)=%v, want %v", got, want)
+	}
+	if b := p.Bridges(Black); len(b) != 0 {
+		t.Errorf("Bridges(Black)=%v", b)
+	}
+}
+
+func TestMoveThreats(t *testing.T) {
+	W, B := MakePiece(White, Flat), MakePiece(Black, Flat)
+	p := New(Config{Size: 5})
+	p.move = 10
+	// White has a1-c1; Black threatens to finish a5-d5 at e5
+	for x := 0; x < 3; x++ {
+		set(p, x, 0, Square{W})
+	}
+	for x := 0; x < 4; x++ {
+		set(p, x, 4, Square{B})
+	}
+
+	created, blocked, err := p.MoveThreats(&Move{X: 3, Y: 0, Type: PlaceFlat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Move{{X: 4, Y: 0, Type: PlaceFlat}, {X: 4, Y: 0, Type: PlaceCapstone}}; !reflect.DeepEqual(created, want) {
+		t.Errorf("d1: created %v, want %v", created, want)
+	}
+	if len(blocked) != 0 {
+		t.Errorf("d1: blocked %v", blocked)
+	}
+
+	created, blocked, err = p.MoveThreats(&Move{X: 4, Y: 4, Type: PlaceStanding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 0 {
+		t.Errorf("Se5: created %v", created)
+	}
+	if want := []Move{{X: 4, Y: 4, Type: PlaceFlat}, {X: 4, Y: 4, Type: PlaceCapstone}}; !reflect.DeepEqual(blocked, want) {
+		t.Errorf("Se5: blocked %v, want %v", blocked, want)
+	}
+
+	if _, _, err := p.MoveThreats(&Move{X: 0, Y: 0, Type: PlaceFlat}); err != ErrOccupied {
+		t.Errorf("illegal move: err=%v", err)
+	}
+}
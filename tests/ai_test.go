@@ -2,6 +2,7 @@ package tests
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -18,6 +19,7 @@ import (
 
 var debug = flag.Int("debug", 0, "debug level")
 var dumpPerf = flag.Bool("debug-perf", false, "debug perf")
+var lmr = flag.Bool("lmr", false, "enable late-move reductions")
 
 type TestCase struct {
 	p          *ptn.PTN
@@ -29,6 +31,7 @@ type TestCase struct {
 	cfg ai.MinimaxConfig
 
 	maxEval   uint64
+	minValue  *int64
 	badMoves  []tak.Move
 	goodMoves []tak.Move
 
@@ -64,6 +67,7 @@ func preparePTN(p *ptn.PTN) (*TestCase, error) {
 		limit: time.Minute,
 	}
 	var e error
+	limited := false
 	for _, t := range p.Tags {
 		if t.Value == "" {
 			continue
@@ -90,6 +94,17 @@ func preparePTN(p *ptn.PTN) (*TestCase, error) {
 			if e != nil {
 				return nil, fmt.Errorf("bad MaxEval: %s", t.Value)
 			}
+		case "MinValue":
+			v, e := strconv.ParseInt(t.Value, 10, 64)
+			if e != nil {
+				return nil, fmt.Errorf("bad MinValue: %s", t.Value)
+			}
+			tc.minValue = &v
+		case "Singular":
+			tc.cfg.Singular, e = strconv.ParseBool(t.Value)
+			if e != nil {
+				return nil, fmt.Errorf("bad Singular: %s", t.Value)
+			}
 		case "Depth":
 			tc.cfg.Depth, e = strconv.Atoi(t.Value)
 			if e != nil {
@@ -112,19 +127,37 @@ func preparePTN(p *ptn.PTN) (*TestCase, error) {
 			if e != nil {
 				return nil, fmt.Errorf("bad limit: `%s`: %v", t.Value, e)
 			}
+			limited = true
 		case "Seed":
 			tc.cfg.Seed, e = strconv.ParseInt(t.Value, 10, 64)
 			if e != nil {
-				return nil, fmt.Errorf("bad MaxEval: %s", t.Value)
+				return nil, fmt.Errorf("bad Seed: %s", t.Value)
 			}
 		case "Speed":
 			tc.speed = t.Value
+		case "Weights":
+			// JSON, applied over ai.DefaultWeights
+			w := ai.DefaultWeights
+			if e = json.Unmarshal([]byte(t.Value), &w); e != nil {
+				return nil, fmt.Errorf("bad Weights: `%s`: %v", t.Value, e)
+			}
+			tc.cfg.Weights = &w
 		case "Id":
 			tc.id = t.Value
 		case "Name":
 			tc.name = t.Value
 		}
 	}
+	if tc.maxEval != 0 {
+		// Search reproducibly, so that MaxEval can be exact;
+		// see ai.MinimaxConfig.Deterministic.
+		if tc.cfg.Seed == 0 {
+			tc.cfg.Deterministic = true
+		}
+		if !limited {
+			tc.limit = 0
+		}
+	}
 	return &tc, nil
 }
 
@@ -146,6 +179,7 @@ func runTest(t *testing.T, tc *TestCase) {
 	cfg := tc.cfg
 	cfg.Size = p.Size()
 	cfg.Debug = *debug
+	cfg.LMR = *lmr
 	ai := ai.NewMinimax(cfg)
 	start := time.Now()
 	pv, v, st := ai.Analyze(p, tc.limit)
@@ -183,8 +217,26 @@ func runTest(t *testing.T, tc *TestCase) {
 	if len(tc.goodMoves) != 0 && !found {
 		t.Errorf("!! %s is not an allowed good move", ptn.FormatMove(&pv[0]))
 	}
+	if tc.minValue != nil && v < *tc.minValue {
+		t.Errorf("!! %s: value %d < %d", name, v, *tc.minValue)
+	}
 	if tc.maxEval != 0 && st.Evaluated > tc.maxEval {
 		t.Errorf("!! %s: evaluated %d > %d positions",
 			name, st.Evaluated, tc.maxEval)
 	}
+	if tc.maxEval != 0 && tc.limit == 0 {
+		checkReproducible(t, name, cfg, p, pv, st)
+	}
+}
+
+// checkReproducible repeats a search with a fresh engine, and fails
+// if it does not evaluate as many positions and choose the same move.
+func checkReproducible(t *testing.T, name string, cfg ai.MinimaxConfig,
+	p *tak.Position, pv []tak.Move, st ai.Stats) {
+	again, _, st2 := ai.NewMinimax(cfg).Analyze(p, 0)
+	if st2.Evaluated != st.Evaluated || !again[0].Equal(&pv[0]) {
+		t.Errorf("!! %s: not reproducible: evaluated %d then %d, pv %s then %s",
+			name, st.Evaluated, st2.Evaluated,
+			ptn.FormatMove(&pv[0]), ptn.FormatMove(&again[0]))
+	}
 }
@@ -1,12 +1,20 @@
 package tests
 
 import (
+	"encoding/csv"
+	"flag"
+	"os"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/nelhage/taktician/ai"
 	"github.com/nelhage/taktician/ptn"
 	"github.com/nelhage/taktician/tak"
 )
 
+var suiteOut = flag.String("suite-out", "", "write a CSV summary of BenchmarkSuite to this file")
+
 func BenchmarkMoveEmpty(b *testing.B) {
 	p := tak.New(tak.Config{Size: 5})
 	n := tak.New(tak.Config{Size: 5})
@@ -41,3 +49,140 @@ func BenchmarkMoveComplex(b *testing.B) {
 		}
 	}
 }
+
+type suiteResult struct {
+	key string
+
+	// depth, evaluated, and elapsed measure the search up to the
+	// iteration from which the best move stayed acceptable
+	solved    bool
+	depth     int
+	evaluated uint64
+	elapsed   time.Duration
+
+	totalEvaluated uint64
+	totalElapsed   time.Duration
+}
+
+// accepts reports whether m is an acceptable answer for tc: one of
+// its good moves if it has any, and otherwise none of its bad moves.
+func (tc *TestCase) accepts(m *tak.Move) bool {
+	if len(tc.goodMoves) != 0 {
+		for i := range tc.goodMoves {
+			if m.Equal(&tc.goodMoves[i]) {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range tc.badMoves {
+		if m.Equal(&tc.badMoves[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func runSuiteCase(tc *TestCase) (*suiteResult, error) {
+	p, e := tc.p.PositionAtMove(tc.moveNumber, tc.color)
+	if e != nil {
+		return nil, e
+	}
+	r := &suiteResult{key: tc.id}
+	if r.key == "" {
+		r.key = tc.name
+	}
+	start := time.Now()
+	cfg := tc.cfg
+	cfg.Size = p.Size()
+	cfg.Deterministic = true
+	cfg.OnIteration = func(depth int, v int64, pv []tak.Move, st ai.Stats) {
+		r.totalEvaluated += st.Evaluated
+		if len(pv) == 0 || !tc.accepts(&pv[0]) {
+			r.solved = false
+			return
+		}
+		if r.solved {
+			return
+		}
+		r.solved = true
+		r.depth = depth
+		r.evaluated = r.totalEvaluated
+		r.elapsed = time.Since(start)
+	}
+	ai.NewMinimax(cfg).Analyze(p, tc.limit)
+	r.totalElapsed = time.Since(start)
+	return r, nil
+}
+
+// BenchmarkSuite searches every position in the AI regression suite,
+// and measures the work needed to settle on the expected move. Run
+// with -suite-out to write a per-position CSV summary, keyed by the
+// PTN Id tag (or Name, if there is none), for comparing commits.
+func BenchmarkSuite(b *testing.B) {
+	ptns, e := readPTNs("data/ai")
+	if e != nil {
+		b.Fatal(e)
+	}
+	var cases []*TestCase
+	for _, p := range ptns {
+		tc, e := preparePTN(p)
+		if e != nil {
+			b.Fatalf("prepare ptn: %v", e)
+		}
+		cases = append(cases, tc)
+	}
+
+	var results []*suiteResult
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results = results[:0]
+		for _, tc := range cases {
+			r, e := runSuiteCase(tc)
+			if e != nil {
+				b.Fatalf("%s: %v", tc.name, e)
+			}
+			results = append(results, r)
+		}
+	}
+	b.StopTimer()
+
+	var solved int
+	var evaluated uint64
+	for _, r := range results {
+		if r.solved {
+			solved++
+		}
+		evaluated += r.totalEvaluated
+	}
+	b.Logf("solved=%d/%d evaluated=%d", solved, len(results), evaluated)
+
+	if *suiteOut == "" {
+		return
+	}
+	f, e := os.Create(*suiteOut)
+	if e != nil {
+		b.Fatal(e)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{
+		"id", "solved", "depth", "evaluated", "elapsed_us",
+		"total_evaluated", "total_elapsed_us",
+	})
+	for _, r := range results {
+		w.Write([]string{
+			r.key,
+			strconv.FormatBool(r.solved),
+			strconv.Itoa(r.depth),
+			strconv.FormatUint(r.evaluated, 10),
+			strconv.FormatInt(int64(r.elapsed/time.Microsecond), 10),
+			strconv.FormatUint(r.totalEvaluated, 10),
+			strconv.FormatInt(int64(r.totalElapsed/time.Microsecond), 10),
+		})
+	}
+	w.Flush()
+	if e := w.Error(); e != nil {
+		b.Fatal(e)
+	}
+}
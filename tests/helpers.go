@@ -1,38 +1,19 @@
 package tests
 
 import (
-	"io/ioutil"
 	"log"
-	"os"
-	"path"
-	"strings"
 
 	"github.com/nelhage/taktician/ptn"
 )
 
+// readPTNs reads the games under d, logging any it can't parse.
 func readPTNs(d string) ([]*ptn.PTN, error) {
-	ents, e := ioutil.ReadDir(d)
-	if e != nil {
-		return nil, e
-	}
-	var out []*ptn.PTN
-	for _, de := range ents {
-		if !strings.HasSuffix(de.Name(), ".ptn") {
-			continue
-		}
-		f, e := os.Open(path.Join(d, de.Name()))
-		if e != nil {
-			log.Printf("open(%s): %v", de.Name(), e)
-			continue
-		}
-		g, e := ptn.ParsePTN(f)
-		if e != nil {
-			log.Printf("parse(%s): %v", de.Name(), e)
-			f.Close()
-			continue
+	ptns, e := ptn.ReadDir(d)
+	if de, ok := e.(ptn.DirError); ok {
+		for _, fe := range de {
+			log.Print(fe)
 		}
-		f.Close()
-		out = append(out, g)
+		e = nil
 	}
-	return out, nil
+	return ptns, e
 }